@@ -4,22 +4,124 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"os"
+	"runtime"
 )
 
-func ffmpegRunner() LinkedFFmpegRunner {
-	return NewLinkedFFmpegRunner(LinkedFFmpegModeHidden)
+const (
+	ffmpegModeEnv = "PODHNOLOGIC_FFMPEG_MODE"
+	ffmpegPathEnv = "PODHNOLOGIC_FFMPEG_PATH"
+)
+
+// FFmpegBackend runs ffmpeg and ffprobe for the converter. LinkedFFmpegRunner
+// is the real implementation; tests substitute a fake.
+type FFmpegBackend interface {
+	FFmpeg(ctx context.Context, args ...string) (LinkedFFmpegResult, error)
+	FFprobe(ctx context.Context, args ...string) (LinkedFFmpegResult, error)
+}
+
+// concurrencyLimiter is implemented by backends that run fewer conversions at
+// once than there are CPUs.
+type concurrencyLimiter interface {
+	Concurrency() int
+}
+
+// conversionWorkers is how many conversions run at once: one per CPU, or
+// fewer when the backend can only run fewer, so queued conversions do not use
+// up their timeouts waiting for it.
+func conversionWorkers(backend FFmpegBackend) int {
+	workers := runtime.NumCPU()
+	if limiter, ok := backend.(concurrencyLimiter); ok {
+		if n := limiter.Concurrency(); n > 0 && n < workers {
+			workers = n
+		}
+	}
+	return workers
+}
+
+// ffmpegRunnerFor builds the runner the settings ask for. Flags override the
+// environment, which overrides the saved configuration.
+func ffmpegRunnerFor(config Config, getenv func(string) string) (LinkedFFmpegRunner, error) {
+	mode, path := config.FFmpegMode, config.FFmpegPath
+	if env := getenv(ffmpegModeEnv); env != "" {
+		mode = env
+	}
+	if env := getenv(ffmpegPathEnv); env != "" {
+		path = env
+	}
+	if *ffmpegModeFlag != "" {
+		mode = *ffmpegModeFlag
+	}
+	if *ffmpegPathFlag != "" {
+		path = *ffmpegPathFlag
+	}
+
+	parsed, err := ParseLinkedFFmpegMode(mode)
+	if err != nil {
+		return LinkedFFmpegRunner{}, err
+	}
+	runner := NewLinkedFFmpegRunner(parsed)
+	runner.FFmpegPath = path
+	return runner.Resolve(), nil
 }
 
-func runFFmpeg(args []string) ([]byte, error) {
-	result, err := ffmpegRunner().FFmpeg(context.Background(), args...)
+// conversionBackend selects the FFmpeg that conversions run with. A system
+// FFmpeg is checked first, since its version and encoders vary, unless
+// nothing will be converted.
+func conversionBackend(config Config, dryRun bool) (FFmpegBackend, error) {
+	runner, err := ffmpegRunnerFor(config, os.Getenv)
+	if err != nil {
+		return nil, err
+	}
+	if runner.Mode == LinkedFFmpegModeSystem && !dryRun {
+		if err := checkSystemFFmpeg(runner, config); err != nil {
+			return nil, err
+		}
+	}
+	limits, err := linkedFFmpegLimitsFor(config)
+	if err != nil {
+		return nil, err
+	}
+	if !limits.IsZero() {
+		if runner.Mode != LinkedFFmpegModeHidden {
+			return nil, fmt.Errorf("cpu_limit and memory_limit apply to hidden FFmpeg mode, not %s; add --ffmpeg-mode hidden or drop the limits", runner.Mode)
+		}
+		if err := checkLinkedFFmpegLimits(limits); err != nil {
+			return nil, err
+		}
+	}
+	if runner.Mode == LinkedFFmpegModeHidden {
+		runner.Pool = NewLinkedFFmpegPool(runtime.NumCPU(), DefaultLinkedFFmpegPoolJobs)
+		runner.Pool.Limits = limits
+	}
+	return runner, nil
+}
+
+// closeFFmpegBackend stops any bridge workers the backend keeps running.
+func closeFFmpegBackend(backend FFmpegBackend) {
+	if runner, ok := backend.(LinkedFFmpegRunner); ok && runner.Pool != nil {
+		runner.Pool.Close()
+	}
+}
+
+func runFFmpeg(backend FFmpegBackend, args []string) ([]byte, error) {
+	return runFFmpegContext(context.Background(), backend, args)
+}
+
+func runFFmpegContext(ctx context.Context, backend FFmpegBackend, args []string) ([]byte, error) {
+	result, err := backend.FFmpeg(ctx, args...)
 	if err != nil {
 		return combinedFFmpegOutput(result), err
 	}
 	return combinedFFmpegOutput(result), nil
 }
 
-func runFFprobe(args []string) (LinkedFFmpegResult, error) {
-	return ffmpegRunner().FFprobe(context.Background(), args...)
+func runFFprobe(backend FFmpegBackend, args []string) (LinkedFFmpegResult, error) {
+	return runFFprobeContext(context.Background(), backend, args)
+}
+
+func runFFprobeContext(ctx context.Context, backend FFmpegBackend, args []string) (LinkedFFmpegResult, error) {
+	return backend.FFprobe(ctx, args...)
 }
 
 func combinedFFmpegOutput(result LinkedFFmpegResult) []byte {
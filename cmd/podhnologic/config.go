@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// configField is one Config setting addressed by its JSON key.
+type configField struct {
+	Key   string
+	Index int
+}
+
+func configFields() []configField {
+	t := reflect.TypeOf(Config{})
+	fields := make([]configField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		key, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+		fields = append(fields, configField{Key: key, Index: i})
+	}
+	return fields
+}
+
+func lookupConfigField(key string) (configField, error) {
+	key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+	var keys []string
+	for _, field := range configFields() {
+		if field.Key == key {
+			return field, nil
+		}
+		keys = append(keys, field.Key)
+	}
+	return configField{}, fmt.Errorf("unknown setting %q (known: %s)", key, strings.Join(keys, ", "))
+}
+
+func getConfigValue(config Config, key string) (string, error) {
+	field, err := lookupConfigField(key)
+	if err != nil {
+		return "", err
+	}
+
+	value := reflect.ValueOf(config).Field(field.Index)
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(value.Bool()), nil
+	case reflect.Slice:
+		if items, ok := value.Interface().([]string); ok {
+			return strings.Join(items, ", "), nil
+		}
+	}
+
+	data, err := json.Marshal(value.Interface())
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// setConfigValue parses value for the setting's type: booleans as true/false,
+// lists as comma-separated text and anything else as JSON.
+func setConfigValue(config *Config, key, value string) error {
+	field, err := lookupConfigField(key)
+	if err != nil {
+		return err
+	}
+
+	target := reflect.ValueOf(config).Elem().Field(field.Index)
+	switch target.Kind() {
+	case reflect.String:
+		if field.Key == "input_dir" || field.Key == "output_dir" {
+			value = expandPath(value)
+		}
+		target.SetString(value)
+		return nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%s must be true or false, got %q", field.Key, value)
+		}
+		target.SetBool(b)
+		return nil
+	case reflect.Slice:
+		if _, ok := target.Interface().([]string); ok {
+			target.Set(reflect.ValueOf(splitListInput(value)))
+			return nil
+		}
+	}
+
+	parsed := reflect.New(target.Type())
+	if err := json.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+		return fmt.Errorf("%s must be JSON: %w", field.Key, err)
+	}
+	target.Set(parsed.Elem())
+	return nil
+}
+
+func resetConfigValue(config *Config, key string) error {
+	field, err := lookupConfigField(key)
+	if err != nil {
+		return err
+	}
+	target := reflect.ValueOf(config).Elem().Field(field.Index)
+	target.Set(reflect.Zero(target.Type()))
+	return nil
+}
+
+// validateSettings checks the optional settings that have a fixed vocabulary
+// or syntax. Required fields are checked separately before converting.
+func validateSettings(config Config) error {
+	if err := validateOutputTemplate(config.OutputTemplate); err != nil {
+		return err
+	}
+	if config.Filesystem != "" {
+		if _, err := lookupFilesystemProfile(config.Filesystem); err != nil {
+			return err
+		}
+	}
+	if err := validateCollisionStrategy(config.Collisions); err != nil {
+		return err
+	}
+	if err := validatePlaylistOptions(config); err != nil {
+		return err
+	}
+	if err := validateFilters(config); err != nil {
+		return err
+	}
+	if _, err := ParseLinkedFFmpegMode(config.FFmpegMode); err != nil {
+		return err
+	}
+	if err := validateJobLimits(config); err != nil {
+		return err
+	}
+	if config.AudioStream != nil && *config.AudioStream < 0 {
+		return fmt.Errorf("audio_stream must be a stream index of 0 or more, got %d", *config.AudioStream)
+	}
+	return validateTargets(config)
+}
+
+const configUsage = `usage: podhnologic [--profile NAME] config <command>
+
+commands:
+  path               print the config file location
+  list               print every setting in the profile
+  get KEY            print one setting
+  set KEY VALUE      change one setting (lists are comma-separated)
+  reset [KEY]        clear one setting, or the whole profile`
+
+// runConfigCommand implements the config subcommand against the named
+// profile, or the active one when profile is empty.
+func runConfigCommand(args []string, configDir, profile string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", configUsage)
+	}
+
+	file := loadConfigFile(configDir)
+	if profile == "" {
+		profile = file.ActiveProfile
+	}
+	config := file.Profiles[profile]
+
+	command, args := args[0], args[1:]
+	wantArgs := map[string][]int{
+		"path":  {0},
+		"list":  {0},
+		"get":   {1},
+		"set":   {2},
+		"reset": {0, 1},
+	}
+	counts, ok := wantArgs[command]
+	if !ok {
+		return fmt.Errorf("unknown config command %q\n%s", command, configUsage)
+	}
+	if !containsInt(counts, len(args)) {
+		return fmt.Errorf("wrong number of arguments for config %s\n%s", command, configUsage)
+	}
+
+	switch command {
+	case "path":
+		fmt.Fprintln(out, filepath.Join(configDir, "config.json"))
+		return nil
+
+	case "list":
+		fmt.Fprintf(out, "# profile: %s\n", profile)
+		for _, field := range configFields() {
+			value, err := getConfigValue(config, field.Key)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "%s = %s\n", field.Key, value)
+		}
+		return nil
+
+	case "get":
+		value, err := getConfigValue(config, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, value)
+		return nil
+
+	case "set":
+		if err := setConfigValue(&config, args[0], args[1]); err != nil {
+			return err
+		}
+		if config.Codec != "" {
+			if err := validateCodec(config.Codec); err != nil {
+				return err
+			}
+		}
+		if err := validateSettings(config); err != nil {
+			return err
+		}
+
+	case "reset":
+		if len(args) == 0 {
+			config = Config{}
+		} else if err := resetConfigValue(&config, args[0]); err != nil {
+			return err
+		}
+	}
+
+	return saveProfile(configDir, profile, config)
+}
+
+func containsInt(values []int, want int) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigValueRoundTrip(t *testing.T) {
+	var config Config
+
+	tests := []struct {
+		key, value, expected string
+	}{
+		{"codec", "opus", "opus"},
+		{"ipod", "true", "true"},
+		{"no-lyrics", "1", "true"},
+		{"exclude", "**/Podcasts/**, *.wav", "**/Podcasts/**, *.wav"},
+		{"targets", `[{"name":"car","output_dir":"/usb","codec":"mp3"}]`, `[{"name":"car","output_dir":"/usb","codec":"mp3","ipod":false,"no_lyrics":false}]`},
+	}
+
+	for _, tt := range tests {
+		if err := setConfigValue(&config, tt.key, tt.value); err != nil {
+			t.Fatalf("setConfigValue(%q, %q) error: %v", tt.key, tt.value, err)
+		}
+		got, err := getConfigValue(config, tt.key)
+		if err != nil {
+			t.Fatalf("getConfigValue(%q) error: %v", tt.key, err)
+		}
+		if got != tt.expected {
+			t.Errorf("getConfigValue(%q) = %q, want %q", tt.key, got, tt.expected)
+		}
+	}
+
+	if err := setConfigValue(&config, "ipod", "maybe"); err == nil {
+		t.Error("setConfigValue accepted a malformed bool")
+	}
+	if err := setConfigValue(&config, "volume", "11"); err == nil {
+		t.Error("setConfigValue accepted an unknown key")
+	}
+
+	if err := resetConfigValue(&config, "exclude"); err != nil {
+		t.Fatalf("resetConfigValue error: %v", err)
+	}
+	if config.Exclude != nil || config.Codec != "opus" {
+		t.Errorf("resetConfigValue cleared the wrong settings: %+v", config)
+	}
+}
+
+func TestRunConfigCommand(t *testing.T) {
+	tempDir := t.TempDir()
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := runConfigCommand(args, tempDir, "", &out)
+		return out.String(), err
+	}
+
+	if _, err := run("set", "codec", "flac"); err != nil {
+		t.Fatalf("config set error: %v", err)
+	}
+	if out, err := run("get", "codec"); err != nil || out != "flac\n" {
+		t.Errorf("config get = %q, %v", out, err)
+	}
+	if _, err := run("set", "collisions", "overwrite"); err == nil {
+		t.Error("config set accepted an invalid collision strategy")
+	}
+	if _, err := run("set", "codec", "foo"); err == nil {
+		t.Error("config set accepted an unknown codec")
+	}
+
+	out, err := run("list")
+	if err != nil {
+		t.Fatalf("config list error: %v", err)
+	}
+	if !strings.Contains(out, "# profile: default\n") || !strings.Contains(out, "codec = flac\n") {
+		t.Errorf("config list = %q", out)
+	}
+
+	if out, err := run("path"); err != nil || strings.TrimSpace(out) != filepath.Join(tempDir, "config.json") {
+		t.Errorf("config path = %q, %v", out, err)
+	}
+
+	if _, err := run("reset"); err != nil {
+		t.Fatalf("config reset error: %v", err)
+	}
+	if loadConfig(tempDir).Codec != "" {
+		t.Errorf("config reset left %+v", loadConfig(tempDir))
+	}
+
+	if _, err := run("get"); err == nil {
+		t.Error("config get without a key succeeded")
+	}
+	if _, err := run("frobnicate"); err == nil {
+		t.Error("unknown config command succeeded")
+	}
+}
+
+func TestConfigFileVersion(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := saveConfig(tempDir, Config{Codec: "aac"}); err != nil {
+		t.Fatalf("saveConfig error: %v", err)
+	}
+	if file := loadConfigFile(tempDir); file.Version != configVersion {
+		t.Errorf("Version = %d, want %d", file.Version, configVersion)
+	}
+
+	newer := []byte(`{"version": 99, "active_profile": "default", "profiles": {"default": {"codec": "flac"}}}`)
+	configPath := filepath.Join(tempDir, "config.json")
+	if err := os.WriteFile(configPath, newer, 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	if err := saveConfig(tempDir, Config{}); err == nil {
+		t.Error("saveConfig overwrote a config from a newer release")
+	}
+	data, _ := os.ReadFile(configPath)
+	if !bytes.Equal(data, newer) {
+		t.Error("newer config file was modified")
+	}
+}
+
+func TestApplyFlagsNegation(t *testing.T) {
+	defer func() {
+		flag.Set("ipod", "false")
+		flag.Set("no-ipod", "false")
+		flag.Set("lyrics", "false")
+	}()
+
+	config := Config{IPod: true, NoLyrics: true}
+	flag.Set("no-ipod", "true")
+	flag.Set("lyrics", "true")
+	if err := applyFlags(&config, map[string]bool{"no-ipod": true, "lyrics": true}); err != nil {
+		t.Fatalf("applyFlags error: %v", err)
+	}
+	if config.IPod || config.NoLyrics {
+		t.Errorf("negated flags not applied: %+v", config)
+	}
+
+	// Unset bool flags leave the profile alone
+	config = Config{IPod: true}
+	if err := applyFlags(&config, map[string]bool{}); err != nil || !config.IPod {
+		t.Errorf("applyFlags changed an unset flag: %+v, %v", config, err)
+	}
+
+	flag.Set("ipod", "true")
+	if err := applyFlags(&config, map[string]bool{"ipod": true, "no-ipod": true}); err == nil {
+		t.Error("applyFlags accepted --ipod with --no-ipod")
+	}
+}
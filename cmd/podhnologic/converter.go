@@ -1,11 +1,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"sync"
 )
@@ -22,31 +22,67 @@ type Metadata struct {
 	Format struct {
 		Tags map[string]string `json:"tags"`
 	} `json:"format"`
-	Streams []struct {
-		CodecType string `json:"codec_type"`
-	} `json:"streams"`
+	Streams []MetadataStream `json:"streams"`
 }
 
-func runConversion(config Config, dryRun bool) error {
+// MetadataStream is one stream as reported by ffprobe
+type MetadataStream struct {
+	Index       int               `json:"index"`
+	CodecType   string            `json:"codec_type"`
+	CodecName   string            `json:"codec_name"`
+	Duration    string            `json:"duration"`
+	Channels    int               `json:"channels"`
+	Tags        map[string]string `json:"tags"`
+	Disposition map[string]int    `json:"disposition"`
+}
+
+func runConversion(backend FFmpegBackend, config Config, dryRun bool) error {
 	if dryRun {
 		fmt.Println("=== DRY RUN MODE - No files will be converted ===")
 	}
 
-	// Verify input directory exists
 	if _, err := os.Stat(config.InputDir); os.IsNotExist(err) {
 		return fmt.Errorf("input directory does not exist: %s", config.InputDir)
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(config.OutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	// Create output directories if they don't exist; dry runs leave them alone
+	if !dryRun {
+		for _, target := range config.targetConfigs() {
+			if err := os.MkdirAll(target.Config.OutputDir, 0755); err != nil {
+				return fmt.Errorf("failed to create output directory: %w", err)
+			}
+		}
+	}
+
+	// Leave earlier outputs out of the scan so they are not converted again
+	excluded := nestedOutputDirs(config)
+	for _, dir := range excluded {
+		fmt.Printf("Output directory %s is inside the input directory; skipping it while scanning\n", dir)
 	}
 
 	// Collect all audio files
-	files, err := collectAudioFiles(config.InputDir)
+	files, warnings, err := scanAudioFiles(config.InputDir, scanOptionsFor(config, excluded))
 	if err != nil {
 		return err
 	}
+	reportScanWarnings(warnings)
+	files, err = filterFiles(files, config)
+	if err != nil {
+		return err
+	}
+
+	// Decide by content which files have audio worth converting
+	if config.ProbeDetection {
+		cache := loadProbeCache(probeCachePath())
+		var rejected []rejectedFile
+		files, rejected = detectAudioFiles(files, cache, func(path string) (*Metadata, error) {
+			return probeMetadata(backend, path, probeTimeout(config))
+		})
+		reportRejectedFiles(rejected)
+		if err := cache.save(); err != nil {
+			fmt.Printf("⚠ Could not save probe cache: %v\n", err)
+		}
+	}
 
 	if len(files) == 0 {
 		fmt.Println("No audio files found in input directory")
@@ -54,27 +90,16 @@ func runConversion(config Config, dryRun bool) error {
 	}
 
 	fmt.Printf("Found %d audio files\n", len(files))
-	fmt.Printf("Using %d threads\n\n", runtime.NumCPU())
+	fmt.Printf("Using %d threads\n\n", conversionWorkers(backend))
 
 	// Process files in parallel
-	return processFilesParallel(files, config, dryRun)
+	return processFilesParallel(backend, files, config, dryRun)
 }
 
-func collectAudioFiles(rootDir string) ([]string, error) {
-	var files []string
-
-	err := filepath.Walk(rootDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !info.IsDir() && isAudioFile(path) {
-			files = append(files, path)
-		}
-
-		return nil
-	})
-
+// collectAudioFiles lists the audio files under rootDir with the default scan
+// options, skipping the excluded directories.
+func collectAudioFiles(rootDir string, exclude ...string) ([]string, error) {
+	files, _, err := scanAudioFiles(rootDir, scanOptions{Exclude: exclude})
 	return files, err
 }
 
@@ -88,16 +113,26 @@ func isAudioFile(path string) bool {
 	return false
 }
 
-func processFilesParallel(files []string, config Config, dryRun bool) error {
-	numWorkers := runtime.NumCPU()
-	fileChan := make(chan string, len(files))
-	errorChan := make(chan error, len(files))
+func processFilesParallel(backend FFmpegBackend, files []string, config Config, dryRun bool) error {
+	// Plan every output up front so collisions are resolved before any work starts
+	jobs, collisions, errs := planConversion(backend, files, config, dryRun)
+	reportCollisions(collisions)
+	if config.Collisions == collisionError && len(collisions) > 0 {
+		return fmt.Errorf("%d output path collisions; choose --collisions lossless or suffix to resolve them", len(collisions))
+	}
+
+	// Each source is decoded once for all of its targets
+	batches := batchJobsByInput(jobs)
+
+	numWorkers := conversionWorkers(backend)
+	batchChan := make(chan []conversionJob, len(batches))
+	errorChan := make(chan error, len(batches))
 
-	// Fill the channel with files
-	for _, file := range files {
-		fileChan <- file
+	// Fill the channel with batches
+	for _, batch := range batches {
+		batchChan <- batch
 	}
-	close(fileChan)
+	close(batchChan)
 
 	// Start workers
 	var wg sync.WaitGroup
@@ -105,8 +140,8 @@ func processFilesParallel(files []string, config Config, dryRun bool) error {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for file := range fileChan {
-				if err := processFile(file, config, dryRun); err != nil {
+			for batch := range batchChan {
+				if err := runBatch(backend, batch, dryRun); err != nil {
 					errorChan <- err
 				}
 			}
@@ -118,14 +153,36 @@ func processFilesParallel(files []string, config Config, dryRun bool) error {
 	close(errorChan)
 
 	// Check for errors
-	var errs []error
 	for err := range errorChan {
 		errs = append(errs, err)
 	}
 
+	if config.Playlists {
+		excluded := nestedOutputDirs(config)
+		for _, target := range config.targetConfigs() {
+			var targetJobs []conversionJob
+			for _, job := range jobs {
+				if job.Target == target.Name {
+					targetJobs = append(targetJobs, job)
+				}
+			}
+			var targetCollisions []outputCollision
+			for _, collision := range collisions {
+				if collision.Target == target.Name {
+					targetCollisions = append(targetCollisions, collision)
+				}
+			}
+			errs = append(errs, convertPlaylists(targetJobs, targetCollisions, target.Config, excluded, dryRun)...)
+		}
+	}
+
 	if len(errs) > 0 {
 		fmt.Printf("\n%d files failed to process\n", len(errs))
 		for _, err := range errs {
+			if errors.Is(err, errConversionTimeout) {
+				fmt.Printf("  - [timeout] %v\n", err)
+				continue
+			}
 			fmt.Printf("  - %v\n", err)
 		}
 		return errors.Join(errs...)
@@ -136,71 +193,191 @@ func processFilesParallel(files []string, config Config, dryRun bool) error {
 	return nil
 }
 
-func processFile(inputPath string, config Config, dryRun bool) error {
-	// Get relative path from input dir
-	relPath, err := filepath.Rel(config.InputDir, inputPath)
+func processFile(backend FFmpegBackend, inputPath string, config Config, dryRun bool) error {
+	job, err := planFile(backend, inputPath, config, dryRun)
 	if err != nil {
-		return fmt.Errorf("failed to get relative path: %w", err)
+		return err
 	}
+	return runBatch(backend, []conversionJob{job}, dryRun)
+}
 
-	// Determine output extension
-	outputExt := getOutputExtension(config.Codec)
+// runBatch converts one source into every planned output with a single ffmpeg
+// invocation. Outputs that already exist are skipped individually.
+func runBatch(backend FFmpegBackend, jobs []conversionJob, dryRun bool) error {
+	var pending []conversionJob
+	for _, job := range jobs {
+		if job.Filtered != "" {
+			fmt.Printf("✓ Skipping (filtered by %s): %s\n", job.Filtered, jobLabel(job))
+			continue
+		}
 
-	// Build output path
-	outputPath := filepath.Join(config.OutputDir, relPath)
-	outputPath = strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + outputExt
+		// Check if output already exists (resumability)
+		if _, err := os.Stat(job.OutputPath); err == nil {
+			fmt.Printf("✓ Skipping (exists): %s\n", jobLabel(job))
+			continue
+		}
 
-	// Check if output already exists (resumability)
-	if _, err := os.Stat(outputPath); err == nil {
-		fmt.Printf("✓ Skipping (exists): %s\n", relPath)
+		pending = append(pending, job)
+	}
+	if len(pending) == 0 {
 		return nil
 	}
 
-	metadata := &Metadata{}
-	if !dryRun {
-		metadata, err = extractMetadata(inputPath)
+	first := pending[0]
+	inputPath := first.InputPath
+
+	metadata := first.Metadata
+	if metadata == nil {
+		var err error
+		metadata, err = conversionMetadata(backend, inputPath, first.Config, dryRun)
 		if err != nil {
-			return fmt.Errorf("failed to extract metadata from %s: %w", inputPath, err)
+			return err
 		}
 	}
+	// Lyrics import is a run-wide setting that targets cannot override
+	if first.Config.LyricsImport && embedSidecarLyrics(inputPath, metadata) && dryRun {
+		fmt.Printf("[DRY RUN] Embedding lyrics from sidecar for %s\n", first.RelPath)
+	}
 
-	// Build ffmpeg command
-	args := buildFFmpegArgs(inputPath, outputPath, config, metadata)
+	// Build ffmpeg command with one output per target
+	args := []string{"-i", inputPath}
+	for _, job := range pending {
+		args = append(args, buildOutputArgs(job.OutputPath, job.Config, metadata)...)
+	}
 
 	if dryRun {
-		fmt.Printf("[DRY RUN] %s -> %s\n", inputPath, outputPath)
+		for _, job := range pending {
+			fmt.Printf("[DRY RUN] %s -> %s\n", inputPath, job.OutputPath)
+			if unsanitized := filepath.Join(job.Config.OutputDir, unsanitizedOutputRelPath(job.Config, job.RelPath, metadata)); unsanitized != job.OutputPath && !job.Renamed {
+				fmt.Printf("  Sanitized for %s: %s\n", targetFilesystem(job.Config).Name, unsanitized)
+			}
+			if job.Renamed {
+				fmt.Printf("  Renamed to avoid a collision\n")
+			}
+			if job.Config.LyricsExport && sourceLyrics(inputPath, metadata) != "" {
+				fmt.Printf("  Lyrics sidecar: %s\n", lyricsSidecarPath(job.OutputPath))
+			}
+		}
+		if summary := metadataSummary(metadata); summary != "" {
+			fmt.Printf("  Tags: %s\n", summary)
+		}
 		fmt.Printf("  FFmpeg args: %s\n\n", strings.Join(args, " "))
 		return nil
 	}
 
-	// Create output directory
-	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	// Create output directories
+	for _, job := range pending {
+		if err := os.MkdirAll(filepath.Dir(job.OutputPath), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
 	}
 
 	// Run ffmpeg
-	fmt.Printf("Converting: %s\n", relPath)
+	fmt.Printf("Converting: %s\n", batchLabel(pending))
 
-	output, err := runFFmpeg(args)
+	timeout := batchTimeout(pending, metadata)
+	ctx, cancel := jobContext(timeout)
+	defer cancel()
+	output, err := runFFmpegContext(ctx, backend, args)
 	if err != nil {
+		// Don't leave partial outputs behind to be skipped as complete next run
+		for _, job := range pending {
+			_ = os.Remove(job.OutputPath)
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("conversion failed for %s: %w after %s\nFFmpeg output: %s", inputPath, errConversionTimeout, timeout, string(output))
+		}
+		// A hidden-mode worker that used up its CPU limit
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("conversion failed for %s: %w: %v\nFFmpeg output: %s", inputPath, errConversionTimeout, err, string(output))
+		}
 		return fmt.Errorf("conversion failed for %s: %w\nFFmpeg output: %s", inputPath, err, string(output))
 	}
 
-	fmt.Printf("✓ Completed: %s\n", relPath)
+	for _, job := range pending {
+		if job.Config.LyricsExport {
+			if lyrics := sourceLyrics(inputPath, metadata); lyrics != "" {
+				if err := writeLyricsSidecar(job.OutputPath, lyrics); err != nil {
+					return err
+				}
+			}
+		}
+
+		fmt.Printf("✓ Completed: %s\n", jobLabel(job))
+	}
 
 	return nil
 }
 
-func extractMetadata(filePath string) (*Metadata, error) {
-	return probeMetadata(filePath)
+func jobLabel(job conversionJob) string {
+	if job.Target == "" {
+		return job.RelPath
+	}
+	return fmt.Sprintf("[%s] %s", job.Target, job.RelPath)
 }
 
-func buildFFmpegArgs(inputPath, outputPath string, config Config, metadata *Metadata) []string {
-	args := []string{
-		"-i", inputPath,
-		"-map", "0",
-		"-map_metadata", "-1",
+func batchLabel(jobs []conversionJob) string {
+	var targets []string
+	for _, job := range jobs {
+		if job.Target != "" {
+			targets = append(targets, job.Target)
+		}
+	}
+	if len(targets) == 0 {
+		return jobs[0].RelPath
+	}
+	return fmt.Sprintf("[%s] %s", strings.Join(targets, ", "), jobs[0].RelPath)
+}
+
+// buildOutputPath maps a source path relative to the input directory onto the
+// output tree, then makes it safe for the target filesystem.
+func buildOutputPath(config Config, relPath string, metadata *Metadata) string {
+	outputRel := sanitizeOutputPath(unsanitizedOutputRelPath(config, relPath, metadata), targetFilesystem(config))
+	return filepath.Join(config.OutputDir, outputRel)
+}
+
+// unsanitizedOutputRelPath either mirrors the source path or renders the
+// output template, and swaps in the codec's extension.
+func unsanitizedOutputRelPath(config Config, relPath string, metadata *Metadata) string {
+	outputRel := strings.TrimSuffix(relPath, filepath.Ext(relPath))
+	if config.OutputTemplate != "" {
+		outputRel = renderOutputTemplate(config.OutputTemplate, metadata, relPath)
 	}
+	return outputRel + getOutputExtension(config.Codec)
+}
+
+// conversionMetadata probes the source. Dry runs read what the built-in tag
+// reader can, only run ffprobe when the output path or the filters depend on
+// tags, and fall back to empty metadata if probing fails.
+func conversionMetadata(backend FFmpegBackend, inputPath string, config Config, dryRun bool) (*Metadata, error) {
+	if dryRun {
+		if metadata, err := readTags(inputPath); err == nil {
+			return metadata, nil
+		}
+		if !needsPlanMetadata(config) && !config.VideoInput {
+			return &Metadata{}, nil
+		}
+	}
+
+	metadata, err := probeMetadata(backend, inputPath, probeTimeout(config))
+	if err != nil {
+		if dryRun {
+			return &Metadata{}, nil
+		}
+		return nil, fmt.Errorf("failed to extract metadata from %s: %w", inputPath, err)
+	}
+	return metadata, nil
+}
+
+func buildFFmpegArgs(inputPath, outputPath string, config Config, metadata *Metadata) []string {
+	return append([]string{"-i", inputPath}, buildOutputArgs(outputPath, config, metadata)...)
+}
+
+// buildOutputArgs builds the per-output half of an ffmpeg command, so several
+// outputs can share one decoded input.
+func buildOutputArgs(outputPath string, config Config, metadata *Metadata) []string {
+	mapArgs, thumbnail := streamMapArgs(config, metadata)
+	args := append(mapArgs, "-map_metadata", "-1")
 
 	// Normalize tags to lowercase for case-insensitive lookup
 	normalizedTags := make(map[string]string)
@@ -216,11 +393,18 @@ func buildFFmpegArgs(inputPath, outputPath string, config Config, metadata *Meta
 
 	for _, key := range desiredKeys {
 		if value, ok := normalizedTags[key]; ok {
+			if key == "lyrics" && config.IPod && config.PlainLyrics {
+				value = stripLyricsTimestamps(value)
+			}
 			args = append(args, "-metadata", fmt.Sprintf("%s=%s", key, value))
 		}
 	}
 
-	args = append(args, getCodecParamsSimple(config)...)
+	params := getCodecParamsSimple(config)
+	if thumbnail {
+		params = videoCoverArgs(params)
+	}
+	args = append(args, params...)
 
 	// Add output path
 	args = append(args, outputPath)
@@ -231,12 +415,6 @@ func buildFFmpegArgs(inputPath, outputPath string, config Config, metadata *Meta
 func getCodecParamsSimple(config Config) []string {
 	var params []string
 
-	// Use aac_at for macOS (best quality), fallback to aac for other platforms
-	aacCodec := "aac"
-	if runtime.GOOS == "darwin" {
-		aacCodec = "aac_at"
-	}
-
 	switch config.Codec {
 	case "alac":
 		params = []string{"-c:a", "alac", "-c:v", "copy"}
@@ -245,7 +423,7 @@ func getCodecParamsSimple(config Config) []string {
 		}
 
 	case "aac":
-		params = []string{"-c:a", aacCodec, "-b:a", "256k", "-c:v", "copy"}
+		params = []string{"-c:a", aacEncoder(), "-b:a", "256k", "-c:v", "copy"}
 		if config.IPod {
 			params = append(params, "-ar", "44100", "-movflags", "+faststart", "-disposition:a", "0")
 		}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 // NewTestHelper creates a new test helper with temporary directories
@@ -116,7 +119,7 @@ func TestProcessFileDryRun(t *testing.T) {
 	}
 
 	// Process the file in dry-run mode
-	err := processFile(inputFile, config, true)
+	err := processFile(newFakeBackend(), inputFile, config, true)
 	if err != nil {
 		t.Fatalf("processFile failed: %v", err)
 	}
@@ -148,7 +151,7 @@ func TestProcessFileSkipsExisting(t *testing.T) {
 	}
 
 	// Process the file - should skip
-	err := processFile(inputFile, config, false)
+	err := processFile(newFakeBackend(), inputFile, config, false)
 	if err != nil {
 		t.Fatalf("processFile failed: %v", err)
 	}
@@ -386,13 +389,19 @@ func TestProcessFilesParallelReturnsConversionErrors(t *testing.T) {
 		Codec:     "flac",
 	}
 
-	err := processFilesParallel([]string{inputFile}, config, false)
+	backend := newFakeBackend()
+	backend.Failures[inputFile] = errors.New("Invalid data found when processing input")
+
+	err := processFilesParallel(backend, []string{inputFile}, config, false)
 	if err == nil {
 		t.Fatal("processFilesParallel returned nil after conversion failure")
 	}
-	if !strings.Contains(err.Error(), "failed to extract metadata") && !strings.Contains(err.Error(), "linked ffmpeg bridge unavailable") {
+	if !strings.Contains(err.Error(), "conversion failed for "+inputFile) || !strings.Contains(err.Error(), "Invalid data found") {
 		t.Fatalf("unexpected error: %v", err)
 	}
+	if _, err := os.Stat(filepath.Join(outputDir, "broken.flac")); !os.IsNotExist(err) {
+		t.Errorf("partial output left behind after a failed conversion: %v", err)
+	}
 }
 
 // TestGetCodecParamsSimple tests codec parameter generation
@@ -466,13 +475,28 @@ func TestRunConversionEmptyInput(t *testing.T) {
 	}
 
 	// Run conversion on empty directory
-	err := runConversion(config, false)
+	err := runConversion(newFakeBackend(), config, false)
 	if err != nil {
 		t.Errorf("runConversion should not error on empty directory: %v", err)
 	}
 }
 
 // TestRunConversionNonExistentInput tests behavior with non-existent input
+func TestRunConversionDryRunDoesNotCreateOutput(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	helper.WriteInputFile("song.flac", []byte("audio"))
+	output := filepath.Join(helper.outputDir, "not", "yet")
+
+	config := Config{InputDir: helper.inputDir, OutputDir: output, Codec: "flac"}
+	if err := runConversion(newFakeBackend(), config, true); err != nil {
+		t.Fatalf("runConversion error = %v", err)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("dry run created the output directory %s", output)
+	}
+}
+
 func TestRunConversionNonExistentInput(t *testing.T) {
 	helper := NewTestHelper(t)
 	helper.Setup()
@@ -484,8 +508,143 @@ func TestRunConversionNonExistentInput(t *testing.T) {
 	}
 
 	// Run conversion on non-existent directory
-	err := runConversion(config, false)
+	err := runConversion(newFakeBackend(), config, false)
 	if err == nil {
 		t.Error("runConversion should error on non-existent input directory")
 	}
 }
+
+func TestRunConversionWithFakeBackend(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	inputs := []string{
+		helper.WriteInputFile("Artist/Album/01.flac", []byte("one")),
+		helper.WriteInputFile("Artist/Album/02.flac", []byte("two")),
+		helper.WriteInputFile("Single.mp3", []byte("three")),
+	}
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "alac"}
+
+	backend := newFakeBackend()
+	if err := runConversion(backend, config, false); err != nil {
+		t.Fatalf("runConversion error = %v", err)
+	}
+
+	calls := backend.Calls(LinkedFFmpegToolFFmpeg)
+	if len(calls) != len(inputs) {
+		t.Fatalf("ffmpeg ran %d times, want %d", len(calls), len(inputs))
+	}
+	for _, output := range []string{"Artist/Album/01.m4a", "Artist/Album/02.m4a", "Single.m4a"} {
+		helper.VerifyFileExists(filepath.Join(helper.outputDir, output))
+	}
+	for _, call := range calls {
+		if outputs := call.Outputs(); len(outputs) != 1 {
+			t.Errorf("ffmpeg call for %s wrote %v, want one output", call.Input(), outputs)
+		}
+	}
+
+	// A second run finds every output and converts nothing
+	rerun := newFakeBackend()
+	if err := runConversion(rerun, config, false); err != nil {
+		t.Fatalf("second runConversion error = %v", err)
+	}
+	if calls := rerun.Calls(LinkedFFmpegToolFFmpeg); len(calls) != 0 {
+		t.Errorf("second run converted %d files, want 0", len(calls))
+	}
+}
+
+func TestRunConversionReportsFailuresAndContinues(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	good := helper.WriteInputFile("good.flac", []byte("good"))
+	broken := helper.WriteInputFile("broken.flac", []byte("broken"))
+	unreadable := helper.WriteInputFile("unreadable.flac", []byte("unreadable"))
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "mp3"}
+
+	backend := newFakeBackend()
+	backend.Failures[broken] = errors.New("Error while decoding stream")
+	backend.ProbeFailures[unreadable] = errors.New("Invalid data found when processing input")
+
+	err := runConversion(backend, config, false)
+	if err == nil {
+		t.Fatal("runConversion returned nil after failures")
+	}
+	for _, want := range []string{"conversion failed for " + broken, "failed to extract metadata from " + unreadable} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), good) {
+		t.Errorf("error mentions the file that converted:\n%v", err)
+	}
+
+	helper.VerifyFileExists(filepath.Join(helper.outputDir, "good.mp3"))
+	helper.VerifyFileNotExists(filepath.Join(helper.outputDir, "broken.mp3"))
+	helper.VerifyFileNotExists(filepath.Join(helper.outputDir, "unreadable.mp3"))
+}
+
+func TestRunConversionRunsFilesConcurrently(t *testing.T) {
+	if runtime.NumCPU() < 2 {
+		t.Skip("needs more than one CPU")
+	}
+	helper := NewTestHelper(t)
+	helper.Setup()
+	for i := 0; i < 4; i++ {
+		helper.WriteInputFile(fmt.Sprintf("%02d.flac", i), []byte("audio"))
+	}
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "flac"}
+
+	backend := newFakeBackend()
+	backend.Delay = 50 * time.Millisecond
+	if err := runConversion(backend, config, false); err != nil {
+		t.Fatalf("runConversion error = %v", err)
+	}
+	if backend.MaxConcurrent() < 2 {
+		t.Errorf("at most %d conversions ran at once, want parallel conversions", backend.MaxConcurrent())
+	}
+}
+
+func TestRunConversionDoesNotTimeOutQueuedConversions(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	for i := 0; i < 2; i++ {
+		helper.WriteInputFile(fmt.Sprintf("%02d.flac", i), []byte("audio"))
+	}
+	scale := 0.0
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "flac", JobTimeout: "300ms", JobTimeoutScale: &scale}
+
+	// Each conversion fits its timeout, but not after waiting for the other
+	backend := newFakeBackend()
+	backend.Serial = true
+	backend.Delay = 200 * time.Millisecond
+	if n := conversionWorkers(backend); n != 1 {
+		t.Errorf("conversion workers = %d for a serial backend, want 1", n)
+	}
+	if err := runConversion(backend, config, false); err != nil {
+		t.Fatalf("runConversion error = %v", err)
+	}
+}
+
+func TestRunConversionDecodesOnceForAllTargets(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	helper.WriteInputFile("song.flac", []byte("audio"))
+	config := Config{
+		InputDir: helper.inputDir,
+		Targets: []Target{
+			{Name: "ipod", OutputDir: filepath.Join(helper.outputDir, "ipod"), Codec: "aac", IPod: true},
+			{Name: "phone", OutputDir: filepath.Join(helper.outputDir, "phone"), Codec: "opus"},
+		},
+	}
+
+	backend := newFakeBackend()
+	if err := runConversion(backend, config, false); err != nil {
+		t.Fatalf("runConversion error = %v", err)
+	}
+
+	calls := backend.Calls(LinkedFFmpegToolFFmpeg)
+	if len(calls) != 1 || len(calls[0].Outputs()) != 2 {
+		t.Fatalf("ffmpeg calls = %v, want one call writing both targets", calls)
+	}
+	helper.VerifyFileExists(filepath.Join(helper.outputDir, "ipod", "song.m4a"))
+	helper.VerifyFileExists(filepath.Join(helper.outputDir, "phone", "song.opus"))
+}
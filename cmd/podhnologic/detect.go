@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// nonMediaExtensions are never probed when detecting audio by content.
+var nonMediaExtensions = map[string]bool{
+	".accurip": true, ".bmp": true, ".cue": true, ".db": true, ".gif": true,
+	".htm": true, ".html": true, ".ini": true, ".jpeg": true, ".jpg": true,
+	".json": true, ".log": true, ".lrc": true, ".m3u": true, ".m3u8": true,
+	".md5": true, ".nfo": true, ".pdf": true, ".pls": true, ".png": true,
+	".sfv": true, ".txt": true, ".webp": true, ".xml": true,
+}
+
+const probeCacheFile = "probe-cache.json"
+
+// probeCachePath is where probe verdicts persist between runs, or "" when
+// there is no config directory.
+func probeCachePath() string {
+	configDir, err := getConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(configDir, probeCacheFile)
+}
+
+// isProbeCandidate reports whether content detection should look at a file.
+func isProbeCandidate(path string) bool {
+	return !nonMediaExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// rejectedFile is a file the content probe decided not to convert.
+type rejectedFile struct {
+	Path   string
+	Reason string
+}
+
+// probeCacheEntry remembers a probe verdict for one version of a file.
+type probeCacheEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+	Audio   bool   `json:"audio"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// probeCache maps absolute source paths to their last probe verdict. Entries
+// are reused while the file's size and modification time are unchanged.
+type probeCache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]probeCacheEntry
+	dirty   bool
+}
+
+func loadProbeCache(path string) *probeCache {
+	cache := &probeCache{path: path, entries: make(map[string]probeCacheEntry)}
+	if data, err := os.ReadFile(path); err == nil {
+		// A corrupt cache is rebuilt from scratch
+		_ = json.Unmarshal(data, &cache.entries)
+	}
+	return cache
+}
+
+func (c *probeCache) lookup(file string, info os.FileInfo) (probeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[file]
+	if !ok || entry.Size != info.Size() || entry.ModTime != info.ModTime().UnixNano() {
+		return probeCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *probeCache) store(file string, entry probeCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[file] = entry
+	c.dirty = true
+}
+
+func (c *probeCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty || c.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	c.dirty = false
+	return os.WriteFile(c.path, data, 0644)
+}
+
+// audioVerdict decides from probe results whether a file has an audio stream
+// FFmpeg can decode, and why not when it does not.
+func audioVerdict(metadata *Metadata, err error) (bool, string) {
+	if err != nil {
+		reason, _, _ := strings.Cut(strings.TrimSpace(err.Error()), "\n")
+		return false, "probe failed: " + reason
+	}
+	sawAudio := false
+	for _, stream := range metadata.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		sawAudio = true
+		if stream.CodecName != "" && stream.CodecName != "none" {
+			return true, ""
+		}
+	}
+	if sawAudio {
+		return false, "audio stream has no decodable codec"
+	}
+	return false, "no audio stream"
+}
+
+// detectAudioFiles probes candidates in parallel and splits them into files
+// with decodable audio and rejected files. Verdicts are cached; probe failures
+// are not.
+func detectAudioFiles(files []string, cache *probeCache, probe func(string) (*Metadata, error)) ([]string, []rejectedFile) {
+	audio := make([]bool, len(files))
+	reasons := make([]string, len(files))
+
+	indexChan := make(chan int, len(files))
+	for i := range files {
+		indexChan <- i
+	}
+	close(indexChan)
+
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexChan {
+				audio[i], reasons[i] = detectAudioFile(files[i], cache, probe)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var accepted []string
+	var rejected []rejectedFile
+	for i, file := range files {
+		if audio[i] {
+			accepted = append(accepted, file)
+		} else {
+			rejected = append(rejected, rejectedFile{Path: file, Reason: reasons[i]})
+		}
+	}
+	return accepted, rejected
+}
+
+func detectAudioFile(file string, cache *probeCache, probe func(string) (*Metadata, error)) (bool, string) {
+	key, err := filepath.Abs(file)
+	if err != nil {
+		key = file
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return false, err.Error()
+	}
+	if entry, ok := cache.lookup(key, info); ok {
+		return entry.Audio, entry.Reason
+	}
+
+	// Only a probe that ran says anything about the file; a failure may be
+	// FFmpeg's and is tried again next run
+	metadata, err := probe(file)
+	audio, reason := audioVerdict(metadata, err)
+	if err != nil {
+		return audio, reason
+	}
+	cache.store(key, probeCacheEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime().UnixNano(),
+		Audio:   audio,
+		Reason:  reason,
+	})
+	return audio, reason
+}
+
+func reportRejectedFiles(rejected []rejectedFile) {
+	if len(rejected) == 0 {
+		return
+	}
+	fmt.Printf("Rejected %d files without decodable audio:\n", len(rejected))
+	for _, file := range rejected {
+		fmt.Printf("  %s (%s)\n", file.Path, file.Reason)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAudioVerdict(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata *Metadata
+		err      error
+		audio    bool
+		reason   string
+	}{
+		{"audio", &Metadata{Streams: []MetadataStream{{CodecType: "video", CodecName: "h264"}, {CodecType: "audio", CodecName: "aac"}}}, nil, true, ""},
+		{"video only", &Metadata{Streams: []MetadataStream{{CodecType: "video", CodecName: "h264"}}}, nil, false, "no audio stream"},
+		{"unknown codec", &Metadata{Streams: []MetadataStream{{CodecType: "audio"}}}, nil, false, "audio stream has no decodable codec"},
+		{"corrupt", nil, errors.New("exit status 1: Invalid data found when processing input\nmore"), false, "probe failed: exit status 1: Invalid data found when processing input"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			audio, reason := audioVerdict(tt.metadata, tt.err)
+			if audio != tt.audio || reason != tt.reason {
+				t.Errorf("audioVerdict = %v, %q; want %v, %q", audio, reason, tt.audio, tt.reason)
+			}
+		})
+	}
+}
+
+func TestDetectAudioFilesCachesVerdicts(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	song := helper.WriteInputFile("misnamed.dat", []byte("audio"))
+	video := helper.WriteInputFile("clip.mkv", []byte("video"))
+
+	var probes atomic.Int32
+	probe := func(path string) (*Metadata, error) {
+		probes.Add(1)
+		if strings.HasSuffix(path, ".dat") {
+			return &Metadata{Streams: []MetadataStream{{CodecType: "audio", CodecName: "flac"}}}, nil
+		}
+		return &Metadata{Streams: []MetadataStream{{CodecType: "video", CodecName: "h264"}}}, nil
+	}
+
+	cachePath := filepath.Join(helper.tempDir, "cache", probeCacheFile)
+	cache := loadProbeCache(cachePath)
+	accepted, rejected := detectAudioFiles([]string{song, video}, cache, probe)
+	if len(accepted) != 1 || accepted[0] != song {
+		t.Errorf("accepted = %v, want %s", accepted, song)
+	}
+	if len(rejected) != 1 || rejected[0].Path != video || rejected[0].Reason != "no audio stream" {
+		t.Errorf("rejected = %v", rejected)
+	}
+	if err := cache.save(); err != nil {
+		t.Fatalf("cache save error: %v", err)
+	}
+
+	// A fresh cache loaded from disk answers without probing
+	detectAudioFiles([]string{song, video}, loadProbeCache(cachePath), probe)
+	if n := probes.Load(); n != 2 {
+		t.Errorf("probes = %d after a cached run, want 2", n)
+	}
+
+	// Changing a file invalidates its entry
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(video, later, later); err != nil {
+		t.Fatalf("failed to touch file: %v", err)
+	}
+	detectAudioFiles([]string{song, video}, loadProbeCache(cachePath), probe)
+	if n := probes.Load(); n != 3 {
+		t.Errorf("probes = %d after touching a file, want 3", n)
+	}
+}
+
+func TestDetectAudioFilesDoesNotCacheProbeFailures(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	song := helper.WriteInputFile("song.wma", []byte("audio"))
+
+	cache := loadProbeCache(filepath.Join(helper.tempDir, probeCacheFile))
+	failing := func(string) (*Metadata, error) {
+		return nil, ErrLinkedFFmpegUnavailable
+	}
+	if _, rejected := detectAudioFiles([]string{song}, cache, failing); len(rejected) != 1 {
+		t.Fatalf("rejected = %v, want the file rejected for this run", rejected)
+	}
+
+	working := func(string) (*Metadata, error) {
+		return &Metadata{Streams: []MetadataStream{{CodecType: "audio", CodecName: "wmav2"}}}, nil
+	}
+	if accepted, _ := detectAudioFiles([]string{song}, cache, working); len(accepted) != 1 {
+		t.Errorf("accepted = %v after FFmpeg recovered, want the file probed again", accepted)
+	}
+}
+
+func TestScanProbeCandidates(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	for _, name := range []string{"song.flac", "video.mkv", "cover.jpg", "rip.cue", "track"} {
+		helper.WriteInputFile(name, []byte("data"))
+	}
+
+	files, _, err := scanAudioFiles(helper.inputDir, scanOptions{ProbeCandidates: true})
+	if err != nil {
+		t.Fatalf("scanAudioFiles error: %v", err)
+	}
+	if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != "song.flac,track,video.mkv" {
+		t.Errorf("probe candidates = %s", got)
+	}
+}
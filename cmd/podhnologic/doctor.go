@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// doctorReport collects the outcome of each doctor check.
+type doctorReport struct {
+	out      io.Writer
+	failures int
+}
+
+func (r *doctorReport) pass(format string, args ...any) {
+	fmt.Fprintf(r.out, "✓ "+format+"\n", args...)
+}
+
+func (r *doctorReport) warn(format string, args ...any) {
+	fmt.Fprintf(r.out, "⚠ "+format+"\n", args...)
+}
+
+func (r *doctorReport) fail(problem, fix string) {
+	r.failures++
+	fmt.Fprintf(r.out, "✗ %s\n", problem)
+	if fix != "" {
+		fmt.Fprintf(r.out, "    → %s\n", fix)
+	}
+}
+
+// runDoctor checks that the linked FFmpeg works, that it has the encoders the
+// profile needs, that the profile is valid and that the output volume has room.
+// It reports whether every check passed.
+func runDoctor(config Config, profile string, runner LinkedFFmpegRunner, out io.Writer) bool {
+	report := &doctorReport{out: out}
+	fmt.Fprintf(out, "podhnologic v%s, profile %s\n\n", Version, profile)
+
+	encoders := doctorFFmpeg(report, runner)
+	doctorEncoders(report, config, runner, encoders)
+	doctorConfig(report, config)
+	doctorDiskSpace(report, config)
+
+	fmt.Fprintln(out)
+	if report.failures > 0 {
+		fmt.Fprintf(out, "%d problems found\n", report.failures)
+		return false
+	}
+	fmt.Fprintln(out, "No problems found")
+	return true
+}
+
+// doctorFFmpeg checks the FFmpeg in use and returns its encoders, or nil when
+// it cannot be run.
+func doctorFFmpeg(report *doctorReport, runner LinkedFFmpegRunner) map[string]bool {
+	system := runner.Mode == LinkedFFmpegModeSystem
+	name := "Linked FFmpeg"
+	if system {
+		name = "System FFmpeg"
+	}
+
+	result, err := runner.FFmpeg(context.Background(), "-hide_banner", "-version")
+	if err != nil {
+		fix := "reinstall podhnologic from a release build"
+		switch {
+		case system:
+			fix = fmt.Sprintf("install FFmpeg %d or newer, or point --ffmpeg-path at it", systemFFmpegMinVersion)
+		case errors.Is(err, ErrLinkedFFmpegUnavailable):
+			fix = linkedFFmpegBuildError(err).Error() + ", or use --ffmpeg-mode system"
+		}
+		report.fail(fmt.Sprintf("%s is unavailable: %v", name, err), fix)
+		return nil
+	}
+	version, _, _ := strings.Cut(string(result.Stdout), "\n")
+	if major, minor, ok := parseFFmpegVersion(result.Stdout); system && ok && major < systemFFmpegMinVersion {
+		report.fail(
+			fmt.Sprintf("%s %d.%d is too old", name, major, minor),
+			fmt.Sprintf("install FFmpeg %d or newer, or point --ffmpeg-path at it", systemFFmpegMinVersion),
+		)
+	} else {
+		report.pass("%s: %s", name, strings.TrimSpace(version))
+	}
+
+	result, err = runner.FFmpeg(context.Background(), "-hide_banner", "-encoders")
+	if err != nil {
+		report.fail(fmt.Sprintf("Could not list FFmpeg encoders: %v", err), "")
+		return nil
+	}
+	return parseEncoderList(result.Stdout)
+}
+
+// parseEncoderList reads the names from `ffmpeg -encoders` output.
+func parseEncoderList(output []byte) map[string]bool {
+	encoders := make(map[string]bool)
+	listing := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "------") {
+			listing = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if listing && len(fields) >= 2 {
+			encoders[fields[1]] = true
+		}
+	}
+	return encoders
+}
+
+func doctorEncoders(report *doctorReport, config Config, runner LinkedFFmpegRunner, encoders map[string]bool) {
+	if encoders == nil || !hasCodec(config) {
+		return
+	}
+
+	for _, codec := range configuredCodecs(config) {
+		encoder := codecEncoder(codec)
+		if encoders[encoder] {
+			report.pass("Encoder for %s: %s", codec, encoder)
+			continue
+		}
+		if runner.Mode == LinkedFFmpegModeSystem {
+			report.fail(
+				fmt.Sprintf("Encoder %s for %s is missing from the system FFmpeg", encoder, codec),
+				"install an FFmpeg build that includes it, or choose another codec",
+			)
+			continue
+		}
+		report.fail(
+			fmt.Sprintf("Encoder %s for %s is missing from the linked FFmpeg", encoder, codec),
+			"rebuild FFmpeg with scripts/ffmpeg/build-source.sh, or choose another codec",
+		)
+	}
+}
+
+func doctorConfig(report *doctorReport, config Config) {
+	for _, warning := range config.Warnings() {
+		report.warn("Configuration: %s", warning)
+	}
+
+	err := config.Validate()
+	if err == nil {
+		report.pass("Configuration is valid")
+		return
+	}
+
+	var errs []error
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+	for _, err := range errs {
+		report.fail("Configuration: "+err.Error(), "fix with podhnologic config set KEY VALUE, flags, or the terminal UI")
+	}
+}
+
+func doctorDiskSpace(report *doctorReport, config Config) {
+	if !hasOutput(config) {
+		return
+	}
+
+	var librarySize int64
+	if config.InputDir != "" {
+		if files, _, err := scanAudioFiles(config.InputDir, scanOptionsFor(config, nestedOutputDirs(config))); err == nil {
+			files, _ = filterFiles(files, config)
+			for _, file := range files {
+				if info, err := os.Stat(file); err == nil {
+					librarySize += info.Size()
+				}
+			}
+		}
+	}
+
+	for _, target := range config.targetConfigs() {
+		dir := existingAncestor(target.Config.OutputDir)
+		label := target.Config.OutputDir
+		if target.Name != "" {
+			label = fmt.Sprintf("%s (%s)", label, target.Name)
+		}
+
+		free, err := diskFree(dir)
+		if err != nil {
+			report.warn("Free space on %s: unknown (%v)", label, err)
+			continue
+		}
+		if librarySize > 0 && free < uint64(librarySize) {
+			report.warn("Free space on %s: %s, less than the %s input library; lossless outputs may not fit", label, formatBytes(free), formatBytes(uint64(librarySize)))
+			continue
+		}
+		report.pass("Free space on %s: %s", label, formatBytes(free))
+	}
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseEncoderList(t *testing.T) {
+	output := []byte(`Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D mjpeg                MJPEG (Motion JPEG)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libmp3lame           libmp3lame MP3 (MPEG audio layer 3) (codec mp3)
+`)
+
+	encoders := parseEncoderList(output)
+	for _, name := range []string{"mjpeg", "aac", "libmp3lame"} {
+		if !encoders[name] {
+			t.Errorf("encoder %q not found in %v", name, encoders)
+		}
+	}
+	if encoders["V....."] || encoders["Video"] || len(encoders) != 3 {
+		t.Errorf("header lines parsed as encoders: %v", encoders)
+	}
+}
+
+func TestRunDoctorReportsProblems(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	helper.WriteInputFile("song.flac", []byte("audio"))
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "foo"}
+	var out bytes.Buffer
+	ok := runDoctor(config, "default", NewLinkedFFmpegRunner(LinkedFFmpegModeDirect), &out)
+
+	report := out.String()
+	if ok {
+		t.Fatalf("runDoctor passed an invalid config:\n%s", report)
+	}
+	if !strings.Contains(report, `✗ Configuration: unsupported codec "foo"`) {
+		t.Errorf("report does not flag the codec:\n%s", report)
+	}
+	if !strings.Contains(report, "Free space on "+helper.outputDir) {
+		t.Errorf("report does not check free space:\n%s", report)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, expected := range map[uint64]string{
+		512:             "512 B",
+		2048:            "2.0 KiB",
+		5 * 1024 * 1024: "5.0 MiB",
+		3 << 30:         "3.0 GiB",
+	} {
+		if got := formatBytes(n); got != expected {
+			t.Errorf("formatBytes(%d) = %q, want %q", n, got, expected)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// fakeInvocation is one recorded call to the fake backend.
+type fakeInvocation struct {
+	Tool LinkedFFmpegTool
+	Args []string
+}
+
+// Input returns the path given with -i.
+func (inv fakeInvocation) Input() string {
+	for i := 0; i+1 < len(inv.Args); i++ {
+		if inv.Args[i] == "-i" {
+			return inv.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// fakeValuelessOptions are the ffmpeg options the converter passes without a value.
+var fakeValuelessOptions = map[string]bool{"-vn": true, "-y": true, "-n": true, "-nostdin": true, "-hide_banner": true}
+
+// Outputs returns the output paths of an ffmpeg call: every argument after
+// the input that is neither an option nor an option's value.
+func (inv fakeInvocation) Outputs() []string {
+	var outputs []string
+	for i := 0; i < len(inv.Args); i++ {
+		arg := inv.Args[i]
+		switch {
+		case arg == "-i":
+			i++
+		case fakeValuelessOptions[arg]:
+		case len(arg) > 1 && arg[0] == '-':
+			i++
+		default:
+			outputs = append(outputs, arg)
+		}
+	}
+	return outputs
+}
+
+// fakeBackend stands in for FFmpeg. It records every call, writes each output
+// file, answers probes from Metadata, and fails inputs listed in Failures.
+type fakeBackend struct {
+	// Metadata is returned by ffprobe per input path; unknown paths probe as
+	// a stereo FLAC file without tags.
+	Metadata map[string]*Metadata
+	// Failures makes ffmpeg fail for an input path after writing partial outputs.
+	Failures map[string]error
+	// ProbeFailures makes ffprobe fail for an input path.
+	ProbeFailures map[string]error
+	// Delay holds each ffmpeg call open, so concurrent calls overlap.
+	Delay time.Duration
+	// ProbeDelay holds each ffprobe call open.
+	ProbeDelay time.Duration
+	// Serial runs one ffmpeg call at a time, as direct mode does, with later
+	// calls waiting their turn.
+	Serial bool
+
+	slot        chan struct{}
+	mu          sync.Mutex
+	invocations []fakeInvocation
+	running     int
+	maxRunning  int
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{
+		Metadata:      make(map[string]*Metadata),
+		Failures:      make(map[string]error),
+		ProbeFailures: make(map[string]error),
+		slot:          make(chan struct{}, 1),
+	}
+}
+
+func (f *fakeBackend) record(tool LinkedFFmpegTool, args []string) fakeInvocation {
+	inv := fakeInvocation{Tool: tool, Args: append([]string(nil), args...)}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.invocations = append(f.invocations, inv)
+	return inv
+}
+
+func (f *fakeBackend) FFmpeg(ctx context.Context, args ...string) (LinkedFFmpegResult, error) {
+	inv := f.record(LinkedFFmpegToolFFmpeg, args)
+	if f.Serial {
+		select {
+		case f.slot <- struct{}{}:
+		case <-ctx.Done():
+			return LinkedFFmpegResult{}, ctx.Err()
+		}
+		defer func() { <-f.slot }()
+	}
+
+	f.mu.Lock()
+	f.running++
+	if f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+	failure := f.Failures[inv.Input()]
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+	}()
+
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-ctx.Done():
+			return LinkedFFmpegResult{ExitCode: 255}, ctx.Err()
+		}
+	}
+
+	for _, output := range inv.Outputs() {
+		if err := os.WriteFile(output, []byte("converted "+inv.Input()), 0644); err != nil {
+			return LinkedFFmpegResult{Stderr: []byte(err.Error()), ExitCode: 1}, err
+		}
+	}
+	if failure != nil {
+		return LinkedFFmpegResult{Stderr: []byte(failure.Error()), ExitCode: 1}, errors.New("exit status 1")
+	}
+	return LinkedFFmpegResult{}, nil
+}
+
+func (f *fakeBackend) FFprobe(ctx context.Context, args ...string) (LinkedFFmpegResult, error) {
+	inv := f.record(LinkedFFmpegToolFFprobe, args)
+	input := inv.Args[len(inv.Args)-1]
+
+	f.mu.Lock()
+	metadata, ok := f.Metadata[input]
+	failure := f.ProbeFailures[input]
+	f.mu.Unlock()
+
+	if f.ProbeDelay > 0 {
+		select {
+		case <-time.After(f.ProbeDelay):
+		case <-ctx.Done():
+			return LinkedFFmpegResult{ExitCode: 255}, ctx.Err()
+		}
+	}
+	if failure != nil {
+		return LinkedFFmpegResult{Stderr: []byte(failure.Error()), ExitCode: 1}, errors.New("exit status 1")
+	}
+	if !ok {
+		metadata = &Metadata{Streams: []MetadataStream{{Index: 0, CodecType: "audio", CodecName: "flac", Channels: 2}}}
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return LinkedFFmpegResult{ExitCode: 1}, err
+	}
+	return LinkedFFmpegResult{Stdout: data}, nil
+}
+
+// Concurrency reports the limit of a serial fake, or none.
+func (f *fakeBackend) Concurrency() int {
+	if f.Serial {
+		return 1
+	}
+	return 0
+}
+
+// Calls returns the recorded calls to one tool.
+func (f *fakeBackend) Calls(tool LinkedFFmpegTool) []fakeInvocation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var calls []fakeInvocation
+	for _, inv := range f.invocations {
+		if inv.Tool == tool {
+			calls = append(calls, inv)
+		}
+	}
+	return calls
+}
+
+// MaxConcurrent is the most ffmpeg calls that were running at once.
+func (f *fakeBackend) MaxConcurrent() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxRunning
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stringListFlag collects a repeatable command-line flag.
+type stringListFlag []string
+
+func (f *stringListFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringListFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+func validateFilters(config Config) error {
+	for _, pattern := range append(append([]string(nil), config.Include...), config.Exclude...) {
+		if _, err := compileGlob(pattern); err != nil {
+			return err
+		}
+	}
+	if _, err := parseMinDuration(config.MinDuration); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseMinDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minimum duration %q: use seconds or a duration like 30s", value)
+	}
+	return d, nil
+}
+
+func hasTagFilters(config Config) bool {
+	return len(config.Genres) > 0 || len(config.Artists) > 0 || strings.TrimSpace(config.MinDuration) != ""
+}
+
+// filterFiles applies the include/exclude globs to paths relative to the input directory.
+func filterFiles(files []string, config Config) ([]string, error) {
+	if len(config.Include) == 0 && len(config.Exclude) == 0 {
+		return files, nil
+	}
+
+	var kept []string
+	for _, file := range files {
+		relPath, err := filepath.Rel(config.InputDir, file)
+		if err != nil {
+			relPath = file
+		}
+		selected, err := pathSelected(relPath, config)
+		if err != nil {
+			return nil, err
+		}
+		if selected {
+			kept = append(kept, file)
+		}
+	}
+	return kept, nil
+}
+
+func pathSelected(relPath string, config Config) (bool, error) {
+	relPath = filepath.ToSlash(relPath)
+	if len(config.Include) > 0 {
+		included := false
+		for _, pattern := range config.Include {
+			ok, err := matchGlob(pattern, relPath)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false, nil
+		}
+	}
+	for _, pattern := range config.Exclude {
+		ok, err := matchGlob(pattern, relPath)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// compileGlob splits a pattern into lowercase path segments and checks the
+// syntax of every one. A pattern without a slash is a single segment matched
+// against the file name.
+func compileGlob(pattern string) ([]string, error) {
+	pattern = strings.ToLower(filepath.ToSlash(strings.TrimSpace(pattern)))
+	segments := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	if !strings.Contains(pattern, "/") {
+		segments = []string{pattern}
+	}
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+	}
+	return segments, nil
+}
+
+// matchGlob matches a slash-separated relative path against a pattern where
+// ** spans directories. Patterns without a slash match the file name only.
+// Matching is case-insensitive.
+func matchGlob(pattern, relPath string) (bool, error) {
+	segments, err := compileGlob(pattern)
+	if err != nil {
+		return false, err
+	}
+	relPath = strings.ToLower(relPath)
+
+	if !strings.Contains(filepath.ToSlash(pattern), "/") {
+		return path.Match(segments[0], path.Base(relPath))
+	}
+	return matchGlobSegments(segments, strings.Split(relPath, "/"))
+}
+
+func matchGlobSegments(pattern, parts []string) (bool, error) {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if ok, err := matchGlobSegments(pattern[1:], parts[i:]); ok || err != nil {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+		if len(parts) == 0 {
+			return false, nil
+		}
+		ok, err := path.Match(pattern[0], parts[0])
+		if !ok || err != nil {
+			return false, err
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0, nil
+}
+
+// tagFilterReason explains why metadata fails the tag filters, or returns "".
+func tagFilterReason(metadata *Metadata, config Config) string {
+	if len(config.Genres) > 0 && !tagMatchesAny(metadata.Tag("genre"), config.Genres) {
+		return "genre"
+	}
+	if len(config.Artists) > 0 && !tagMatchesAny(metadata.Tag("artist"), config.Artists) && !tagMatchesAny(metadata.Tag("album_artist"), config.Artists) {
+		return "artist"
+	}
+	if minDuration, _ := parseMinDuration(config.MinDuration); minDuration > 0 {
+		if duration := metadata.Duration(); duration > 0 && duration < minDuration {
+			return fmt.Sprintf("shorter than %s", minDuration)
+		}
+	}
+	return ""
+}
+
+func tagMatchesAny(value string, wanted []string) bool {
+	value = strings.ToLower(value)
+	if value == "" {
+		return false
+	}
+	for _, w := range wanted {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" && strings.Contains(value, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func describeFilters(config Config) string {
+	var parts []string
+	if n := len(config.Include); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d include", n))
+	}
+	if n := len(config.Exclude); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d exclude", n))
+	}
+	if len(config.Genres) > 0 {
+		parts = append(parts, "genre: "+strings.Join(config.Genres, ", "))
+	}
+	if len(config.Artists) > 0 {
+		parts = append(parts, "artist: "+strings.Join(config.Artists, ", "))
+	}
+	if config.MinDuration != "" {
+		parts = append(parts, "min "+config.MinDuration)
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, "; ")
+}
+
+func splitListInput(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{"**/Podcasts/**", "Podcasts/show/ep1.mp3", true},
+		{"**/Podcasts/**", "Music/Podcasts/ep1.mp3", true},
+		{"**/Podcasts/**", "Music/Rock/song.mp3", false},
+		{"*.mp3", "Music/Rock/song.MP3", true},
+		{"*.mp3", "Music/Rock/song.flac", false},
+		{"Rock/*", "Rock/song.flac", true},
+		{"Rock/*", "Rock/Album/song.flac", false},
+		{"Rock/**/*.flac", "Rock/Album/Disc 1/song.flac", true},
+		{"/Rock/**", "Rock/song.flac", true},
+	}
+
+	for _, tt := range tests {
+		got, err := matchGlob(tt.pattern, tt.path)
+		if err != nil {
+			t.Fatalf("matchGlob(%q, %q) error: %v", tt.pattern, tt.path, err)
+		}
+		if got != tt.expected {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestFilterFiles(t *testing.T) {
+	config := Config{
+		InputDir: "/music",
+		Include:  []string{"Rock/**", "Jazz/**"},
+		Exclude:  []string{"**/Live/**"},
+	}
+	files := []string{
+		filepath.Join("/music", "Rock", "a.flac"),
+		filepath.Join("/music", "Rock", "Live", "b.flac"),
+		filepath.Join("/music", "Jazz", "c.flac"),
+		filepath.Join("/music", "Pop", "d.flac"),
+	}
+
+	got, err := filterFiles(files, config)
+	if err != nil {
+		t.Fatalf("filterFiles failed: %v", err)
+	}
+	if len(got) != 2 || got[0] != files[0] || got[1] != files[2] {
+		t.Fatalf("filterFiles = %v, want %v", got, []string{files[0], files[2]})
+	}
+}
+
+func TestTagFilterReason(t *testing.T) {
+	metadata := &Metadata{}
+	metadata.SetTag("genre", "Progressive Rock")
+	metadata.SetTag("artist", "The Band")
+	metadata.Streams = append(metadata.Streams, MetadataStream{CodecType: "audio", Duration: "12.5"})
+
+	tests := []struct {
+		name     string
+		config   Config
+		expected string
+	}{
+		{"no filters", Config{}, ""},
+		{"genre matches", Config{Genres: []string{"rock"}}, ""},
+		{"genre rejects", Config{Genres: []string{"Jazz"}}, "genre"},
+		{"artist matches", Config{Artists: []string{"band"}}, ""},
+		{"artist rejects", Config{Artists: []string{"Other"}}, "artist"},
+		{"long enough", Config{MinDuration: "10"}, ""},
+		{"too short", Config{MinDuration: "30s"}, "shorter than 30s"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tagFilterReason(metadata, tt.config); got != tt.expected {
+				t.Errorf("tagFilterReason = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateFilters(t *testing.T) {
+	if err := validateFilters(Config{Include: []string{"[a-"}}); err == nil {
+		t.Error("validateFilters accepted a malformed glob")
+	}
+	if err := validateFilters(Config{Exclude: []string{"a/[b"}}); err == nil {
+		t.Error("validateFilters accepted a malformed glob in a later segment")
+	}
+	if _, err := filterFiles([]string{"/music/x/y.flac"}, Config{InputDir: "/music", Exclude: []string{"a/[b"}}); err == nil {
+		t.Error("filterFiles ignored a malformed glob")
+	}
+	if err := validateFilters(Config{MinDuration: "soon"}); err == nil {
+		t.Error("validateFilters accepted a malformed duration")
+	}
+	if err := validateFilters(Config{Exclude: []string{"**/Podcasts/**"}, MinDuration: "1m30s"}); err != nil {
+		t.Errorf("validateFilters rejected valid filters: %v", err)
+	}
+}
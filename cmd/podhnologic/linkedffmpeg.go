@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
@@ -31,8 +33,10 @@ func NormalizeLinkedFFmpegTool(tool string) (LinkedFFmpegTool, error) {
 }
 
 type LinkedFFmpegRequest struct {
-	Tool LinkedFFmpegTool `json:"tool"`
-	Args []string         `json:"args"`
+	// Version is the bridge protocol version; zero means the current one.
+	Version int              `json:"version"`
+	Tool    LinkedFFmpegTool `json:"tool"`
+	Args    []string         `json:"args"`
 }
 
 func (r LinkedFFmpegRequest) Validate() error {
@@ -44,16 +48,25 @@ func (r LinkedFFmpegRequest) Validate() error {
 	}
 }
 
+// MarshalJSON stamps requests with the current protocol version.
 func (r LinkedFFmpegRequest) MarshalJSON() ([]byte, error) {
 	type alias LinkedFFmpegRequest
+	if r.Version == 0 {
+		r.Version = LinkedFFmpegProtocolVersion
+	}
 	return json.Marshal(alias(r))
 }
 
+// ParseLinkedFFmpegRequest decodes a request, refusing one written for
+// another protocol version.
 func ParseLinkedFFmpegRequest(data []byte) (LinkedFFmpegRequest, error) {
 	var req LinkedFFmpegRequest
 	if err := json.Unmarshal(data, &req); err != nil {
 		return LinkedFFmpegRequest{}, fmt.Errorf("decode linked ffmpeg request: %w", err)
 	}
+	if req.Version != LinkedFFmpegProtocolVersion {
+		return LinkedFFmpegRequest{}, fmt.Errorf("%w: request is version %d, this build speaks version %d", ErrLinkedFFmpegProtocol, req.Version, LinkedFFmpegProtocolVersion)
+	}
 	return req, req.Validate()
 }
 
@@ -68,10 +81,33 @@ type LinkedFFmpegMode string
 const (
 	LinkedFFmpegModeDirect LinkedFFmpegMode = "direct"
 	LinkedFFmpegModeHidden LinkedFFmpegMode = "hidden"
+	LinkedFFmpegModeSystem LinkedFFmpegMode = "system"
+	LinkedFFmpegModeAuto   LinkedFFmpegMode = "auto"
 )
 
+func ParseLinkedFFmpegMode(mode string) (LinkedFFmpegMode, error) {
+	switch LinkedFFmpegMode(strings.ToLower(strings.TrimSpace(mode))) {
+	case "", LinkedFFmpegModeAuto:
+		return LinkedFFmpegModeAuto, nil
+	case LinkedFFmpegModeDirect:
+		return LinkedFFmpegModeDirect, nil
+	case LinkedFFmpegModeHidden:
+		return LinkedFFmpegModeHidden, nil
+	case LinkedFFmpegModeSystem:
+		return LinkedFFmpegModeSystem, nil
+	default:
+		return "", fmt.Errorf("unsupported ffmpeg mode %q (use auto, direct, hidden, or system)", mode)
+	}
+}
+
 type LinkedFFmpegRunner struct {
 	Mode LinkedFFmpegMode
+	// FFmpegPath is the ffmpeg binary, or the directory holding ffmpeg and
+	// ffprobe, used in system mode. Empty means search PATH.
+	FFmpegPath string
+	// Pool, when set, serves hidden-mode runs from long-lived bridge
+	// workers instead of starting a bridge per call.
+	Pool *LinkedFFmpegPool
 }
 
 func NewLinkedFFmpegRunner(mode LinkedFFmpegMode) LinkedFFmpegRunner {
@@ -81,6 +117,45 @@ func NewLinkedFFmpegRunner(mode LinkedFFmpegMode) LinkedFFmpegRunner {
 	return LinkedFFmpegRunner{Mode: mode}
 }
 
+// Resolve picks a concrete mode for auto: the linked FFmpeg when this binary
+// was built with it, otherwise the system FFmpeg. Direct mode is only picked
+// where it leaves the process's standard descriptors alone.
+func (r LinkedFFmpegRunner) Resolve() LinkedFFmpegRunner {
+	if r.Mode != LinkedFFmpegModeAuto {
+		return r
+	}
+	switch {
+	case linkedFFmpegNativeBuilt && linkedFFmpegHiddenBuilt:
+		r.Mode = LinkedFFmpegModeHidden
+	case linkedFFmpegNativeBuilt && linkedFFmpegDirectSupported:
+		r.Mode = LinkedFFmpegModeDirect
+	default:
+		r.Mode = LinkedFFmpegModeSystem
+	}
+	return r
+}
+
+// ProbeMetadata reads a file's metadata with the linked libavformat in this
+// process. Only direct mode, which runs the tools in this process anyway,
+// probes here. Other modes and builds without the linked FFmpeg return
+// ErrLinkedFFmpegUnavailable, leaving probing to ffprobe, so hidden mode
+// opens untrusted files in bridge workers under their limits.
+func (r LinkedFFmpegRunner) ProbeMetadata(ctx context.Context, path string) (*Metadata, error) {
+	if mode := r.Resolve().Mode; mode != LinkedFFmpegModeDirect && mode != "" {
+		return nil, fmt.Errorf("%w: %s mode probes with ffprobe", ErrLinkedFFmpegUnavailable, mode)
+	}
+	return probeLinkedMetadata(ctx, path)
+}
+
+// Concurrency is how many runs make progress at once, or 0 for no limit.
+// Direct mode runs the tools in this process one at a time.
+func (r LinkedFFmpegRunner) Concurrency() int {
+	if r.Resolve().Mode == LinkedFFmpegModeDirect {
+		return 1
+	}
+	return 0
+}
+
 func DefaultLinkedFFmpegRunner() LinkedFFmpegRunner {
 	return NewLinkedFFmpegRunner(LinkedFFmpegModeDirect)
 }
@@ -93,21 +168,71 @@ func (r LinkedFFmpegRunner) FFprobe(ctx context.Context, args ...string) (Linked
 	return r.run(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFprobe, Args: append([]string(nil), args...)})
 }
 
+// run buffers a whole run's output for the FFmpeg and FFprobe methods.
 func (r LinkedFFmpegRunner) run(ctx context.Context, req LinkedFFmpegRequest) (LinkedFFmpegResult, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := r.Stream(ctx, req, LinkedFFmpegStreams{Stdout: &stdout, Stderr: &stderr})
+	return LinkedFFmpegResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitCode}, err
+}
+
+// LinkedFFmpegStreams connects a run to the caller. Output is written as the
+// tool produces it; nil writers discard it, and a nil Stdin gives the tool no
+// input.
+type LinkedFFmpegStreams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (s LinkedFFmpegStreams) withDefaults() LinkedFFmpegStreams {
+	if s.Stdin == nil {
+		s.Stdin = strings.NewReader("")
+	}
+	if s.Stdout == nil {
+		s.Stdout = io.Discard
+	}
+	if s.Stderr == nil {
+		s.Stderr = io.Discard
+	}
+	return s
+}
+
+func (r LinkedFFmpegRunner) StreamFFmpeg(ctx context.Context, streams LinkedFFmpegStreams, args ...string) (int, error) {
+	return r.Stream(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: append([]string(nil), args...)}, streams)
+}
+
+func (r LinkedFFmpegRunner) StreamFFprobe(ctx context.Context, streams LinkedFFmpegStreams, args ...string) (int, error) {
+	return r.Stream(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFprobe, Args: append([]string(nil), args...)}, streams)
+}
+
+// Stream runs a request with the caller's streams and returns the tool's exit
+// code. It returns once the tool has exited and its output has been written.
+func (r LinkedFFmpegRunner) Stream(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
 	if err := req.Validate(); err != nil {
-		return LinkedFFmpegResult{}, err
+		return 0, err
 	}
+	streams = streams.withDefaults()
 
 	switch r.Mode {
+	case LinkedFFmpegModeAuto:
+		return r.Resolve().Stream(ctx, req, streams)
+	case LinkedFFmpegModeSystem:
+		return runSystemFFmpeg(ctx, r.FFmpegPath, req, streams)
 	case LinkedFFmpegModeHidden:
-		return runLinkedFFmpegHidden(ctx, req)
+		if r.Pool != nil {
+			return r.Pool.Stream(ctx, req, streams)
+		}
+		return runLinkedFFmpegHidden(ctx, req, streams)
 	case LinkedFFmpegModeDirect, "":
 		fallthrough
 	default:
-		return runLinkedFFmpegNative(ctx, req)
+		if linkedFFmpegNativeBuilt && !linkedFFmpegDirectSupported {
+			return 0, fmt.Errorf("%w: direct mode would redirect this process's stdout on this platform; use --ffmpeg-mode hidden", ErrLinkedFFmpegUnavailable)
+		}
+		return runLinkedFFmpegNative(ctx, req, streams)
 	}
 }
 
@@ -126,3 +251,36 @@ func RunLinkedFFmpegHidden(ctx context.Context, args ...string) (LinkedFFmpegRes
 func RunLinkedFFprobeHidden(ctx context.Context, args ...string) (LinkedFFmpegResult, error) {
 	return NewLinkedFFmpegRunner(LinkedFFmpegModeHidden).FFprobe(ctx, args...)
 }
+
+// linkedFFmpegDirectArgs points the standard stream pipes named in args at
+// the descriptors of one in-process run, which never touches the process's
+// own standard streams. pipe:0 and pipe:1 always mean stdin and stdout; "-"
+// and "pipe:" mean stdin after -i, stdout as ffprobe's -o, and stdout as
+// ffmpeg's last argument. ffmpeg also gets -nostdin so it never reads the
+// terminal for keyboard commands.
+func linkedFFmpegDirectArgs(tool LinkedFFmpegTool, args []string, stdinFD, stdoutFD uintptr) []string {
+	stdin := fmt.Sprintf("pipe:%d", stdinFD)
+	stdout := fmt.Sprintf("pipe:%d", stdoutFD)
+
+	var out []string
+	if tool == LinkedFFmpegToolFFmpeg {
+		out = append(out, "-nostdin")
+	}
+	for i, arg := range args {
+		prev := ""
+		if i > 0 {
+			prev = args[i-1]
+		}
+		bare := arg == "-" || arg == "pipe:"
+		switch {
+		case arg == "pipe:0", bare && prev == "-i":
+			arg = stdin
+		case arg == "pipe:1", bare && tool == LinkedFFmpegToolFFprobe && prev == "-o":
+			arg = stdout
+		case bare && tool == LinkedFFmpegToolFFmpeg && i == len(args)-1:
+			arg = stdout
+		}
+		out = append(out, arg)
+	}
+	return out
+}
@@ -7,12 +7,14 @@ import (
 	"fmt"
 )
 
-func runLinkedFFmpegHidden(ctx context.Context, req LinkedFFmpegRequest) (LinkedFFmpegResult, error) {
+const linkedFFmpegHiddenBuilt = false
+
+func runLinkedFFmpegHidden(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
 	if ctx != nil {
 		if err := ctx.Err(); err != nil {
-			return LinkedFFmpegResult{}, err
+			return 0, err
 		}
 	}
 
-	return LinkedFFmpegResult{}, fmt.Errorf("%w: build with -tags linkedffmpeg_hidden", ErrLinkedFFmpegUnavailable)
+	return 0, fmt.Errorf("%w: build with -tags linkedffmpeg_hidden", ErrLinkedFFmpegUnavailable)
 }
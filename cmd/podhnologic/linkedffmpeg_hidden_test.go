@@ -4,6 +4,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
@@ -13,34 +14,32 @@ import (
 	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
-func TestLinkedFFmpegBridgeHandlerUnavailableWithoutCgo(t *testing.T) {
-	req := LinkedFFmpegRequest{
-		Tool: LinkedFFmpegToolFFprobe,
-		Args: []string{"-version"},
-	}
+var linkedTestBackend = NewLinkedFFmpegRunner(LinkedFFmpegModeHidden)
 
-	payload, err := json.Marshal(req)
-	if err != nil {
-		t.Fatalf("json.Marshal failed: %v", err)
-	}
+func TestLinkedFFmpegWorkerUnavailableWithoutCgo(t *testing.T) {
+	conn := newTestConn(t, linkedFFmpegWorkerHello(), runLinkedFFmpegNative)
 
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
-
-	code, err := handleLinkedFFmpegBridge(bytes.NewReader(payload), &stdout, &stderr)
-	if err == nil {
-		if code != 0 {
-			t.Fatalf("handleLinkedFFmpegBridge code = %d, want 0", code)
+	req := LinkedFFmpegRequest{
+		Tool: LinkedFFmpegToolFFprobe,
+		Args: []string{"-version"},
+	}
+	resp, err := conn.Do(context.Background(), req, LinkedFFmpegStreams{Stdout: &stdout, Stderr: &stderr}, time.Second)
+	if linkedFFmpegNativeBuilt {
+		if err != nil || resp.ExitCode != 0 {
+			t.Fatalf("worker ffprobe = %+v, %v, want success", resp, err)
 		}
 		return
 	}
-	if !errors.Is(err, ErrLinkedFFmpegUnavailable) {
-		t.Fatalf("handleLinkedFFmpegBridge error = %v, want ErrLinkedFFmpegUnavailable", err)
+	if !errors.Is(err, ErrLinkedFFmpegUnavailable) || resp.ErrorClass != LinkedFFmpegErrorUnavailable {
+		t.Fatalf("worker ffprobe = %+v, %v, want ErrLinkedFFmpegUnavailable", resp, err)
 	}
-	if code == 0 {
-		t.Fatal("handleLinkedFFmpegBridge code = 0, want non-zero")
+	if resp.ExitCode == 0 {
+		t.Fatal("worker ffprobe exit code = 0, want non-zero")
 	}
 	if stdout.Len() != 0 {
 		t.Fatalf("stdout len = %d, want 0", stdout.Len())
@@ -50,6 +49,31 @@ func TestLinkedFFmpegBridgeHandlerUnavailableWithoutCgo(t *testing.T) {
 	}
 }
 
+func TestLinkedFFmpegWorkerCPULimitIsATimeout(t *testing.T) {
+	if !linkedFFmpegNativeBuilt || !linkedFFmpegLimitsSupported {
+		t.Skip("needs the linked FFmpeg and resource limits")
+	}
+	source := filepath.Join(t.TempDir(), "source.wav")
+	writePCM16WAV(t, source, 48000, 48000)
+
+	pool := NewLinkedFFmpegPool(1, 10)
+	pool.Limits = LinkedFFmpegLimits{CPU: time.Second}
+	t.Cleanup(pool.Close)
+
+	// Encoding the source over and over never ends on its own
+	start := time.Now()
+	_, err := pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{
+		"-stream_loop", "-1", "-i", source, "-c:a", "flac", "-compression_level", "12", "-f", "null", "-",
+	}})
+	var linkedErr *LinkedFFmpegError
+	if !errors.As(err, &linkedErr) || linkedErr.Class != LinkedFFmpegErrorTimeout {
+		t.Fatalf("CPU-limited ffmpeg error = %v, want the timeout class", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Fatalf("CPU-limited ffmpeg took %v", elapsed)
+	}
+}
+
 func TestLinkedFFmpegConvertsM4AWithAttachedPNG(t *testing.T) {
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		t.Skip("system ffmpeg is required to synthesize the attached-art fixture")
@@ -97,12 +121,12 @@ func TestLinkedFFmpegConvertsM4AWithAttachedPNG(t *testing.T) {
 		OutputDir: outputDir,
 		Codec:     "flac",
 	}
-	if err := processFile(inputPath, config, false); err != nil {
+	if err := processFile(linkedTestBackend, inputPath, config, false); err != nil {
 		t.Fatalf("processFile failed: %v", err)
 	}
 
 	outputPath := filepath.Join(outputDir, "cover-art.flac")
-	result, err := runFFprobe([]string{
+	result, err := runFFprobe(linkedTestBackend, []string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_streams",
@@ -148,7 +172,7 @@ func TestLinkedFFmpegEncodersFromFLAC(t *testing.T) {
 	writePCM16WAV(t, wavPath, 48000, 48000)
 
 	sourceFLAC := filepath.Join(inputDir, "encoder-source.flac")
-	if output, err := runFFmpeg([]string{
+	if output, err := runFFmpeg(linkedTestBackend, []string{
 		"-y",
 		"-i", wavPath,
 		"-metadata", "title=Encoder Proof",
@@ -225,7 +249,7 @@ func TestLinkedFFmpegEncodersFromFLAC(t *testing.T) {
 				Codec:     tt.codec,
 				IPod:      tt.ipod,
 			}
-			if err := processFile(sourceFLAC, config, false); err != nil {
+			if err := processFile(linkedTestBackend, sourceFLAC, config, false); err != nil {
 				t.Fatalf("processFile failed: %v", err)
 			}
 
@@ -256,7 +280,7 @@ func assertAudioStream(t *testing.T, path, wantCodec, wantSampleRate, wantSample
 		t.Fatalf("output file is empty: %s", path)
 	}
 
-	result, err := runFFprobe([]string{
+	result, err := runFFprobe(linkedTestBackend, []string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_streams",
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Environment variables that carry resource limits to a bridge worker.
+const (
+	linkedFFmpegCPULimitEnv    = "PODHNOLOGIC_LINKED_FFMPEG_CPU_LIMIT"
+	linkedFFmpegMemoryLimitEnv = "PODHNOLOGIC_LINKED_FFMPEG_MEMORY_LIMIT"
+)
+
+// linkedFFmpegCPULimitExitCode is what a worker exits with once a request
+// uses up its CPU time, so the parent can tell that from a crash. It is the
+// shell's code for a process killed by SIGXCPU.
+const linkedFFmpegCPULimitExitCode = 128 + 24
+
+// LinkedFFmpegLimits bounds the resources of a bridge worker. CPU is the CPU
+// time each request may use and Memory the address space of the whole worker
+// in bytes. A worker that runs out of CPU time exits. Zero means no limit.
+type LinkedFFmpegLimits struct {
+	CPU    time.Duration
+	Memory int64
+}
+
+func (l LinkedFFmpegLimits) IsZero() bool {
+	return l.CPU <= 0 && l.Memory <= 0
+}
+
+// env passes the limits to a worker, with CPU time in whole seconds.
+func (l LinkedFFmpegLimits) env() []string {
+	var env []string
+	if l.CPU > 0 {
+		seconds := int64((l.CPU + time.Second - 1) / time.Second)
+		env = append(env, linkedFFmpegCPULimitEnv+"="+strconv.FormatInt(seconds, 10))
+	}
+	if l.Memory > 0 {
+		env = append(env, linkedFFmpegMemoryLimitEnv+"="+strconv.FormatInt(l.Memory, 10))
+	}
+	return env
+}
+
+// linkedFFmpegLimitsFromEnv reads the limits a worker was started with.
+func linkedFFmpegLimitsFromEnv(getenv func(string) string) (LinkedFFmpegLimits, error) {
+	var limits LinkedFFmpegLimits
+	if value := getenv(linkedFFmpegCPULimitEnv); value != "" {
+		seconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || seconds <= 0 {
+			return limits, fmt.Errorf("invalid %s %q", linkedFFmpegCPULimitEnv, value)
+		}
+		limits.CPU = time.Duration(seconds) * time.Second
+	}
+	if value := getenv(linkedFFmpegMemoryLimitEnv); value != "" {
+		bytes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || bytes <= 0 {
+			return limits, fmt.Errorf("invalid %s %q", linkedFFmpegMemoryLimitEnv, value)
+		}
+		limits.Memory = bytes
+	}
+	return limits, nil
+}
+
+// checkLinkedFFmpegLimits reports whether limits can be enforced here.
+func checkLinkedFFmpegLimits(limits LinkedFFmpegLimits) error {
+	if !limits.IsZero() && !linkedFFmpegLimitsSupported {
+		return fmt.Errorf("CPU and memory limits are not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// applyLinkedFFmpegLimits limits the worker's memory and wraps run so every
+// request gets its own CPU allowance.
+func applyLinkedFFmpegLimits(limits LinkedFFmpegLimits, run linkedFFmpegRunFunc) (linkedFFmpegRunFunc, error) {
+	if err := checkLinkedFFmpegLimits(limits); err != nil {
+		return nil, err
+	}
+	if limits.Memory > 0 {
+		if err := setLinkedFFmpegMemoryLimit(limits.Memory); err != nil {
+			return nil, err
+		}
+	}
+	if limits.CPU <= 0 {
+		return run, nil
+	}
+
+	watchLinkedFFmpegCPULimit()
+	return func(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
+		deadline, err := setLinkedFFmpegCPULimit(limits.CPU)
+		if err != nil {
+			return 1, err
+		}
+		code, err := run(ctx, req, streams)
+		// ffmpeg catches SIGXCPU itself and stops as if interrupted, so a run
+		// that used up its CPU time can return without the watcher seeing it
+		if used, usageErr := linkedFFmpegCPUTime(); usageErr == nil && used >= deadline {
+			exitLinkedFFmpegCPULimit()
+		}
+		return code, err
+	}, nil
+}
+
+// exitLinkedFFmpegCPULimit ends a worker whose request used up its CPU time,
+// before it answers, so the parent reports the request as timed out.
+func exitLinkedFFmpegCPULimit() {
+	fmt.Fprintln(os.Stderr, "linked ffmpeg worker ran out of CPU time")
+	os.Exit(linkedFFmpegCPULimitExitCode)
+}
@@ -5,13 +5,51 @@ package main
 /*
 #include <stdint.h>
 #include <stdlib.h>
+#ifdef _WIN32
+#include <io.h>
+#include <windows.h>
+#else
+#include <unistd.h>
+#endif
 
 extern int podhnologic_linked_ffmpeg_main(const char *tool, int argc, const char **argv, int stdout_fd, int stderr_fd);
+extern void podhnologic_linked_ffmpeg_reset_interrupt(void);
+extern void podhnologic_linked_ffmpeg_interrupt(void);
+extern int podhnologic_linked_ffmpeg_swaps_stdio(void);
+extern void podhnologic_linked_ffmpeg_allow_stdout_redirect(void);
+
+// podhnologic_open_fd returns a C runtime descriptor for a duplicate of an
+// os.File's descriptor, or handle on Windows, for the tools to use.
+static int podhnologic_open_fd(uintptr_t fd)
+{
+#ifdef _WIN32
+    HANDLE process = GetCurrentProcess();
+    HANDLE dup;
+    int crt_fd;
+
+    if (!DuplicateHandle(process, (HANDLE)fd, process, &dup, 0, FALSE, DUPLICATE_SAME_ACCESS))
+        return -1;
+    crt_fd = _open_osfhandle((intptr_t)dup, 0);
+    if (crt_fd < 0)
+        CloseHandle(dup);
+    return crt_fd;
+#else
+    return dup((int)fd);
+#endif
+}
+
+static void podhnologic_close_fd(int fd)
+{
+#ifdef _WIN32
+    _close(fd);
+#else
+    close(fd);
+#endif
+}
 */
 import "C"
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -20,45 +58,106 @@ import (
 	"unsafe"
 )
 
-func runLinkedFFmpegNative(ctx context.Context, req LinkedFFmpegRequest) (LinkedFFmpegResult, error) {
-	if ctx != nil {
-		if err := ctx.Err(); err != nil {
-			return LinkedFFmpegResult{}, err
-		}
+const linkedFFmpegNativeBuilt = true
+
+// linkedFFmpegDirectSupported reports whether the bridge can run the tools
+// in this process without redirecting its standard descriptors.
+var linkedFFmpegDirectSupported = C.podhnologic_linked_ffmpeg_swaps_stdio() != 0
+
+// allowLinkedFFmpegStdoutRedirect lets runs move descriptor 1 where the C
+// streams cannot be swapped. Only bridge workers call it.
+func allowLinkedFFmpegStdoutRedirect() {
+	C.podhnologic_linked_ffmpeg_allow_stdout_redirect()
+}
+
+// linkedFFmpegNativeSlot admits one run at a time. The tools' mains keep
+// global state, such as options, signal flags and the log level, so runs in
+// this process cannot overlap.
+var linkedFFmpegNativeSlot = make(chan struct{}, 1)
+
+// runLinkedFFmpegNative runs the tool in this process. Its input and output
+// are pipes that are copied to and from the caller's streams while it runs;
+// logs reach the stderr pipe through an av_log callback and the process's own
+// standard streams are left alone. Runs wait for each other. Canceling ctx
+// interrupts ffmpeg as SIGTERM would and the run returns ctx.Err(); ffprobe
+// runs to the end once started.
+func runLinkedFFmpegNative(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case linkedFFmpegNativeSlot <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-linkedFFmpegNativeSlot }()
+	if err := ctx.Err(); err != nil {
+		return 0, err
 	}
 
 	stdoutR, stdoutW, err := os.Pipe()
 	if err != nil {
-		return LinkedFFmpegResult{}, fmt.Errorf("create linked ffmpeg stdout pipe: %w", err)
+		return 0, fmt.Errorf("create linked ffmpeg stdout pipe: %w", err)
 	}
 	defer stdoutR.Close()
 	defer stdoutW.Close()
 
 	stderrR, stderrW, err := os.Pipe()
 	if err != nil {
-		return LinkedFFmpegResult{}, fmt.Errorf("create linked ffmpeg stderr pipe: %w", err)
+		return 0, fmt.Errorf("create linked ffmpeg stderr pipe: %w", err)
 	}
 	defer stderrR.Close()
 	defer stderrW.Close()
 
-	var stdoutBuf bytes.Buffer
-	var stderrBuf bytes.Buffer
+	// A file is handed over as is; any other reader is fed through a pipe
+	stdinFile, ok := streams.Stdin.(*os.File)
+	if !ok {
+		stdinR, stdinW, err := os.Pipe()
+		if err != nil {
+			return 0, fmt.Errorf("create linked ffmpeg stdin pipe: %w", err)
+		}
+		defer stdinR.Close()
+		// The copy may still be blocked reading the caller's stdin when the
+		// tool exits; closing the pipe makes its next write fail
+		go func() {
+			_, _ = io.Copy(stdinW, streams.Stdin)
+			_ = stdinW.Close()
+		}()
+		stdinFile = stdinR
+	}
+
+	// The tools get their own descriptors, closed once they return so the
+	// output copies see the end of the pipes
+	var fds [3]C.int
+	for i, f := range []*os.File{stdinFile, stdoutW, stderrW} {
+		fds[i] = C.podhnologic_open_fd(C.uintptr_t(f.Fd()))
+		if fds[i] < 0 {
+			for _, fd := range fds[:i] {
+				C.podhnologic_close_fd(fd)
+			}
+			return 0, fmt.Errorf("open linked ffmpeg descriptor for %s", f.Name())
+		}
+	}
+
+	var stdoutErr, stderrErr error
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(&stdoutBuf, stdoutR)
+		_, stdoutErr = io.Copy(streams.Stdout, stdoutR)
 	}()
 	go func() {
 		defer wg.Done()
-		_, _ = io.Copy(&stderrBuf, stderrR)
+		_, stderrErr = io.Copy(streams.Stderr, stderrR)
 	}()
 
+	args := linkedFFmpegDirectArgs(req.Tool, req.Args, uintptr(fds[0]), uintptr(fds[1]))
+
 	cTool := C.CString(string(req.Tool))
 	defer C.free(unsafe.Pointer(cTool))
 
-	cArgs := make([]*C.char, len(req.Args))
-	for i, arg := range req.Args {
+	cArgs := make([]*C.char, len(args))
+	for i, arg := range args {
 		cArgs[i] = C.CString(arg)
 		defer C.free(unsafe.Pointer(cArgs[i]))
 	}
@@ -68,27 +167,48 @@ func runLinkedFFmpegNative(ctx context.Context, req LinkedFFmpegRequest) (Linked
 		argPtr = (**C.char)(unsafe.Pointer(&cArgs[0]))
 	}
 
+	// Any interrupt from here on reaches this run, even one sent before the
+	// tool has started
+	C.podhnologic_linked_ffmpeg_reset_interrupt()
+	finished := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			C.podhnologic_linked_ffmpeg_interrupt()
+		case <-finished:
+		}
+	}()
+
 	exitCode := int(C.podhnologic_linked_ffmpeg_main(
 		cTool,
 		C.int(len(cArgs)),
 		argPtr,
-		C.int(stdoutW.Fd()),
-		C.int(stderrW.Fd()),
+		fds[1],
+		fds[2],
 	))
 
+	close(finished)
+	for _, fd := range fds {
+		C.podhnologic_close_fd(fd)
+	}
+
 	_ = stdoutW.Close()
 	_ = stderrW.Close()
 	wg.Wait()
 
-	result := LinkedFFmpegResult{
-		Stdout:   append([]byte(nil), stdoutBuf.Bytes()...),
-		Stderr:   append([]byte(nil), stderrBuf.Bytes()...),
-		ExitCode: exitCode,
+	// A run that finished before the interrupt reached it still succeeds
+	if exitCode != 0 && ctx.Err() != nil {
+		return exitCode, ctx.Err()
 	}
 
 	if exitCode != 0 {
-		return result, fmt.Errorf("linked ffmpeg %s exited with code %d", req.Tool, exitCode)
+		return exitCode, fmt.Errorf("linked ffmpeg %s exited with code %d", req.Tool, exitCode)
 	}
-
-	return result, nil
+	if stdoutErr != nil {
+		return exitCode, fmt.Errorf("write linked ffmpeg stdout: %w", stdoutErr)
+	}
+	if stderrErr != nil {
+		return exitCode, fmt.Errorf("write linked ffmpeg stderr: %w", stderrErr)
+	}
+	return exitCode, nil
 }
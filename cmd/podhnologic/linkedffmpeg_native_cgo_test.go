@@ -0,0 +1,89 @@
+//go:build linkedffmpeg_cgo && cgo
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// zeroReader is endless silence.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// skipWithoutDirect skips tests of direct mode where it is refused.
+func skipWithoutDirect(t *testing.T) {
+	t.Helper()
+	if !linkedFFmpegDirectSupported {
+		t.Skip("direct mode is refused on this platform")
+	}
+}
+
+func TestLinkedFFmpegDirectCancel(t *testing.T) {
+	skipWithoutDirect(t)
+	runner := NewLinkedFFmpegRunner(LinkedFFmpegModeDirect)
+	output := filepath.Join(t.TempDir(), "endless.wav")
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	var stderr bytes.Buffer
+	start := time.Now()
+	code, err := runner.StreamFFmpeg(ctx, LinkedFFmpegStreams{Stdin: zeroReader{}, Stderr: &stderr},
+		"-nostdin", "-re", "-f", "s16le", "-ar", "48000", "-ac", "1", "-i", "pipe:0", "-y", output)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled run = %d, %v, want context.Canceled\n%s", code, err, stderr.String())
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("canceled run took %v", elapsed)
+	}
+
+	// The interrupt does not leak into the next run
+	if result, err := runner.FFmpeg(context.Background(), "-version"); err != nil {
+		t.Fatalf("run after cancel failed: %v\n%s", err, result.Stderr)
+	}
+}
+
+func TestLinkedFFmpegDirectRunsKeepTheirOwnOutput(t *testing.T) {
+	skipWithoutDirect(t)
+	runner := NewLinkedFFmpegRunner(LinkedFFmpegModeDirect)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			version, err := runner.FFmpeg(context.Background(), "-hide_banner", "-version")
+			if err != nil || !strings.HasPrefix(string(version.Stdout), "ffmpeg version") {
+				t.Errorf("ffmpeg -version = %q, %v", version.Stdout, err)
+			}
+			missing, err := runner.FFprobe(context.Background(), "-hide_banner", "missing.flac")
+			if err == nil || !strings.Contains(string(missing.Stderr), "missing.flac") || len(missing.Stdout) != 0 {
+				t.Errorf("ffprobe of a missing file = %q, %q, %v", missing.Stdout, missing.Stderr, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestLinkedFFmpegDirectRefusedWithoutStdioSwap(t *testing.T) {
+	if linkedFFmpegDirectSupported {
+		t.Skip("direct mode is supported on this platform")
+	}
+	if mode := NewLinkedFFmpegRunner(LinkedFFmpegModeAuto).Resolve().Mode; mode == LinkedFFmpegModeDirect {
+		t.Fatalf("auto resolved to direct mode")
+	}
+	_, err := NewLinkedFFmpegRunner(LinkedFFmpegModeDirect).FFmpeg(context.Background(), "-version")
+	if !errors.Is(err, ErrLinkedFFmpegUnavailable) {
+		t.Fatalf("direct run error = %v, want ErrLinkedFFmpegUnavailable", err)
+	}
+}
@@ -7,12 +7,18 @@ import (
 	"fmt"
 )
 
-func runLinkedFFmpegNative(ctx context.Context, req LinkedFFmpegRequest) (LinkedFFmpegResult, error) {
+const linkedFFmpegNativeBuilt = false
+
+const linkedFFmpegDirectSupported = false
+
+func allowLinkedFFmpegStdoutRedirect() {}
+
+func runLinkedFFmpegNative(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
 	if ctx != nil {
 		if err := ctx.Err(); err != nil {
-			return LinkedFFmpegResult{}, err
+			return 0, err
 		}
 	}
 
-	return LinkedFFmpegResult{}, fmt.Errorf("%w: build with -tags linkedffmpeg_cgo", ErrLinkedFFmpegUnavailable)
+	return 0, fmt.Errorf("%w: build with -tags linkedffmpeg_cgo", ErrLinkedFFmpegUnavailable)
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// linkedFFmpegBridgeWorker is the bridge env value that starts a worker.
+const linkedFFmpegBridgeWorker = "worker"
+
+// DefaultLinkedFFmpegPoolJobs is how many requests a worker serves before it
+// is replaced, bounding any state FFmpeg leaks between runs.
+const DefaultLinkedFFmpegPoolJobs = 200
+
+// LinkedFFmpegPool keeps bridge worker processes running between requests,
+// so a conversion does not pay a process start for every probe and encode.
+// Workers are replaced after MaxJobs requests, when one crashes, and when one
+// does not stop a canceled request in time.
+type LinkedFFmpegPool struct {
+	// MaxJobs is how many requests a worker serves before it is replaced.
+	MaxJobs int
+	// CancelGrace is how long a worker has to stop a canceled request.
+	CancelGrace time.Duration
+	// Limits bounds the CPU and memory of each worker.
+	Limits LinkedFFmpegLimits
+	// Command starts a worker; by default this binary in bridge worker mode
+	// with Limits.
+	Command func() (*exec.Cmd, error)
+
+	slots  chan struct{}
+	mu     sync.Mutex
+	idle   []*linkedFFmpegConn
+	closed bool
+}
+
+// NewLinkedFFmpegPool returns a pool running at most size workers at once.
+// Workers start on demand.
+func NewLinkedFFmpegPool(size, maxJobs int) *LinkedFFmpegPool {
+	if size < 1 {
+		size = 1
+	}
+	if maxJobs < 1 {
+		maxJobs = DefaultLinkedFFmpegPoolJobs
+	}
+	pool := &LinkedFFmpegPool{
+		MaxJobs:     maxJobs,
+		CancelGrace: DefaultLinkedFFmpegCancelGrace,
+		slots:       make(chan struct{}, size),
+	}
+	pool.Command = func() (*exec.Cmd, error) {
+		return linkedFFmpegWorkerCommand(pool.Limits)
+	}
+	return pool
+}
+
+func linkedFFmpegWorkerCommand(limits LinkedFFmpegLimits) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("resolve linked ffmpeg executable: %w", err)
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), linkedFFmpegBridgeEnv+"="+linkedFFmpegBridgeWorker)
+	cmd.Env = append(cmd.Env, limits.env()...)
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
+
+// Run sends a request to a worker and collects its output.
+func (p *LinkedFFmpegPool) Run(ctx context.Context, req LinkedFFmpegRequest) (LinkedFFmpegResult, error) {
+	var stdout, stderr bytes.Buffer
+	exitCode, err := p.Stream(ctx, req, LinkedFFmpegStreams{Stdout: &stdout, Stderr: &stderr})
+	return LinkedFFmpegResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitCode}, err
+}
+
+// Stream runs a request on a worker with the caller's streams and returns the
+// tool's exit code.
+func (p *LinkedFFmpegPool) Stream(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := req.Validate(); err != nil {
+		return 0, err
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	defer func() { <-p.slots }()
+
+	// An idle worker may have died since its last job; a request it never
+	// received is safe to retry once on a fresh worker
+	for attempt := 0; ; attempt++ {
+		conn, err := p.acquire()
+		if err != nil {
+			return 0, err
+		}
+		// Requests the worker saw, including ones that timed out, are never
+		// run again
+		resp, err := conn.Do(ctx, req, streams, p.CancelGrace)
+		if errors.Is(err, errLinkedFFmpegNotSent) {
+			conn.kill()
+			if attempt == 0 {
+				continue
+			}
+			return 0, fmt.Errorf("send linked ffmpeg request: %w", err)
+		}
+
+		if conn.alive() {
+			conn.jobs++
+			p.release(conn)
+		} else {
+			conn.kill()
+		}
+		return resp.ExitCode, err
+	}
+}
+
+func (p *LinkedFFmpegPool) acquire() (*linkedFFmpegConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errors.New("linked ffmpeg pool is closed")
+	}
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	cmd, err := p.Command()
+	if err != nil {
+		return nil, err
+	}
+	return startLinkedFFmpegConn(cmd)
+}
+
+// release returns a healthy worker to the pool, or retires it once it has
+// served its jobs.
+func (p *LinkedFFmpegPool) release(conn *linkedFFmpegConn) {
+	p.mu.Lock()
+	if p.closed || conn.jobs >= p.MaxJobs {
+		p.mu.Unlock()
+		conn.retire()
+		return
+	}
+	p.idle = append(p.idle, conn)
+	p.mu.Unlock()
+}
+
+// Close retires the idle workers. Requests still running finish first and
+// their workers are retired when they return.
+func (p *LinkedFFmpegPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+	for _, conn := range idle {
+		conn.retire()
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// poolTestWorkerEnv turns the test binary into a bridge worker that serves
+// fakeWorkerRun instead of the linked FFmpeg. The value "old" makes it
+// announce a protocol version this build does not speak.
+const poolTestWorkerEnv = "PODHNOLOGIC_TEST_POOL_WORKER"
+
+func TestMain(m *testing.M) {
+	if value := os.Getenv(poolTestWorkerEnv); value != "" {
+		hello := fakeWorkerHello()
+		if value == "old" {
+			hello.Version = 0
+		}
+		limits, err := linkedFFmpegLimitsFromEnv(os.Getenv)
+		var run linkedFFmpegRunFunc
+		if err == nil {
+			run, err = applyLinkedFFmpegLimits(limits, fakeWorkerRun)
+		}
+		if err == nil {
+			err = serveLinkedFFmpegWorker(os.Stdin, os.Stdout, hello, run)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func fakeWorkerHello() LinkedFFmpegHello {
+	return LinkedFFmpegHello{
+		Version:      LinkedFFmpegProtocolVersion,
+		PID:          os.Getpid(),
+		Capabilities: []string{linkedFFmpegCapabilityStdin, linkedFFmpegCapabilityCancel, string(LinkedFFmpegToolFFmpeg), string(LinkedFFmpegToolFFprobe)},
+	}
+}
+
+// fakeWorkerTrapCPULimit takes SIGXCPU away from the worker's watcher, as
+// ffmpeg's own signal handlers do. It is set where CPU limits are supported.
+var fakeWorkerTrapCPULimit = func() {}
+
+// fakeWorkerRun acts on the first argument: pid reports the worker's process,
+// fail exits 3, crash kills the worker, sleep blocks until canceled, hang
+// ignores cancellation, spin burns CPU, trapped-spin burns CPU for three
+// seconds with SIGXCPU trapped, and cat copies stdin to stdout. Anything else
+// is echoed.
+func fakeWorkerRun(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
+	switch req.Args[0] {
+	case "pid":
+		fmt.Fprint(streams.Stdout, os.Getpid())
+		return 0, nil
+	case "fail":
+		fmt.Fprint(streams.Stderr, "Invalid data found")
+		return 3, errors.New("linked ffmpeg ffmpeg exited with code 3")
+	case "crash":
+		os.Exit(2)
+	case "sleep":
+		select {
+		case <-ctx.Done():
+			return 255, ctx.Err()
+		case <-time.After(time.Minute):
+		}
+	case "hang":
+		time.Sleep(time.Minute)
+	case "spin":
+		for deadline := time.Now().Add(time.Minute); time.Now().Before(deadline); {
+		}
+	case "trapped-spin":
+		fakeWorkerTrapCPULimit()
+		for deadline := time.Now().Add(3 * time.Second); time.Now().Before(deadline); {
+		}
+		return 255, errors.New("linked ffmpeg ffmpeg exited with code 255")
+	case "cat":
+		if _, err := io.Copy(streams.Stdout, streams.Stdin); err != nil {
+			return 1, err
+		}
+		return 0, nil
+	}
+	fmt.Fprint(streams.Stdout, strings.Join(req.Args, " "))
+	return 0, nil
+}
+
+// newTestConn serves run in this process over pipes and connects to it.
+func newTestConn(t *testing.T, hello LinkedFFmpegHello, run linkedFFmpegRunFunc) *linkedFFmpegConn {
+	t.Helper()
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	go func() {
+		_ = serveLinkedFFmpegWorker(inR, outW, hello, run)
+		_ = outW.Close()
+	}()
+
+	conn, err := newLinkedFFmpegConn(outR, inW)
+	if err != nil {
+		t.Fatalf("newLinkedFFmpegConn failed: %v", err)
+	}
+	t.Cleanup(conn.retire)
+	return conn
+}
+
+func newTestPool(t *testing.T, size, maxJobs int) *LinkedFFmpegPool {
+	t.Helper()
+	pool := NewLinkedFFmpegPool(size, maxJobs)
+	pool.Command = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Env = append(os.Environ(), poolTestWorkerEnv+"=1")
+		return cmd, nil
+	}
+	t.Cleanup(pool.Close)
+	return pool
+}
+
+func poolRun(t *testing.T, pool *LinkedFFmpegPool, args ...string) string {
+	t.Helper()
+	result, err := pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: args})
+	if err != nil {
+		t.Fatalf("pool.Run(%v) failed: %v", args, err)
+	}
+	return string(result.Stdout)
+}
+
+func TestLinkedFFmpegFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	req := LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFprobe, Args: []string{"-show_streams", "song.flac"}}
+	if err := writeLinkedFFmpegFrame(&buf, req); err != nil {
+		t.Fatalf("writeLinkedFFmpegFrame failed: %v", err)
+	}
+
+	var decoded LinkedFFmpegRequest
+	if err := readLinkedFFmpegFrame(&buf, &decoded); err != nil {
+		t.Fatalf("readLinkedFFmpegFrame failed: %v", err)
+	}
+	if decoded.Tool != req.Tool || strings.Join(decoded.Args, " ") != "-show_streams song.flac" {
+		t.Fatalf("decoded = %#v", decoded)
+	}
+	if err := readLinkedFFmpegFrame(&buf, &decoded); err != io.EOF {
+		t.Fatalf("read at end = %v, want io.EOF", err)
+	}
+
+	truncated := []byte{0, 0, 0, 10, '{'}
+	if err := readLinkedFFmpegFrame(bytes.NewReader(truncated), &decoded); err == nil || err == io.EOF {
+		t.Fatalf("truncated frame error = %v", err)
+	}
+	oversized := []byte{0xff, 0xff, 0xff, 0xff}
+	if err := readLinkedFFmpegFrame(bytes.NewReader(oversized), &decoded); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("oversized frame error = %v", err)
+	}
+}
+
+func TestServeLinkedFFmpegWorker(t *testing.T) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- serveLinkedFFmpegWorker(inR, outW, fakeWorkerHello(), fakeWorkerRun)
+		_ = outW.Close()
+	}()
+
+	var hello linkedFFmpegMessage
+	if err := readLinkedFFmpegFrame(outR, &hello); err != nil || hello.Type != linkedFFmpegHelloMessage || hello.Hello.Version != LinkedFFmpegProtocolVersion {
+		t.Fatalf("first message = %+v, %v, want hello", hello, err)
+	}
+
+	go func() {
+		for id, payload := range []string{
+			`{"version":1,"tool":"ffmpeg","args":["-version"]}`,
+			`{"version":1,"tool":"ffplay","args":["song.flac"]}`,
+			`{"version":1,"tool":"ffmpeg","args":["fail"]}`,
+			`{"version":1,"tool":"ffmpeg","args":["cat"]}`,
+			`{"version":2,"tool":"ffmpeg","args":["-version"]}`,
+		} {
+			_ = writeLinkedFFmpegFrame(inW, linkedFFmpegMessage{Type: linkedFFmpegRequestMessage, ID: uint64(id + 1), Request: json.RawMessage(payload)})
+		}
+		_ = writeLinkedFFmpegFrame(inW, linkedFFmpegMessage{Type: linkedFFmpegStdinMessage, ID: 4, Data: []byte("RIFF")})
+		_ = writeLinkedFFmpegFrame(inW, linkedFFmpegMessage{Type: linkedFFmpegStdinMessage, ID: 4})
+	}()
+
+	stdout := make(map[uint64]string)
+	stderr := make(map[uint64]string)
+	responses := make(map[uint64]LinkedFFmpegResponse)
+	for len(responses) < 5 {
+		var msg linkedFFmpegMessage
+		if err := readLinkedFFmpegFrame(outR, &msg); err != nil {
+			t.Fatalf("read worker message failed: %v", err)
+		}
+		switch msg.Type {
+		case linkedFFmpegStdoutMessage:
+			stdout[msg.ID] += string(msg.Data)
+		case linkedFFmpegStderrMessage:
+			stderr[msg.ID] += string(msg.Data)
+		case linkedFFmpegResponseMessage:
+			responses[msg.ID] = *msg.Response
+		default:
+			t.Fatalf("unexpected worker message %+v", msg)
+		}
+	}
+	_ = inW.Close()
+	if err := <-served; err != nil {
+		t.Fatalf("serveLinkedFFmpegWorker failed: %v", err)
+	}
+
+	if stdout[1] != "-version" || responses[1].ExitCode != 0 || responses[1].ErrorClass != "" {
+		t.Errorf("echo = %q, %+v", stdout[1], responses[1])
+	}
+	if resp := responses[2]; resp.ExitCode == 0 || resp.ErrorClass != LinkedFFmpegErrorInvalid || !strings.Contains(resp.Error, "unsupported") {
+		t.Errorf("invalid tool response = %+v", resp)
+	}
+	if resp := responses[3]; resp.ExitCode != 3 || resp.ErrorClass != LinkedFFmpegErrorExit || stderr[3] != "Invalid data found" {
+		t.Errorf("failure = %q, %+v", stderr[3], resp)
+	}
+	if stdout[4] != "RIFF" || responses[4].ExitCode != 0 {
+		t.Errorf("stdin copy = %q, %+v", stdout[4], responses[4])
+	}
+	if resp := responses[5]; resp.ErrorClass != LinkedFFmpegErrorInvalid || !strings.Contains(resp.Error, "version 2") {
+		t.Errorf("mismatched version response = %+v", resp)
+	}
+}
+
+func TestLinkedFFmpegConnStreamsStdin(t *testing.T) {
+	conn := newTestConn(t, fakeWorkerHello(), fakeWorkerRun)
+	input := strings.Repeat("0123456789abcdef", 3*linkedFFmpegChunkSize/16+5)
+
+	var stdout bytes.Buffer
+	resp, err := conn.Do(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"cat"}}, LinkedFFmpegStreams{Stdin: strings.NewReader(input), Stdout: &stdout}, time.Second)
+	if err != nil || resp.ExitCode != 0 {
+		t.Fatalf("Do(cat) = %+v, %v", resp, err)
+	}
+	if stdout.String() != input {
+		t.Fatalf("stdout has %d bytes, want the %d bytes of stdin", stdout.Len(), len(input))
+	}
+}
+
+func TestLinkedFFmpegConnCancel(t *testing.T) {
+	conn := newTestConn(t, fakeWorkerHello(), fakeWorkerRun)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	resp, err := conn.Do(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"sleep"}}, LinkedFFmpegStreams{}, 10*time.Second)
+	if !errors.Is(err, context.DeadlineExceeded) || resp.ErrorClass != LinkedFFmpegErrorTimeout {
+		t.Fatalf("timed out Do = %+v, %v, want context.DeadlineExceeded", resp, err)
+	}
+	if !conn.alive() {
+		t.Fatal("worker that answered the cancellation was lost")
+	}
+
+	var stdout bytes.Buffer
+	if _, err := conn.Do(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"after"}}, LinkedFFmpegStreams{Stdout: &stdout}, time.Second); err != nil || stdout.String() != "after" {
+		t.Fatalf("Do after cancel = %q, %v", stdout.String(), err)
+	}
+}
+
+func TestLinkedFFmpegConnChecksCapabilities(t *testing.T) {
+	hello := fakeWorkerHello()
+	hello.Capabilities = []string{string(LinkedFFmpegToolFFmpeg)}
+	conn := newTestConn(t, hello, fakeWorkerRun)
+
+	resp, err := conn.Do(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFprobe, Args: []string{"-version"}}, LinkedFFmpegStreams{}, time.Second)
+	if !errors.Is(err, ErrLinkedFFmpegUnavailable) || resp.ErrorClass != LinkedFFmpegErrorUnavailable {
+		t.Fatalf("Do(ffprobe) = %+v, %v, want ErrLinkedFFmpegUnavailable", resp, err)
+	}
+}
+
+func TestLinkedFFmpegPoolReusesAndRecyclesWorkers(t *testing.T) {
+	pool := newTestPool(t, 1, 2)
+
+	first := poolRun(t, pool, "pid")
+	if second := poolRun(t, pool, "pid"); second != first {
+		t.Errorf("second request ran in worker %s, want reused worker %s", second, first)
+	}
+	if third := poolRun(t, pool, "pid"); third == first {
+		t.Errorf("worker %s served more than MaxJobs requests", third)
+	}
+}
+
+func TestLinkedFFmpegPoolReplacesCrashedWorkers(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	first := poolRun(t, pool, "pid")
+
+	_, err := pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"crash"}})
+	if !errors.Is(err, errLinkedFFmpegWorkerLost) {
+		t.Fatalf("crash error = %v, want errLinkedFFmpegWorkerLost", err)
+	}
+
+	if next := poolRun(t, pool, "pid"); next == first {
+		t.Errorf("crashed worker %s was reused", first)
+	}
+}
+
+func TestLinkedFFmpegPoolReportsToolFailures(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	first := poolRun(t, pool, "pid")
+
+	result, err := pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"fail"}})
+	if err == nil || result.ExitCode != 3 || string(result.Stderr) != "Invalid data found" {
+		t.Fatalf("failed run = %+v, %v", result, err)
+	}
+
+	// A failing tool is not a broken worker
+	if next := poolRun(t, pool, "pid"); next != first {
+		t.Errorf("worker was replaced after a tool failure")
+	}
+}
+
+func TestLinkedFFmpegPoolCancel(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	first := poolRun(t, pool, "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := pool.Run(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"sleep"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("canceled run error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("canceled run took %v", elapsed)
+	}
+
+	// The worker stopped the request itself, so it stays in the pool
+	if next := poolRun(t, pool, "pid"); next != first {
+		t.Errorf("worker was replaced after a cancellation it answered")
+	}
+}
+
+func TestLinkedFFmpegPoolKillsHungWorkers(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	pool.CancelGrace = 200 * time.Millisecond
+	first := poolRun(t, pool, "pid")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := pool.Run(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"hang"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("canceled run error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("hung run took %v", elapsed)
+	}
+
+	if next := poolRun(t, pool, "pid"); next == first {
+		t.Errorf("hung worker %s was reused", first)
+	}
+}
+
+func TestLinkedFFmpegPoolTimeout(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	pool.CancelGrace = 200 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	_, err := pool.Run(ctx, LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"hang"}})
+	var linkedErr *LinkedFFmpegError
+	if !errors.As(err, &linkedErr) || linkedErr.Class != LinkedFFmpegErrorTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("timed out run error = %v, want the timeout class", err)
+	}
+}
+
+func TestLinkedFFmpegPoolCPULimit(t *testing.T) {
+	if !linkedFFmpegLimitsSupported {
+		t.Skip("resource limits are not supported on this platform")
+	}
+	pool := newTestPool(t, 1, 10)
+	pool.Limits = LinkedFFmpegLimits{CPU: time.Second}
+	command := pool.Command
+	pool.Command = func() (*exec.Cmd, error) {
+		cmd, err := command()
+		if err == nil {
+			cmd.Env = append(cmd.Env, pool.Limits.env()...)
+		}
+		return cmd, err
+	}
+	first := poolRun(t, pool, "pid")
+
+	start := time.Now()
+	_, err := pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"spin"}})
+	var linkedErr *LinkedFFmpegError
+	if !errors.As(err, &linkedErr) || linkedErr.Class != LinkedFFmpegErrorTimeout {
+		t.Fatalf("spinning run error = %v, want the timeout class", err)
+	}
+	if elapsed := time.Since(start); elapsed > 30*time.Second {
+		t.Fatalf("spinning run took %v", elapsed)
+	}
+
+	// The allowance is per request, not per worker
+	if next := poolRun(t, pool, "pid"); next == first {
+		t.Errorf("worker %s survived its CPU limit", first)
+	}
+	if out := poolRun(t, pool, "after", "limit"); out != "after limit" {
+		t.Errorf("run after CPU limit = %q", out)
+	}
+
+	// ffmpeg handles SIGXCPU itself and returns as if interrupted
+	_, err = pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"trapped-spin"}})
+	if !errors.As(err, &linkedErr) || linkedErr.Class != LinkedFFmpegErrorTimeout {
+		t.Fatalf("run that trapped SIGXCPU error = %v, want the timeout class", err)
+	}
+}
+
+func TestLinkedFFmpegLimitsEnvRoundTrip(t *testing.T) {
+	limits := LinkedFFmpegLimits{CPU: 1500 * time.Millisecond, Memory: 2 << 30}
+	env := make(map[string]string)
+	for _, entry := range limits.env() {
+		key, value, _ := strings.Cut(entry, "=")
+		env[key] = value
+	}
+
+	decoded, err := linkedFFmpegLimitsFromEnv(func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("linkedFFmpegLimitsFromEnv failed: %v", err)
+	}
+	if decoded != (LinkedFFmpegLimits{CPU: 2 * time.Second, Memory: 2 << 30}) {
+		t.Fatalf("decoded limits = %+v", decoded)
+	}
+	if _, err := linkedFFmpegLimitsFromEnv(func(key string) string { return "lots" }); err == nil {
+		t.Fatal("linkedFFmpegLimitsFromEnv accepted a malformed limit")
+	}
+}
+
+func TestLinkedFFmpegPoolRejectsOtherProtocolVersions(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	pool.Command = func() (*exec.Cmd, error) {
+		cmd := exec.Command(os.Args[0], "-test.run=^$")
+		cmd.Env = append(os.Environ(), poolTestWorkerEnv+"=old")
+		return cmd, nil
+	}
+
+	_, err := pool.Run(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"pid"}})
+	if !errors.Is(err, ErrLinkedFFmpegProtocol) || !strings.Contains(err.Error(), "version 0") {
+		t.Fatalf("old worker error = %v, want ErrLinkedFFmpegProtocol", err)
+	}
+}
+
+func TestLinkedFFmpegPoolStream(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+
+	var stdout bytes.Buffer
+	code, err := pool.Stream(context.Background(), LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"cat"}}, LinkedFFmpegStreams{Stdin: strings.NewReader("RIFF....WAVE"), Stdout: &stdout})
+	if err != nil || code != 0 || stdout.String() != "RIFF....WAVE" {
+		t.Fatalf("Stream(cat) = %d, %q, %v", code, stdout.String(), err)
+	}
+}
+
+func TestLinkedFFmpegRunnerUsesPool(t *testing.T) {
+	pool := newTestPool(t, 1, 10)
+	runner := LinkedFFmpegRunner{Mode: LinkedFFmpegModeHidden, Pool: pool}
+
+	result, err := runner.FFprobe(context.Background(), "-show_format", "song.flac")
+	if err != nil || string(result.Stdout) != "-show_format song.flac" {
+		t.Fatalf("FFprobe through pool = %q, %v", result.Stdout, err)
+	}
+}
@@ -0,0 +1,168 @@
+//go:build linkedffmpeg_cgo && cgo
+
+package main
+
+/*
+#include <stdlib.h>
+#include <libavcodec/avcodec.h>
+#include <libavformat/avformat.h>
+#include <libavutil/dict.h>
+#include <libavutil/error.h>
+#include <libavutil/time.h>
+
+extern void podhnologic_linked_ffmpeg_quiet_thread(int quiet);
+
+static int podhnologic_probe_interrupt(void *opaque)
+{
+    return av_gettime_relative() > *(const int64_t *)opaque;
+}
+
+// podhnologic_probe_open opens path and reads its stream info with logging
+// off on this thread. Both happen in one call so they stay on that thread.
+// Reading stops once av_gettime_relative passes *deadline, unless it is 0;
+// deadline must outlive the context.
+static int podhnologic_probe_open(AVFormatContext **ctx, const char *path, int64_t *deadline)
+{
+    int ret;
+
+    if (!(*ctx = avformat_alloc_context()))
+        return AVERROR(ENOMEM);
+    if (*deadline > 0) {
+        (*ctx)->interrupt_callback.callback = podhnologic_probe_interrupt;
+        (*ctx)->interrupt_callback.opaque = deadline;
+    }
+
+    podhnologic_linked_ffmpeg_quiet_thread(1);
+    ret = avformat_open_input(ctx, path, NULL, NULL);
+    if (ret >= 0 && (ret = avformat_find_stream_info(*ctx, NULL)) < 0)
+        avformat_close_input(ctx);
+    podhnologic_linked_ffmpeg_quiet_thread(0);
+    return ret;
+}
+
+static void podhnologic_probe_close(AVFormatContext **ctx)
+{
+    podhnologic_linked_ffmpeg_quiet_thread(1);
+    avformat_close_input(ctx);
+    podhnologic_linked_ffmpeg_quiet_thread(0);
+}
+
+static AVStream *podhnologic_stream(AVFormatContext *ctx, unsigned int i)
+{
+    return ctx->streams[i];
+}
+
+static int podhnologic_stream_channels(AVStream *st)
+{
+    return st->codecpar->ch_layout.nb_channels;
+}
+
+static double podhnologic_stream_duration(AVStream *st)
+{
+    if (st->duration == AV_NOPTS_VALUE)
+        return -1;
+    return st->duration * av_q2d(st->time_base);
+}
+
+static const char *podhnologic_stream_type(AVStream *st)
+{
+    return av_get_media_type_string(st->codecpar->codec_type);
+}
+
+static const char *podhnologic_stream_codec(AVStream *st)
+{
+    return avcodec_get_name(st->codecpar->codec_id);
+}
+
+static AVDictionaryEntry *podhnologic_next_tag(AVDictionary *dict, AVDictionaryEntry *prev)
+{
+    return av_dict_get(dict, "", prev, AV_DICT_IGNORE_SUFFIX);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// probeLinkedMetadata reads tags and streams with the linked libavformat, the
+// same fields ffprobe's JSON would give. Each call opens its own format
+// context, so probes may run concurrently, and logs nothing. Reading stops at
+// the context's deadline; cancellation is only seen before the probe starts.
+func probeLinkedMetadata(ctx context.Context, path string) (*Metadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	deadline := (*C.int64_t)(C.malloc(C.sizeof_int64_t))
+	defer C.free(unsafe.Pointer(deadline))
+	*deadline = 0
+	if d, ok := ctx.Deadline(); ok {
+		*deadline = C.av_gettime_relative() + C.int64_t(max(time.Until(d).Microseconds(), 1))
+	}
+
+	var fmtCtx *C.AVFormatContext
+	if ret := C.podhnologic_probe_open(&fmtCtx, cPath, deadline); ret < 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("probe %s: %w", path, err)
+		}
+		return nil, fmt.Errorf("probe %s: %s", path, avError(ret))
+	}
+	defer C.podhnologic_probe_close(&fmtCtx)
+
+	var metadata Metadata
+	metadata.Format.Tags = avDictionary(fmtCtx.metadata)
+	for i := C.uint(0); i < fmtCtx.nb_streams; i++ {
+		st := C.podhnologic_stream(fmtCtx, i)
+		stream := MetadataStream{
+			Index:       int(st.index),
+			CodecType:   C.GoString(C.podhnologic_stream_type(st)),
+			CodecName:   C.GoString(C.podhnologic_stream_codec(st)),
+			Channels:    int(C.podhnologic_stream_channels(st)),
+			Tags:        avDictionary(st.metadata),
+			Disposition: avDisposition(int(st.disposition)),
+		}
+		if seconds := float64(C.podhnologic_stream_duration(st)); seconds >= 0 {
+			stream.Duration = strconv.FormatFloat(seconds, 'f', 6, 64)
+		}
+		metadata.Streams = append(metadata.Streams, stream)
+	}
+	return &metadata, nil
+}
+
+func avDictionary(dict *C.AVDictionary) map[string]string {
+	tags := make(map[string]string)
+	for entry := C.podhnologic_next_tag(dict, nil); entry != nil; entry = C.podhnologic_next_tag(dict, entry) {
+		tags[C.GoString(entry.key)] = C.GoString(entry.value)
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// avDisposition names the disposition flags that are set, as ffprobe does.
+func avDisposition(flags int) map[string]int {
+	disposition := make(map[string]int)
+	for bit := 0; bit < 31; bit++ {
+		if flags&(1<<bit) == 0 {
+			continue
+		}
+		if name := C.av_disposition_to_string(C.int(1 << bit)); name != nil {
+			disposition[C.GoString(name)] = 1
+		}
+	}
+	return disposition
+}
+
+func avError(code C.int) string {
+	buf := make([]C.char, C.AV_ERROR_MAX_STRING_SIZE)
+	C.av_strerror(code, &buf[0], C.size_t(len(buf)))
+	return C.GoString(&buf[0])
+}
@@ -0,0 +1,114 @@
+//go:build linkedffmpeg_cgo && linkedffmpeg_hidden && cgo
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestProbeLinkedMetadataMatchesFFprobe(t *testing.T) {
+	tempDir := t.TempDir()
+	wavPath := filepath.Join(tempDir, "source.wav")
+	writePCM16WAV(t, wavPath, 48000, 48000)
+
+	flacPath := filepath.Join(tempDir, "tagged.flac")
+	if output, err := runFFmpeg(linkedTestBackend, []string{
+		"-y",
+		"-i", wavPath,
+		"-metadata", "title=Probe Proof",
+		"-metadata", "artist=Podhnologic",
+		"-c:a", "flac",
+		flacPath,
+	}); err != nil {
+		t.Fatalf("linked ffmpeg failed to create flac source: %v\n%s", err, output)
+	}
+
+	result, err := runFFprobe(linkedTestBackend, []string{
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		flacPath,
+	})
+	if err != nil {
+		t.Fatalf("linked ffprobe failed: %v\n%s", err, result.Stderr)
+	}
+	var want Metadata
+	if err := json.Unmarshal(result.Stdout, &want); err != nil {
+		t.Fatalf("decode ffprobe output failed: %v", err)
+	}
+
+	// Probes share no state, so they can run side by side
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := probeLinkedMetadata(context.Background(), flacPath)
+			if err != nil {
+				t.Errorf("probeLinkedMetadata failed: %v", err)
+				return
+			}
+			if got.Tag("title") != want.Tag("title") || got.Tag("artist") != want.Tag("artist") {
+				t.Errorf("tags = %v, ffprobe = %v", got.Format.Tags, want.Format.Tags)
+			}
+			if len(got.Streams) != len(want.Streams) || got.Streams[0].CodecName != want.Streams[0].CodecName ||
+				got.Streams[0].Channels != want.Streams[0].Channels || got.Duration() != want.Duration() {
+				t.Errorf("streams = %+v, ffprobe = %+v", got.Streams, want.Streams)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := probeLinkedMetadata(context.Background(), filepath.Join(tempDir, "missing.flac")); err == nil {
+		t.Error("probeLinkedMetadata opened a missing file")
+	}
+}
+
+// probeTestFileEnv makes the test binary probe one file and exit, so a test
+// can watch what the probe writes to stderr.
+const probeTestFileEnv = "PODHNOLOGIC_TEST_PROBE_FILE"
+
+func TestProbeLinkedMetadataOfADamagedFileIsQuiet(t *testing.T) {
+	if path := os.Getenv(probeTestFileEnv); path != "" {
+		_, _ = probeLinkedMetadata(context.Background(), path)
+		return
+	}
+
+	tempDir := t.TempDir()
+	wavPath := filepath.Join(tempDir, "source.wav")
+	writePCM16WAV(t, wavPath, 48000, 48000)
+	flacPath := filepath.Join(tempDir, "damaged.flac")
+	if output, err := runFFmpeg(linkedTestBackend, []string{"-y", "-i", wavPath, "-c:a", "flac", flacPath}); err != nil {
+		t.Fatalf("linked ffmpeg failed to create flac source: %v\n%s", err, output)
+	}
+	data, err := os.ReadFile(flacPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep the header and scramble the frames the decoder reads
+	for i := 8192; i < len(data); i += 7 {
+		data[i] ^= 0x5a
+	}
+	if err := os.WriteFile(flacPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestProbeLinkedMetadataOfADamagedFileIsQuiet$")
+	cmd.Env = append(os.Environ(), probeTestFileEnv+"="+flacPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("probe process failed: %v\n%s", err, stderr.String())
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("probe of a damaged file wrote to stderr:\n%s", stderr.String())
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linkedffmpeg_cgo
+
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+func probeLinkedMetadata(ctx context.Context, path string) (*Metadata, error) {
+	return nil, fmt.Errorf("%w: build with -tags linkedffmpeg_cgo", ErrLinkedFFmpegUnavailable)
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LinkedFFmpegProtocolVersion is the version of the bridge protocol spoken
+// between the parent and its bridge workers. It changes whenever a message
+// changes, so a stale worker binary is refused instead of misread.
+const LinkedFFmpegProtocolVersion = 1
+
+var ErrLinkedFFmpegProtocol = errors.New("linked ffmpeg protocol mismatch")
+
+// linkedFFmpegMaxFrame bounds a frame so a corrupt length cannot allocate
+// without limit. Frames carry stream chunks and small control messages.
+const linkedFFmpegMaxFrame = 256 << 20
+
+// linkedFFmpegChunkSize is the largest stdin chunk sent in one message.
+const linkedFFmpegChunkSize = 64 << 10
+
+// DefaultLinkedFFmpegCancelGrace is how long a worker has to stop a canceled
+// request before it is killed.
+const DefaultLinkedFFmpegCancelGrace = 5 * time.Second
+
+// Capabilities a worker may announce besides the tools it can run.
+const (
+	linkedFFmpegCapabilityStdin  = "stdin"
+	linkedFFmpegCapabilityCancel = "cancel"
+)
+
+var errLinkedFFmpegWorkerLost = errors.New("linked ffmpeg worker exited")
+
+// errLinkedFFmpegNotSent marks a request that never reached the worker, which
+// is safe to retry on another one.
+var errLinkedFFmpegNotSent = errors.New("linked ffmpeg request not sent")
+
+type linkedFFmpegMessageType string
+
+const (
+	linkedFFmpegHelloMessage    linkedFFmpegMessageType = "hello"
+	linkedFFmpegRequestMessage  linkedFFmpegMessageType = "request"
+	linkedFFmpegStdinMessage    linkedFFmpegMessageType = "stdin"
+	linkedFFmpegStdoutMessage   linkedFFmpegMessageType = "stdout"
+	linkedFFmpegStderrMessage   linkedFFmpegMessageType = "stderr"
+	linkedFFmpegResponseMessage linkedFFmpegMessageType = "response"
+	linkedFFmpegCancelMessage   linkedFFmpegMessageType = "cancel"
+)
+
+// linkedFFmpegMessage is one frame of the bridge protocol. Every message but
+// hello carries the ID of the request it belongs to, so messages for several
+// requests can share a worker's pipes.
+type linkedFFmpegMessage struct {
+	Type     linkedFFmpegMessageType `json:"type"`
+	ID       uint64                  `json:"id,omitempty"`
+	Hello    *LinkedFFmpegHello      `json:"hello,omitempty"`
+	Request  json.RawMessage         `json:"request,omitempty"`
+	Response *LinkedFFmpegResponse   `json:"response,omitempty"`
+	// Data is a chunk of a standard stream. An empty stdin message ends the
+	// request's input.
+	Data []byte `json:"data,omitempty"`
+}
+
+// LinkedFFmpegHello is the first message a worker sends. The parent refuses
+// workers that speak another protocol version.
+type LinkedFFmpegHello struct {
+	Version      int      `json:"version"`
+	PID          int      `json:"pid"`
+	Capabilities []string `json:"capabilities"`
+}
+
+// Supports reports whether the worker announced a capability, such as a tool
+// name or "cancel".
+func (h LinkedFFmpegHello) Supports(capability string) bool {
+	for _, c := range h.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// linkedFFmpegWorkerHello describes this binary as a worker. Builds without
+// the linked FFmpeg run no tools.
+func linkedFFmpegWorkerHello() LinkedFFmpegHello {
+	hello := LinkedFFmpegHello{
+		Version:      LinkedFFmpegProtocolVersion,
+		PID:          os.Getpid(),
+		Capabilities: []string{linkedFFmpegCapabilityStdin, linkedFFmpegCapabilityCancel},
+	}
+	if linkedFFmpegNativeBuilt {
+		hello.Capabilities = append(hello.Capabilities, string(LinkedFFmpegToolFFmpeg), string(LinkedFFmpegToolFFprobe))
+	}
+	return hello
+}
+
+// LinkedFFmpegErrorClass says why a bridged run failed.
+type LinkedFFmpegErrorClass string
+
+const (
+	// LinkedFFmpegErrorExit means the tool ran and exited with a failure code.
+	LinkedFFmpegErrorExit LinkedFFmpegErrorClass = "exit"
+	// LinkedFFmpegErrorInvalid means the worker refused the request.
+	LinkedFFmpegErrorInvalid LinkedFFmpegErrorClass = "invalid_request"
+	// LinkedFFmpegErrorUnavailable means the worker cannot run the tool.
+	LinkedFFmpegErrorUnavailable LinkedFFmpegErrorClass = "unavailable"
+	// LinkedFFmpegErrorCanceled means the request was canceled.
+	LinkedFFmpegErrorCanceled LinkedFFmpegErrorClass = "canceled"
+	// LinkedFFmpegErrorTimeout means the request ran past its deadline and
+	// was stopped.
+	LinkedFFmpegErrorTimeout LinkedFFmpegErrorClass = "timeout"
+	// LinkedFFmpegErrorInternal covers everything else, such as a failed pipe.
+	LinkedFFmpegErrorInternal LinkedFFmpegErrorClass = "internal"
+)
+
+// LinkedFFmpegResponse is the structured result of one request. The tool's
+// output travels separately, in stdout and stderr messages sent before it.
+type LinkedFFmpegResponse struct {
+	ExitCode   int                    `json:"exit_code"`
+	DurationMS int64                  `json:"duration_ms"`
+	Error      string                 `json:"error,omitempty"`
+	ErrorClass LinkedFFmpegErrorClass `json:"error_class,omitempty"`
+}
+
+// Err returns the failure the response reports, or nil.
+func (r LinkedFFmpegResponse) Err(tool LinkedFFmpegTool) error {
+	if r.Error == "" && r.ErrorClass == "" {
+		return nil
+	}
+	return &LinkedFFmpegError{Tool: tool, Class: r.ErrorClass, ExitCode: r.ExitCode, Message: r.Error}
+}
+
+// LinkedFFmpegError is a failure reported by a bridge worker. Unavailable,
+// canceled and timed out runs match ErrLinkedFFmpegUnavailable,
+// context.Canceled and context.DeadlineExceeded with errors.Is.
+type LinkedFFmpegError struct {
+	Tool     LinkedFFmpegTool
+	Class    LinkedFFmpegErrorClass
+	ExitCode int
+	Message  string
+}
+
+func (e *LinkedFFmpegError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("linked ffmpeg %s failed (%s)", e.Tool, e.Class)
+	}
+	return e.Message
+}
+
+func (e *LinkedFFmpegError) Unwrap() error {
+	switch e.Class {
+	case LinkedFFmpegErrorUnavailable:
+		return ErrLinkedFFmpegUnavailable
+	case LinkedFFmpegErrorCanceled:
+		return context.Canceled
+	case LinkedFFmpegErrorTimeout:
+		return context.DeadlineExceeded
+	case LinkedFFmpegErrorInvalid:
+		return ErrLinkedFFmpegProtocol
+	}
+	return nil
+}
+
+// linkedFFmpegErrorClass classifies a failed run in the worker.
+func linkedFFmpegErrorClass(ctx context.Context, exitCode int, err error) LinkedFFmpegErrorClass {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return LinkedFFmpegErrorTimeout
+	case errors.Is(err, context.Canceled), ctx.Err() != nil:
+		return LinkedFFmpegErrorCanceled
+	case errors.Is(err, ErrLinkedFFmpegUnavailable):
+		return LinkedFFmpegErrorUnavailable
+	case exitCode != 0:
+		return LinkedFFmpegErrorExit
+	}
+	return LinkedFFmpegErrorInternal
+}
+
+// writeLinkedFFmpegFrame writes v as JSON behind a 4-byte big-endian length.
+func writeLinkedFFmpegFrame(w io.Writer, v any) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(payload) > linkedFFmpegMaxFrame {
+		return fmt.Errorf("linked ffmpeg frame of %d bytes is too large", len(payload))
+	}
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readLinkedFFmpegFrame reads one frame into v. It returns io.EOF only when
+// the stream ends cleanly between frames.
+func readLinkedFFmpegFrame(r io.Reader, v any) error {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("read linked ffmpeg frame: %w", err)
+		}
+		return err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > linkedFFmpegMaxFrame {
+		return fmt.Errorf("linked ffmpeg frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return fmt.Errorf("read linked ffmpeg frame: %w", err)
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// linkedFFmpegSender writes whole messages from several goroutines.
+type linkedFFmpegSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *linkedFFmpegSender) send(msg linkedFFmpegMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeLinkedFFmpegFrame(s.w, msg)
+}
+
+// linkedFFmpegStreamWriter sends what a tool writes as stdout or stderr
+// messages for one request.
+type linkedFFmpegStreamWriter struct {
+	sender *linkedFFmpegSender
+	id     uint64
+	stream linkedFFmpegMessageType
+}
+
+func (w linkedFFmpegStreamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := w.sender.send(linkedFFmpegMessage{Type: w.stream, ID: w.id, Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// linkedFFmpegRunFunc runs one request with the given streams and returns the
+// tool's exit code, as runLinkedFFmpegNative does.
+type linkedFFmpegRunFunc func(context.Context, LinkedFFmpegRequest, LinkedFFmpegStreams) (int, error)
+
+// linkedFFmpegJob is a request accepted by a worker.
+type linkedFFmpegJob struct {
+	id     uint64
+	req    LinkedFFmpegRequest
+	ctx    context.Context
+	cancel context.CancelFunc
+	stdinR *io.PipeReader
+	stdinW *io.PipeWriter
+	done   chan struct{}
+}
+
+// serveLinkedFFmpegWorker speaks the bridge protocol until the parent closes
+// r. It sends hello, then runs requests one at a time in the order they
+// arrive while still reading input and cancellations for them. A request's
+// input is handed over as the tool reads it.
+func serveLinkedFFmpegWorker(r io.Reader, w io.Writer, hello LinkedFFmpegHello, run linkedFFmpegRunFunc) error {
+	sender := &linkedFFmpegSender{w: w}
+	if err := sender.send(linkedFFmpegMessage{Type: linkedFFmpegHelloMessage, Hello: &hello}); err != nil {
+		return err
+	}
+
+	var (
+		mu   sync.Mutex
+		jobs = make(map[uint64]*linkedFFmpegJob)
+		wg   sync.WaitGroup
+		prev = make(chan struct{})
+	)
+	close(prev)
+	lookup := func(id uint64) *linkedFFmpegJob {
+		mu.Lock()
+		defer mu.Unlock()
+		return jobs[id]
+	}
+	respond := func(id uint64, resp LinkedFFmpegResponse) error {
+		return sender.send(linkedFFmpegMessage{Type: linkedFFmpegResponseMessage, ID: id, Response: &resp})
+	}
+
+	for {
+		var msg linkedFFmpegMessage
+		if err := readLinkedFFmpegFrame(r, &msg); err != nil {
+			// The parent is gone or confused; stop everything it asked for
+			mu.Lock()
+			for _, job := range jobs {
+				job.cancel()
+				_ = job.stdinW.Close()
+			}
+			mu.Unlock()
+			wg.Wait()
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		switch msg.Type {
+		case linkedFFmpegRequestMessage:
+			req, err := ParseLinkedFFmpegRequest(msg.Request)
+			if err == nil && lookup(msg.ID) != nil {
+				err = fmt.Errorf("linked ffmpeg request %d is already running", msg.ID)
+			}
+			if err != nil {
+				if err := respond(msg.ID, LinkedFFmpegResponse{ExitCode: 1, Error: err.Error(), ErrorClass: LinkedFFmpegErrorInvalid}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stdinR, stdinW := io.Pipe()
+			job := &linkedFFmpegJob{id: msg.ID, req: req, ctx: ctx, cancel: cancel, stdinR: stdinR, stdinW: stdinW, done: make(chan struct{})}
+			mu.Lock()
+			jobs[job.id] = job
+			mu.Unlock()
+
+			wait := prev
+			prev = job.done
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(job.done)
+				<-wait
+				resp := job.run(run, sender)
+				mu.Lock()
+				delete(jobs, job.id)
+				mu.Unlock()
+				_ = respond(job.id, resp)
+			}()
+
+		case linkedFFmpegStdinMessage:
+			if job := lookup(msg.ID); job != nil {
+				if len(msg.Data) == 0 {
+					_ = job.stdinW.Close()
+				} else {
+					// Fails once the run has finished and stopped reading
+					_, _ = job.stdinW.Write(msg.Data)
+				}
+			}
+
+		case linkedFFmpegCancelMessage:
+			if job := lookup(msg.ID); job != nil {
+				job.cancel()
+				_ = job.stdinW.CloseWithError(context.Canceled)
+			}
+		}
+	}
+}
+
+func (j *linkedFFmpegJob) run(run linkedFFmpegRunFunc, sender *linkedFFmpegSender) LinkedFFmpegResponse {
+	defer j.cancel()
+	start := time.Now()
+
+	code, err := 0, j.ctx.Err()
+	if err == nil {
+		code, err = run(j.ctx, j.req, LinkedFFmpegStreams{
+			Stdin:  j.stdinR,
+			Stdout: linkedFFmpegStreamWriter{sender: sender, id: j.id, stream: linkedFFmpegStdoutMessage},
+			Stderr: linkedFFmpegStreamWriter{sender: sender, id: j.id, stream: linkedFFmpegStderrMessage},
+		})
+	}
+	// Unblock input still on its way to a tool that has stopped reading
+	_ = j.stdinR.CloseWithError(io.ErrClosedPipe)
+
+	resp := LinkedFFmpegResponse{ExitCode: code, DurationMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		resp.Error = err.Error()
+		resp.ErrorClass = linkedFFmpegErrorClass(j.ctx, code, err)
+		if resp.ExitCode == 0 {
+			resp.ExitCode = 1
+		}
+	}
+	return resp
+}
+
+// linkedFFmpegConn is the parent's end of a bridge worker. It sends requests
+// and routes the worker's messages to the request they belong to.
+type linkedFFmpegConn struct {
+	hello  LinkedFFmpegHello
+	sender *linkedFFmpegSender
+	stdin  io.Closer
+	stdout io.Reader
+	cmd    *exec.Cmd
+	jobs   int
+
+	nextID atomic.Uint64
+	mu     sync.Mutex
+	calls  map[uint64]*linkedFFmpegCall
+	err    error
+	done   chan struct{}
+
+	waitOnce sync.Once
+	waitErr  error
+}
+
+// linkedFFmpegCall is a request waiting for its response.
+type linkedFFmpegCall struct {
+	streams  LinkedFFmpegStreams
+	writeErr error
+	response chan LinkedFFmpegResponse
+}
+
+// startLinkedFFmpegConn starts a worker process and completes the handshake.
+func startLinkedFFmpegConn(cmd *exec.Cmd) (*linkedFFmpegConn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("open linked ffmpeg worker stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		_ = stdin.Close()
+		return nil, fmt.Errorf("open linked ffmpeg worker stdout: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		_ = stdin.Close()
+		return nil, fmt.Errorf("start linked ffmpeg worker: %w", err)
+	}
+
+	conn, err := newLinkedFFmpegConn(stdout, stdin)
+	if err != nil {
+		_ = stdin.Close()
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, err
+	}
+	conn.cmd = cmd
+	return conn, nil
+}
+
+// newLinkedFFmpegConn reads the worker's hello from r and starts routing its
+// messages. Requests are written to w.
+func newLinkedFFmpegConn(r io.Reader, w io.WriteCloser) (*linkedFFmpegConn, error) {
+	var msg linkedFFmpegMessage
+	if err := readLinkedFFmpegFrame(r, &msg); err != nil {
+		if err == io.EOF {
+			err = errLinkedFFmpegWorkerLost
+		}
+		return nil, fmt.Errorf("read linked ffmpeg worker hello: %w", err)
+	}
+	if msg.Type != linkedFFmpegHelloMessage || msg.Hello == nil {
+		return nil, fmt.Errorf("%w: worker sent %q before hello", ErrLinkedFFmpegProtocol, msg.Type)
+	}
+	if msg.Hello.Version != LinkedFFmpegProtocolVersion {
+		return nil, fmt.Errorf("%w: worker speaks version %d, this build speaks version %d", ErrLinkedFFmpegProtocol, msg.Hello.Version, LinkedFFmpegProtocolVersion)
+	}
+
+	conn := &linkedFFmpegConn{
+		hello:  *msg.Hello,
+		sender: &linkedFFmpegSender{w: w},
+		stdin:  w,
+		stdout: r,
+		calls:  make(map[uint64]*linkedFFmpegCall),
+		done:   make(chan struct{}),
+	}
+	go conn.route()
+	return conn, nil
+}
+
+// route delivers the worker's messages until it exits.
+func (c *linkedFFmpegConn) route() {
+	defer close(c.done)
+	for {
+		var msg linkedFFmpegMessage
+		if err := readLinkedFFmpegFrame(c.stdout, &msg); err != nil {
+			if err == io.EOF {
+				err = errLinkedFFmpegWorkerLost
+			}
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+
+		c.mu.Lock()
+		call := c.calls[msg.ID]
+		if msg.Type == linkedFFmpegResponseMessage {
+			delete(c.calls, msg.ID)
+		}
+		c.mu.Unlock()
+		if call == nil {
+			// Output of a request the caller gave up on
+			continue
+		}
+
+		switch msg.Type {
+		case linkedFFmpegStdoutMessage:
+			call.write(call.streams.Stdout, msg.Data)
+		case linkedFFmpegStderrMessage:
+			call.write(call.streams.Stderr, msg.Data)
+		case linkedFFmpegResponseMessage:
+			resp := LinkedFFmpegResponse{ExitCode: 1, Error: "linked ffmpeg worker sent an empty response", ErrorClass: LinkedFFmpegErrorInternal}
+			if msg.Response != nil {
+				resp = *msg.Response
+			}
+			call.response <- resp
+		}
+	}
+}
+
+func (c *linkedFFmpegCall) write(w io.Writer, data []byte) {
+	if c.writeErr != nil {
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		c.writeErr = err
+	}
+}
+
+// lost returns why the worker is gone, or nil while it runs.
+func (c *linkedFFmpegConn) lost() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *linkedFFmpegConn) forget(id uint64) {
+	c.mu.Lock()
+	delete(c.calls, id)
+	c.mu.Unlock()
+}
+
+// Do runs one request on the worker. Canceling ctx sends a cancel message;
+// a worker that does not answer it within grace is killed. A request stopped
+// by the ctx deadline fails with the timeout class, as does one whose worker
+// exits on its CPU limit. Errors wrapping errLinkedFFmpegNotSent mean the
+// worker never saw the request.
+func (c *linkedFFmpegConn) Do(ctx context.Context, req LinkedFFmpegRequest, streams LinkedFFmpegStreams, grace time.Duration) (LinkedFFmpegResponse, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	streams = streams.withDefaults()
+	if !c.hello.Supports(string(req.Tool)) {
+		resp := LinkedFFmpegResponse{ExitCode: 1, Error: fmt.Sprintf("%v: worker cannot run %s", ErrLinkedFFmpegUnavailable, req.Tool), ErrorClass: LinkedFFmpegErrorUnavailable}
+		return resp, resp.Err(req.Tool)
+	}
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return LinkedFFmpegResponse{}, fmt.Errorf("marshal linked ffmpeg request: %w", err)
+	}
+
+	id := c.nextID.Add(1)
+	call := &linkedFFmpegCall{streams: streams, response: make(chan LinkedFFmpegResponse, 1)}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return LinkedFFmpegResponse{}, fmt.Errorf("%w: %w", errLinkedFFmpegNotSent, c.err)
+	}
+	c.calls[id] = call
+	c.mu.Unlock()
+
+	if err := c.sender.send(linkedFFmpegMessage{Type: linkedFFmpegRequestMessage, ID: id, Request: payload}); err != nil {
+		c.forget(id)
+		return LinkedFFmpegResponse{}, fmt.Errorf("%w: %w", errLinkedFFmpegNotSent, err)
+	}
+	finished := make(chan struct{})
+	defer close(finished)
+	go c.sendStdin(id, streams.Stdin, finished)
+
+	select {
+	case resp := <-call.response:
+		return c.finish(req, call, resp)
+	case <-c.done:
+		select {
+		case resp := <-call.response:
+			return c.finish(req, call, resp)
+		default:
+		}
+		if c.outOfCPU() {
+			resp := LinkedFFmpegResponse{ExitCode: 1, Error: fmt.Sprintf("linked ffmpeg %s ran out of CPU time", req.Tool), ErrorClass: LinkedFFmpegErrorTimeout}
+			return resp, resp.Err(req.Tool)
+		}
+		return LinkedFFmpegResponse{ExitCode: 1}, fmt.Errorf("linked ffmpeg %s: %w", req.Tool, c.lost())
+	case <-ctx.Done():
+	}
+
+	_ = c.sender.send(linkedFFmpegMessage{Type: linkedFFmpegCancelMessage, ID: id})
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-call.response:
+	case <-c.done:
+	case <-timer.C:
+		c.forget(id)
+		c.kill()
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		resp := LinkedFFmpegResponse{ExitCode: 1, Error: fmt.Sprintf("linked ffmpeg %s timed out", req.Tool), ErrorClass: LinkedFFmpegErrorTimeout}
+		return resp, resp.Err(req.Tool)
+	}
+	return LinkedFFmpegResponse{ExitCode: 1, ErrorClass: LinkedFFmpegErrorCanceled}, ctx.Err()
+}
+
+func (c *linkedFFmpegConn) finish(req LinkedFFmpegRequest, call *linkedFFmpegCall, resp LinkedFFmpegResponse) (LinkedFFmpegResponse, error) {
+	if err := resp.Err(req.Tool); err != nil {
+		return resp, err
+	}
+	if call.writeErr != nil {
+		return resp, fmt.Errorf("write linked ffmpeg output: %w", call.writeErr)
+	}
+	return resp, nil
+}
+
+// sendStdin forwards the caller's input in chunks and then ends it. A reader
+// that blocks past the end of the request keeps this goroutine until it
+// returns.
+func (c *linkedFFmpegConn) sendStdin(id uint64, stdin io.Reader, finished <-chan struct{}) {
+	buf := make([]byte, linkedFFmpegChunkSize)
+	for {
+		n, err := stdin.Read(buf)
+		select {
+		case <-finished:
+			return
+		default:
+		}
+		if n > 0 {
+			if c.sender.send(linkedFFmpegMessage{Type: linkedFFmpegStdinMessage, ID: id, Data: buf[:n]}) != nil {
+				return
+			}
+		}
+		if err != nil {
+			_ = c.sender.send(linkedFFmpegMessage{Type: linkedFFmpegStdinMessage, ID: id})
+			return
+		}
+	}
+}
+
+// alive reports whether the worker can take another request.
+func (c *linkedFFmpegConn) alive() bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// wait reaps the worker process once; later calls return the same result.
+func (c *linkedFFmpegConn) wait() error {
+	if c.cmd == nil {
+		return nil
+	}
+	c.waitOnce.Do(func() {
+		c.waitErr = c.cmd.Wait()
+	})
+	return c.waitErr
+}
+
+// outOfCPU reports whether a worker that is gone exited on its CPU limit.
+func (c *linkedFFmpegConn) outOfCPU() bool {
+	var exitErr *exec.ExitError
+	return c.cmd != nil && errors.As(c.wait(), &exitErr) && exitErr.ExitCode() == linkedFFmpegCPULimitExitCode
+}
+
+// retire lets the worker exit on its own by closing its input.
+func (c *linkedFFmpegConn) retire() {
+	_ = c.stdin.Close()
+	_ = c.wait()
+}
+
+// kill stops a worker that is broken or ignores a cancellation.
+func (c *linkedFFmpegConn) kill() {
+	_ = c.stdin.Close()
+	if c.cmd == nil {
+		return
+	}
+	_ = c.cmd.Process.Kill()
+	_ = c.wait()
+	<-c.done
+}
@@ -0,0 +1,26 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+const linkedFFmpegLimitsSupported = false
+
+var errLinkedFFmpegLimitsUnsupported = errors.New("linked ffmpeg resource limits are not supported on this platform")
+
+func setLinkedFFmpegMemoryLimit(bytes int64) error {
+	return errLinkedFFmpegLimitsUnsupported
+}
+
+func setLinkedFFmpegCPULimit(limit time.Duration) (time.Duration, error) {
+	return 0, errLinkedFFmpegLimitsUnsupported
+}
+
+func linkedFFmpegCPUTime() (time.Duration, error) {
+	return 0, errLinkedFFmpegLimitsUnsupported
+}
+
+func watchLinkedFFmpegCPULimit() {}
@@ -0,0 +1,65 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const linkedFFmpegLimitsSupported = true
+
+func setLinkedFFmpegMemoryLimit(bytes int64) error {
+	limit := syscall.Rlimit{Cur: uint64(bytes), Max: uint64(bytes)}
+	if err := syscall.Setrlimit(syscall.RLIMIT_AS, &limit); err != nil {
+		return fmt.Errorf("set linked ffmpeg memory limit: %w", err)
+	}
+	return nil
+}
+
+// setLinkedFFmpegCPULimit moves the soft CPU limit to limit past the CPU time
+// the worker has used so far and returns the new soft limit. The hard limit
+// stays put so the next request can move it again.
+func setLinkedFFmpegCPULimit(limit time.Duration) (time.Duration, error) {
+	used, err := linkedFFmpegCPUTime()
+	if err != nil {
+		return 0, err
+	}
+	var current syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_CPU, &current); err != nil {
+		return 0, fmt.Errorf("read linked ffmpeg CPU limit: %w", err)
+	}
+
+	soft := uint64((used + limit + time.Second - 1) / time.Second)
+	if soft > current.Max {
+		soft = current.Max
+	}
+	current.Cur = soft
+	if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &current); err != nil {
+		return 0, fmt.Errorf("set linked ffmpeg CPU limit: %w", err)
+	}
+	return time.Duration(soft) * time.Second, nil
+}
+
+// linkedFFmpegCPUTime is the CPU time the worker has used so far.
+func linkedFFmpegCPUTime() (time.Duration, error) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, fmt.Errorf("read linked ffmpeg CPU usage: %w", err)
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), nil
+}
+
+// watchLinkedFFmpegCPULimit ends the worker once a request uses up its CPU
+// time. Go ignores SIGXCPU unless it is asked for.
+func watchLinkedFFmpegCPULimit() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGXCPU)
+	go func() {
+		<-signals
+		exitLinkedFFmpegCPULimit()
+	}()
+}
@@ -0,0 +1,12 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os/signal"
+	"syscall"
+)
+
+func init() {
+	fakeWorkerTrapCPULimit = func() { signal.Ignore(syscall.SIGXCPU) }
+}
@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// linkedFFmpegProtocolStdio returns the standard streams. No linked FFmpeg
+// build targets this platform, so no tool runs in the worker.
+func linkedFFmpegProtocolStdio() (*os.File, *os.File, error) {
+	return os.Stdin, os.Stdout, nil
+}
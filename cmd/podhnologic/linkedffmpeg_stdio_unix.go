@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkedFFmpegProtocolStdio returns a worker's protocol streams on their own
+// descriptors, so nothing a tool writes to descriptor 1 can cut into the
+// protocol.
+func linkedFFmpegProtocolStdio() (*os.File, *os.File, error) {
+	in, err := dupLinkedFFmpegStdio(os.Stdin, "bridge-in")
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := dupLinkedFFmpegStdio(os.Stdout, "bridge-out")
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+func dupLinkedFFmpegStdio(f *os.File, name string) (*os.File, error) {
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		return nil, os.NewSyscallError("dup", err)
+	}
+	syscall.CloseOnExec(fd)
+	return os.NewFile(uintptr(fd), name), nil
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// linkedFFmpegProtocolStdio returns a worker's protocol streams on their own
+// handles. The native bridge replaces standard output while a tool runs,
+// closing the original.
+func linkedFFmpegProtocolStdio() (*os.File, *os.File, error) {
+	in, err := dupLinkedFFmpegStdio(os.Stdin, "bridge-in")
+	if err != nil {
+		return nil, nil, err
+	}
+	out, err := dupLinkedFFmpegStdio(os.Stdout, "bridge-out")
+	if err != nil {
+		_ = in.Close()
+		return nil, nil, err
+	}
+	return in, out, nil
+}
+
+func dupLinkedFFmpegStdio(f *os.File, name string) (*os.File, error) {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return nil, os.NewSyscallError("GetCurrentProcess", err)
+	}
+	var handle syscall.Handle
+	if err := syscall.DuplicateHandle(process, syscall.Handle(f.Fd()), process, &handle, 0, false, syscall.DUPLICATE_SAME_ACCESS); err != nil {
+		return nil, os.NewSyscallError("DuplicateHandle", err)
+	}
+	return os.NewFile(uintptr(handle), name), nil
+}
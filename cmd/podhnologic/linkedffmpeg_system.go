@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+var ErrSystemFFmpegNotFound = errors.New("system ffmpeg not found")
+
+// systemFFmpegMinVersion is the oldest FFmpeg release the conversion
+// arguments are known to work with.
+const systemFFmpegMinVersion = 6
+
+// systemToolPath finds ffmpeg or ffprobe. A configured ffmpeg path may be the
+// binary itself, with ffprobe expected next to it, or the directory holding both.
+func systemToolPath(tool LinkedFFmpegTool, ffmpegPath string) (string, error) {
+	if ffmpegPath == "" {
+		path, err := exec.LookPath(string(tool))
+		if err != nil {
+			return "", fmt.Errorf("%w: %s is not on PATH; install FFmpeg or set ffmpeg_path", ErrSystemFFmpegNotFound, tool)
+		}
+		return path, nil
+	}
+
+	path := expandPath(ffmpegPath)
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, string(tool)+executableSuffix())
+	} else if tool == LinkedFFmpegToolFFprobe {
+		path = filepath.Join(filepath.Dir(path), string(tool)+filepath.Ext(path))
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("%w: %s", ErrSystemFFmpegNotFound, path)
+	}
+	return path, nil
+}
+
+func executableSuffix() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+func runSystemFFmpeg(ctx context.Context, ffmpegPath string, req LinkedFFmpegRequest, streams LinkedFFmpegStreams) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	path, err := systemToolPath(req.Tool, ffmpegPath)
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.CommandContext(ctx, path, req.Args...)
+	cmd.Stdin = streams.Stdin
+	cmd.Stdout = streams.Stdout
+	cmd.Stderr = streams.Stderr
+	if err := cmd.Run(); err != nil {
+		return commandExitCode(err), fmt.Errorf("system %s failed: %w", req.Tool, err)
+	}
+	return 0, nil
+}
+
+// commandExitCode is the exit code behind a failed exec.Cmd, or 1 when the
+// process did not exit normally.
+func commandExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
+
+var ffmpegVersionPattern = regexp.MustCompile(`^ffmpeg version n?(\d+)\.(\d+)`)
+
+// parseFFmpegVersion reads the release from `ffmpeg -version` output. Git
+// snapshot builds carry no release number and report false.
+func parseFFmpegVersion(output []byte) (major, minor int, ok bool) {
+	line, _, _ := strings.Cut(string(output), "\n")
+	match := ffmpegVersionPattern.FindStringSubmatch(strings.TrimSpace(line))
+	if match == nil {
+		return 0, 0, false
+	}
+	major, _ = strconv.Atoi(match[1])
+	minor, _ = strconv.Atoi(match[2])
+	return major, minor, true
+}
+
+// checkSystemFFmpeg confirms that a system FFmpeg is recent enough and has
+// the encoder for each configured codec.
+func checkSystemFFmpeg(runner LinkedFFmpegRunner, config Config) error {
+	ctx := context.Background()
+	result, err := runner.FFmpeg(ctx, "-hide_banner", "-version")
+	if err != nil {
+		return err
+	}
+	if major, minor, ok := parseFFmpegVersion(result.Stdout); ok && major < systemFFmpegMinVersion {
+		return fmt.Errorf("system FFmpeg %d.%d is too old; version %d or newer is required", major, minor, systemFFmpegMinVersion)
+	}
+
+	result, err = runner.FFmpeg(ctx, "-hide_banner", "-encoders")
+	if err != nil {
+		return fmt.Errorf("list system FFmpeg encoders: %w", err)
+	}
+	encoders := parseEncoderList(result.Stdout)
+
+	var errs []error
+	for _, codec := range configuredCodecs(config) {
+		if encoder := codecEncoder(codec); !encoders[encoder] {
+			errs = append(errs, fmt.Errorf("system FFmpeg lacks the %s encoder needed for %s", encoder, codec))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeFakeFFmpeg installs shell scripts standing in for ffmpeg and ffprobe
+// that report the given version and encoders.
+func writeFakeFFmpeg(t *testing.T, version string, encoders ...string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+
+	dir := t.TempDir()
+	listing := " A..... = Audio\n ------\n"
+	for _, encoder := range encoders {
+		listing += " A....D " + encoder + " Encoder\n"
+	}
+	ffmpeg := `#!/bin/sh
+case "$2" in
+-version) echo "ffmpeg version ` + version + ` Copyright (c) 2000-2024" ;;
+-encoders) printf '` + listing + `' ;;
+pipe:0) cat; echo "done" >&2 ;;
+*) echo "bad input" >&2; exit 3 ;;
+esac
+`
+	ffprobe := "#!/bin/sh\necho '{\"streams\":[]}'\n"
+	for name, script := range map[string]string{"ffmpeg": ffmpeg, "ffprobe": ffprobe} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("failed to write fake %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+func TestParseLinkedFFmpegMode(t *testing.T) {
+	for input, want := range map[string]LinkedFFmpegMode{
+		"":        LinkedFFmpegModeAuto,
+		"auto":    LinkedFFmpegModeAuto,
+		"System":  LinkedFFmpegModeSystem,
+		" hidden": LinkedFFmpegModeHidden,
+		"direct":  LinkedFFmpegModeDirect,
+	} {
+		if mode, err := ParseLinkedFFmpegMode(input); err != nil || mode != want {
+			t.Errorf("ParseLinkedFFmpegMode(%q) = %q, %v; want %q", input, mode, err, want)
+		}
+	}
+	if _, err := ParseLinkedFFmpegMode("path"); err == nil {
+		t.Error("ParseLinkedFFmpegMode accepted an unknown mode")
+	}
+}
+
+func TestLinkedFFmpegAutoFallsBackToSystem(t *testing.T) {
+	if linkedFFmpegNativeBuilt {
+		t.Skip("built with the linked FFmpeg")
+	}
+	if mode := NewLinkedFFmpegRunner(LinkedFFmpegModeAuto).Resolve().Mode; mode != LinkedFFmpegModeSystem {
+		t.Errorf("auto resolved to %q, want system", mode)
+	}
+
+	runner := NewLinkedFFmpegRunner(LinkedFFmpegModeAuto)
+	runner.FFmpegPath = writeFakeFFmpeg(t, "7.1")
+	if _, err := runner.FFmpeg(context.Background(), "-hide_banner", "-version"); err != nil {
+		t.Errorf("auto runner did not use the system FFmpeg: %v", err)
+	}
+}
+
+func TestRunSystemFFmpeg(t *testing.T) {
+	dir := writeFakeFFmpeg(t, "7.1")
+	runner := LinkedFFmpegRunner{Mode: LinkedFFmpegModeSystem, FFmpegPath: filepath.Join(dir, "ffmpeg")}
+
+	result, err := runner.FFprobe(context.Background(), "-show_streams")
+	if err != nil || !strings.Contains(string(result.Stdout), "streams") {
+		t.Fatalf("FFprobe = %q, %v", result.Stdout, err)
+	}
+
+	result, err = runner.FFmpeg(context.Background(), "-i", "missing.flac")
+	if err == nil || result.ExitCode != 3 || !strings.Contains(string(result.Stderr), "bad input") {
+		t.Errorf("failed FFmpeg = exit %d, stderr %q, err %v", result.ExitCode, result.Stderr, err)
+	}
+
+	runner.FFmpegPath = filepath.Join(dir, "missing")
+	if _, err := runner.FFmpeg(context.Background(), "-version"); !errors.Is(err, ErrSystemFFmpegNotFound) {
+		t.Errorf("missing binary error = %v, want ErrSystemFFmpegNotFound", err)
+	}
+}
+
+func TestStreamSystemFFmpeg(t *testing.T) {
+	runner := LinkedFFmpegRunner{Mode: LinkedFFmpegModeSystem, FFmpegPath: writeFakeFFmpeg(t, "7.1")}
+
+	var stdout, stderr bytes.Buffer
+	code, err := runner.StreamFFmpeg(context.Background(), LinkedFFmpegStreams{
+		Stdin:  strings.NewReader("encoded audio"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}, "-i", "pipe:0", "-f", "flac", "pipe:1")
+	if err != nil || code != 0 {
+		t.Fatalf("StreamFFmpeg = %d, %v", code, err)
+	}
+	if stdout.String() != "encoded audio" || stderr.String() != "done\n" {
+		t.Errorf("stdout = %q, stderr = %q", stdout.String(), stderr.String())
+	}
+
+	// Nil streams discard output and provide no input
+	if code, err := runner.StreamFFmpeg(context.Background(), LinkedFFmpegStreams{}, "-i", "pipe:0"); err != nil || code != 0 {
+		t.Errorf("StreamFFmpeg without streams = %d, %v", code, err)
+	}
+}
+
+func TestParseFFmpegVersion(t *testing.T) {
+	tests := []struct {
+		output       string
+		major, minor int
+		ok           bool
+	}{
+		{"ffmpeg version 6.1.1-3ubuntu5 Copyright (c) 2000-2023", 6, 1, true},
+		{"ffmpeg version n7.0 Copyright", 7, 0, true},
+		{"ffmpeg version N-113214-g1a2b3c4 Copyright", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		major, minor, ok := parseFFmpegVersion([]byte(tt.output))
+		if major != tt.major || minor != tt.minor || ok != tt.ok {
+			t.Errorf("parseFFmpegVersion(%q) = %d, %d, %v", tt.output, major, minor, ok)
+		}
+	}
+}
+
+func TestCheckSystemFFmpeg(t *testing.T) {
+	config := Config{Codec: "opus"}
+
+	tests := []struct {
+		name     string
+		version  string
+		encoders []string
+		problem  string
+	}{
+		{"usable", "7.1", []string{"libopus"}, ""},
+		{"too old", "4.4.2", []string{"libopus"}, "too old"},
+		{"missing encoder", "7.1", []string{"aac"}, "lacks the libopus encoder"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := LinkedFFmpegRunner{Mode: LinkedFFmpegModeSystem, FFmpegPath: writeFakeFFmpeg(t, tt.version, tt.encoders...)}
+			err := checkSystemFFmpeg(runner, config)
+			if tt.problem == "" && err != nil {
+				t.Errorf("checkSystemFFmpeg = %v, want no error", err)
+			}
+			if tt.problem != "" && (err == nil || !strings.Contains(err.Error(), tt.problem)) {
+				t.Errorf("checkSystemFFmpeg = %v, want %q", err, tt.problem)
+			}
+		})
+	}
+}
+
+func TestFFmpegRunnerForEnvironment(t *testing.T) {
+	env := map[string]string{ffmpegModeEnv: "system", ffmpegPathEnv: "/opt/ffmpeg/bin"}
+	config := Config{FFmpegMode: "direct", FFmpegPath: "/usr/local/bin/ffmpeg"}
+
+	runner, err := ffmpegRunnerFor(config, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.Mode != LinkedFFmpegModeSystem || runner.FFmpegPath != "/opt/ffmpeg/bin" {
+		t.Errorf("runner = %+v, want the environment's system FFmpeg", runner)
+	}
+
+	runner, err = ffmpegRunnerFor(config, func(string) string { return "" })
+	if err != nil || runner.Mode != LinkedFFmpegModeDirect {
+		t.Errorf("runner = %+v, %v; want the configured direct mode", runner, err)
+	}
+}
@@ -2,8 +2,10 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -45,3 +47,56 @@ func TestLinkedFFmpegDefaultRunnerUnavailable(t *testing.T) {
 		t.Fatalf("default runner exit code = %d, want 0", result.ExitCode)
 	}
 }
+
+func TestParseLinkedFFmpegRequestChecksVersion(t *testing.T) {
+	payload, err := json.Marshal(LinkedFFmpegRequest{Tool: LinkedFFmpegToolFFmpeg, Args: []string{"-i", "pipe:0"}})
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	req, err := ParseLinkedFFmpegRequest(payload)
+	if err != nil {
+		t.Fatalf("ParseLinkedFFmpegRequest failed: %v", err)
+	}
+	if req.Version != LinkedFFmpegProtocolVersion || !reflect.DeepEqual(req.Args, []string{"-i", "pipe:0"}) {
+		t.Fatalf("request = %#v", req)
+	}
+
+	_, err = ParseLinkedFFmpegRequest([]byte(`{"version":99,"tool":"ffmpeg","args":["-version"]}`))
+	if !errors.Is(err, ErrLinkedFFmpegProtocol) || !strings.Contains(err.Error(), "version 99") {
+		t.Fatalf("mismatched version error = %v, want ErrLinkedFFmpegProtocol naming version 99", err)
+	}
+}
+
+func TestLinkedFFmpegDirectArgs(t *testing.T) {
+	tests := []struct {
+		tool LinkedFFmpegTool
+		args []string
+		want []string
+	}{
+		{
+			tool: LinkedFFmpegToolFFmpeg,
+			args: []string{"-i", "pipe:0", "-f", "wav", "pipe:1"},
+			want: []string{"-nostdin", "-i", "pipe:7", "-f", "wav", "pipe:9"},
+		},
+		{
+			tool: LinkedFFmpegToolFFmpeg,
+			args: []string{"-i", "-", "-c:a", "flac", "-f", "flac", "-"},
+			want: []string{"-nostdin", "-i", "pipe:7", "-c:a", "flac", "-f", "flac", "pipe:9"},
+		},
+		{
+			tool: LinkedFFmpegToolFFmpeg,
+			args: []string{"-y", "-i", "song.flac", "song.mp3"},
+			want: []string{"-nostdin", "-y", "-i", "song.flac", "song.mp3"},
+		},
+		{
+			tool: LinkedFFmpegToolFFprobe,
+			args: []string{"-show_streams", "-o", "-", "-i", "pipe:"},
+			want: []string{"-show_streams", "-o", "pipe:9", "-i", "pipe:7"},
+		},
+	}
+	for _, tt := range tests {
+		if got := linkedFFmpegDirectArgs(tt.tool, tt.args, 7, 9); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("linkedFFmpegDirectArgs(%s, %q) = %q, want %q", tt.tool, tt.args, got, tt.want)
+		}
+	}
+}
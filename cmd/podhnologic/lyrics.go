@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	lrcTimestampPattern = regexp.MustCompile(`\[\d{1,3}:\d{1,2}(?:[.:]\d{1,3})?\]`)
+	lrcWordTimePattern  = regexp.MustCompile(`<\d{1,3}:\d{1,2}(?:[.:]\d{1,3})?>`)
+	lrcIDTagPattern     = regexp.MustCompile(`^\[(?i:ar|ti|al|au|by|re|ve|length|offset|#):[^\]]*\]$`)
+)
+
+// sidecarLyricsPaths lists the .lrc files that may sit next to an audio file.
+func sidecarLyricsPaths(audioPath string) []string {
+	stem := strings.TrimSuffix(audioPath, filepath.Ext(audioPath))
+	return []string{stem + ".lrc", stem + ".LRC"}
+}
+
+func readSidecarLyrics(audioPath string) (string, bool) {
+	for _, path := range sidecarLyricsPaths(audioPath) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		lyrics := strings.TrimSpace(strings.TrimPrefix(string(data), "\ufeff"))
+		if lyrics == "" {
+			continue
+		}
+		return strings.ReplaceAll(lyrics, "\r\n", "\n"), true
+	}
+	return "", false
+}
+
+// embedSidecarLyrics fills the lyrics tag from a sidecar .lrc file when the
+// source does not carry lyrics of its own.
+func embedSidecarLyrics(inputPath string, metadata *Metadata) bool {
+	if metadata.Tag("lyrics") != "" {
+		return false
+	}
+	lyrics, ok := readSidecarLyrics(inputPath)
+	if !ok {
+		return false
+	}
+	metadata.SetTag("lyrics", lyrics)
+	return true
+}
+
+// sourceLyrics returns the embedded lyrics, falling back to a sidecar .lrc.
+func sourceLyrics(inputPath string, metadata *Metadata) string {
+	if lyrics := metadata.Tag("lyrics"); lyrics != "" {
+		return lyrics
+	}
+	lyrics, _ := readSidecarLyrics(inputPath)
+	return lyrics
+}
+
+func isSyncedLyrics(lyrics string) bool {
+	return lrcTimestampPattern.MatchString(lyrics)
+}
+
+// stripLyricsTimestamps turns LRC lyrics into plain text by dropping ID tags
+// and line/word timestamps. Plain lyrics are returned unchanged.
+func stripLyricsTimestamps(lyrics string) string {
+	if !isSyncedLyrics(lyrics) {
+		return lyrics
+	}
+
+	var lines []string
+	blank := true
+	for _, line := range strings.Split(strings.ReplaceAll(lyrics, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if lrcIDTagPattern.MatchString(line) {
+			continue
+		}
+		line = lrcTimestampPattern.ReplaceAllString(line, "")
+		line = lrcWordTimePattern.ReplaceAllString(line, "")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func lyricsSidecarPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + ".lrc"
+}
+
+func writeLyricsSidecar(outputPath, lyrics string) error {
+	path := lyricsSidecarPath(outputPath)
+	if err := os.WriteFile(path, []byte(lyrics+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write lyrics sidecar %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStripLyricsTimestamps(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "plain lyrics unchanged",
+			input:    "First line\nSecond line",
+			expected: "First line\nSecond line",
+		},
+		{
+			name:     "line timestamps and id tags",
+			input:    "[ar:Artist]\n[ti:Title]\n[00:01.00]First line\n[00:02.50]Second line",
+			expected: "First line\nSecond line",
+		},
+		{
+			name:     "repeated and word timestamps",
+			input:    "[00:01.00][01:01.00]Chorus <00:01.20>word\n[00:05.00]\n[00:06.00]Verse",
+			expected: "Chorus word\n\nVerse",
+		},
+		{
+			name:     "crlf line endings",
+			input:    "[00:01.00]One\r\n[00:02.00]Two\r\n",
+			expected: "One\nTwo",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripLyricsTimestamps(tt.input); got != tt.expected {
+				t.Errorf("stripLyricsTimestamps(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEmbedSidecarLyrics(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	inputFile := helper.WriteInputFile("track.flac", []byte("audio"))
+	helper.WriteInputFile("track.lrc", []byte("\ufeff[00:01.00]Hello\r\n[00:02.00]World\r\n"))
+
+	metadata := &Metadata{}
+	if !embedSidecarLyrics(inputFile, metadata) {
+		t.Fatal("embedSidecarLyrics did not embed sidecar lyrics")
+	}
+	if got := metadata.Tag("lyrics"); got != "[00:01.00]Hello\n[00:02.00]World" {
+		t.Fatalf("lyrics tag = %q", got)
+	}
+
+	metadata.SetTag("LYRICS", "Embedded")
+	if embedSidecarLyrics(inputFile, metadata) {
+		t.Fatal("embedSidecarLyrics replaced existing lyrics")
+	}
+	if got := metadata.Tag("lyrics"); got != "Embedded" {
+		t.Fatalf("lyrics tag = %q, want embedded lyrics", got)
+	}
+}
+
+func TestBuildFFmpegArgsPlainLyricsForIPod(t *testing.T) {
+	metadata := &Metadata{}
+	metadata.SetTag("lyrics", "[00:01.00]Hello\n[00:02.00]World")
+
+	args := strings.Join(buildFFmpegArgs("/input.flac", "/output.m4a", Config{Codec: "aac", IPod: true, PlainLyrics: true}, metadata), "\x00")
+	if !strings.Contains(args, "lyrics=Hello\nWorld") {
+		t.Fatalf("expected plain lyrics in args: %q", args)
+	}
+
+	args = strings.Join(buildFFmpegArgs("/input.flac", "/output.flac", Config{Codec: "flac", PlainLyrics: true}, metadata), "\x00")
+	if !strings.Contains(args, "lyrics=[00:01.00]Hello") {
+		t.Fatalf("expected synced lyrics for non-iPod output: %q", args)
+	}
+}
+
+func TestWriteLyricsSidecar(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "track.m4a")
+	if err := writeLyricsSidecar(outputPath, "[00:01.00]Hello"); err != nil {
+		t.Fatalf("writeLyricsSidecar failed: %v", err)
+	}
+
+	data, err := os.ReadFile(strings.TrimSuffix(outputPath, ".m4a") + ".lrc")
+	if err != nil {
+		t.Fatalf("failed to read sidecar: %v", err)
+	}
+	if string(data) != "[00:01.00]Hello\n" {
+		t.Fatalf("sidecar content = %q", data)
+	}
+}
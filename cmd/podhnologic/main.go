@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -23,20 +22,99 @@ type Config struct {
 	Codec     string `json:"codec"`
 	IPod      bool   `json:"ipod"`
 	NoLyrics  bool   `json:"no_lyrics"`
+
+	LyricsImport bool `json:"lyrics_import"`
+	LyricsExport bool `json:"lyrics_export"`
+	PlainLyrics  bool `json:"plain_lyrics"`
+
+	OutputTemplate string `json:"output_template,omitempty"`
+	Filesystem     string `json:"filesystem,omitempty"`
+	Collisions     string `json:"collisions,omitempty"`
+
+	Playlists           bool   `json:"playlists"`
+	PlaylistPathStyle   string `json:"playlist_path_style,omitempty"`
+	PlaylistLineEndings string `json:"playlist_line_endings,omitempty"`
+
+	Include     []string `json:"include,omitempty"`
+	Exclude     []string `json:"exclude,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+	Artists     []string `json:"artists,omitempty"`
+	MinDuration string   `json:"min_duration,omitempty"`
+
+	FollowSymlinks bool `json:"follow_symlinks"`
+	SkipHidden     bool `json:"skip_hidden"`
+	ProbeDetection bool `json:"probe_detection"`
+
+	VideoInput     bool     `json:"video_input"`
+	AudioLanguages []string `json:"audio_languages,omitempty"`
+	AudioStream    *int     `json:"audio_stream,omitempty"`
+
+	FFmpegMode string `json:"ffmpeg_mode,omitempty"`
+	FFmpegPath string `json:"ffmpeg_path,omitempty"`
+
+	JobTimeout      string   `json:"job_timeout,omitempty"`
+	JobTimeoutScale *float64 `json:"job_timeout_scale,omitempty"`
+	CPULimit        string   `json:"cpu_limit,omitempty"`
+	MemoryLimit     string   `json:"memory_limit,omitempty"`
+
+	Targets []Target `json:"targets,omitempty"`
 }
 
 var (
 	// Command-line flags
-	inputFlag       = flag.String("input", "", "Input directory containing audio files")
-	outputFlag      = flag.String("output", "", "Output directory for converted files")
-	codecFlag       = flag.String("codec", "", "Target codec: flac, alac, aac, wav, mp3, opus")
-	ipodFlag        = flag.Bool("ipod", false, "Enable iPod optimizations")
-	noLyricsFlag    = flag.Bool("no-lyrics", false, "Strip lyrics metadata")
-	dryRunFlag      = flag.Bool("dry-run", false, "Show what would be done without converting")
-	interactiveFlag = flag.Bool("interactive", false, "Force interactive mode")
-	versionFlag     = flag.Bool("version", false, "Show version information")
+	inputFlag         = flag.String("input", "", "Input directory containing audio files")
+	outputFlag        = flag.String("output", "", "Output directory for converted files")
+	codecFlag         = flag.String("codec", "", "Target codec: flac, alac, aac, wav, mp3, opus")
+	ipodFlag          = flag.Bool("ipod", false, "Enable iPod optimizations")
+	noIPodFlag        = flag.Bool("no-ipod", false, "Disable iPod optimizations saved in the profile")
+	noLyricsFlag      = flag.Bool("no-lyrics", false, "Strip lyrics metadata")
+	lyricsFlag        = flag.Bool("lyrics", false, "Keep lyrics metadata even if the profile strips them")
+	lrcImportFlag     = flag.Bool("lrc-import", false, "Embed a matching .lrc sidecar when the source has no lyrics")
+	lrcExportFlag     = flag.Bool("lrc-export", false, "Write .lrc sidecars next to converted files")
+	plainLyricsFlag   = flag.Bool("plain-lyrics", false, "Strip LRC timestamps from lyrics for iPod outputs")
+	templateFlag      = flag.String("output-template", "", "Output path template, e.g. '{albumartist}/{album}/{track:02} {title}'")
+	filesystemFlag    = flag.String("filesystem", "", "Target filesystem naming rules: posix, fat32, exfat, ntfs (default posix)")
+	collisionsFlag    = flag.String("collisions", "", "Output collision strategy: lossless, suffix, error (default lossless)")
+	playlistsFlag     = flag.Bool("playlists", false, "Rewrite M3U/M3U8/PLS playlists to point at converted files")
+	playlistPathsFlag = flag.String("playlist-paths", "", "Playlist entry style: relative, absolute (default relative)")
+	playlistEOLFlag   = flag.String("playlist-line-endings", "", "Playlist line endings: lf, crlf (default lf)")
+	minDurationFlag   = flag.String("min-duration", "", "Skip sources shorter than this, e.g. 30s")
+	followLinksFlag   = flag.Bool("follow-symlinks", false, "Follow symlinked folders in the input library")
+	hiddenFlag        = flag.Bool("skip-hidden", false, "Skip hidden files and folders while scanning")
+	probeFlag         = flag.Bool("probe", false, "Detect audio by probing file contents instead of by extension")
+	videoFlag         = flag.Bool("video", false, "Also convert video files, keeping the best audio track and a thumbnail as cover art")
+	ffmpegModeFlag    = flag.String("ffmpeg-mode", "", "FFmpeg to use: auto, direct, hidden, or system (FFmpeg on PATH)")
+	ffmpegPathFlag    = flag.String("ffmpeg-path", "", "System ffmpeg binary, or the directory holding ffmpeg and ffprobe")
+	audioStreamFlag   = flag.Int("audio-stream", -1, "Convert this stream index when a source has it as an audio stream, instead of picking one")
+	timeoutFlag       = flag.String("timeout", "", "Stop a conversion after this long plus --timeout-scale times the source duration, e.g. 10m, or off (default 5m)")
+	timeoutScaleFlag  = flag.Float64("timeout-scale", 2, "Extra timeout per second of source audio, in seconds")
+	cpuLimitFlag      = flag.String("cpu-limit", "", "CPU time each hidden-mode FFmpeg run may use, e.g. 10m")
+	memoryLimitFlag   = flag.String("memory-limit", "", "Memory each hidden-mode FFmpeg worker may use, e.g. 2G")
+	profileFlag       = flag.String("profile", "", "Use this named profile instead of the active one")
+	saveFlag          = flag.Bool("save", false, "Save these settings to the profile for future runs")
+	dryRunFlag        = flag.Bool("dry-run", false, "Show what would be done without converting")
+	interactiveFlag   = flag.Bool("interactive", false, "Force interactive mode")
+	versionFlag       = flag.Bool("version", false, "Show version information")
 )
 
+var (
+	includeFlag stringListFlag
+	excludeFlag stringListFlag
+	genreFlag   stringListFlag
+	artistFlag  stringListFlag
+	targetFlag  stringListFlag
+	langFlag    stringListFlag
+)
+
+func init() {
+	flag.Var(&includeFlag, "include", "Only convert paths matching this glob (repeatable), e.g. 'Rock/**'")
+	flag.Var(&excludeFlag, "exclude", "Skip paths matching this glob (repeatable), e.g. '**/Podcasts/**'")
+	flag.Var(&genreFlag, "genre", "Only convert sources whose genre contains this text (repeatable)")
+	flag.Var(&artistFlag, "artist", "Only convert sources whose artist contains this text (repeatable)")
+	flag.Var(&langFlag, "audio-language", "Preferred audio language when a source has several tracks, e.g. eng (repeatable, in order)")
+	flag.Var(&targetFlag, "target", "Only convert to this configured target (repeatable, default all)")
+}
+
 func main() {
 	flag.Parse()
 
@@ -56,45 +134,102 @@ func main() {
 		log.Fatalf("Failed to create config directory: %v", err)
 	}
 
-	// Load existing config
-	config := loadConfig(configDir)
+	// Subcommands take flags before or after their own arguments
+	command := flag.Arg(0)
+	var commandArgs []string
+	if command != "" {
+		commandArgs, err = parseInterspersed(flag.CommandLine, flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	switch command {
+	case "", "config":
+	case "doctor":
+		if len(commandArgs) > 0 {
+			log.Fatalf("doctor takes no arguments, got %q", strings.Join(commandArgs, " "))
+		}
+	default:
+		log.Fatalf("unknown command %q (want config or doctor)", command)
+	}
+
+	if command == "config" {
+		if err := runConfigCommand(commandArgs, configDir, *profileFlag, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
 
 	// Determine if we should run in interactive mode
 	interactive := *interactiveFlag || (flag.NFlag() == 0 && len(os.Args) == 1)
 
+	// Load the selected profile
+	file := loadConfigFile(configDir)
+	profileName := file.ActiveProfile
+	if *profileFlag != "" {
+		profileName = *profileFlag
+	}
+	config, ok := file.profile(profileName)
+	if !ok {
+		if !interactive && !*saveFlag {
+			log.Fatalf("unknown profile %q (configured: %s); add --save to create it", profileName, strings.Join(file.profileNames(), ", "))
+		}
+		fmt.Printf("Creating profile %q\n", profileName)
+	}
+
+	if command == "doctor" {
+		if err := applyFlags(&config, setFlags()); err != nil {
+			log.Fatal(err)
+		}
+		runner, err := ffmpegRunnerFor(config, os.Getenv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !runDoctor(config, profileName, runner, os.Stdout) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	if interactive {
 		// Interactive mode
-		if err := runInteractive(&config, configDir); err != nil {
+		if err := runInteractive(&config, configDir, profileName); err != nil {
 			log.Fatalf("Interactive mode failed: %v", err)
 		}
 	} else {
 		// Command-line mode: override config with flags
-		if *inputFlag != "" {
-			config.InputDir = expandPath(*inputFlag)
-		}
-		if *outputFlag != "" {
-			config.OutputDir = expandPath(*outputFlag)
-		}
-		if *codecFlag != "" {
-			config.Codec = *codecFlag
-		}
-		if *ipodFlag {
-			config.IPod = true
-		}
-		if *noLyricsFlag {
-			config.NoLyrics = true
+		if err := applyFlags(&config, setFlags()); err != nil {
+			log.Fatal(err)
 		}
 
 		// Validate required fields
-		if config.InputDir == "" || config.OutputDir == "" {
+		if config.InputDir == "" || !hasOutput(config) {
 			log.Fatal("--input and --output are required")
 		}
-		if config.Codec == "" && !config.IPod {
+		if !hasCodec(config) {
 			log.Fatal("--codec or --ipod is required")
 		}
 
-		// Save the config for future use
-		saveConfig(configDir, config)
+		// Check the configuration before touching any files; dry runs
+		// do not write to the output directories
+		if err := config.validate(!*dryRunFlag); err != nil {
+			log.Fatalf("Invalid configuration:\n%v", err)
+		}
+		for _, warning := range config.Warnings() {
+			fmt.Printf("⚠ %s\n", warning)
+		}
+
+		// Only persist the overrides when asked to
+		if *saveFlag {
+			if err := saveProfile(configDir, profileName, config); err != nil {
+				log.Fatalf("Failed to save config: %v", err)
+			}
+		}
+
+		// Narrow to the requested targets after saving so the full list is kept
+		if err := config.selectTargets(targetFlag); err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Set default codec for iPod mode
@@ -102,12 +237,165 @@ func main() {
 		config.Codec = "aac"
 	}
 
+	backend, err := conversionBackend(config, *dryRunFlag)
+	if err != nil {
+		log.Fatalf("FFmpeg is not usable: %v", err)
+	}
+
 	// Run the conversion
-	if err := runConversion(config, *dryRunFlag); err != nil {
+	err = runConversion(backend, config, *dryRunFlag)
+	closeFFmpegBackend(backend)
+	if err != nil {
 		log.Fatalf("Conversion failed: %v", err)
 	}
 }
 
+// parseInterspersed parses flags wherever they appear in args and returns the
+// remaining arguments in order. Everything after "--" is left unparsed.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		remaining := fs.Args()
+		if consumed := args[:len(args)-len(remaining)]; len(consumed) > 0 && consumed[len(consumed)-1] == "--" {
+			return append(rest, remaining...), nil
+		}
+		if len(remaining) == 0 {
+			return rest, nil
+		}
+		rest = append(rest, remaining[0])
+		args = remaining[1:]
+	}
+}
+
+// setFlags returns the names of the flags given on the command line.
+func setFlags() map[string]bool {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	return set
+}
+
+// applyFlags overrides config with the flags that were given. Boolean flags
+// apply whether true or false, so --ipod=false and --no-ipod both turn iPod mode off.
+func applyFlags(config *Config, set map[string]bool) error {
+	if set["ipod"] && set["no-ipod"] && *ipodFlag == *noIPodFlag {
+		return fmt.Errorf("--ipod and --no-ipod conflict")
+	}
+	if set["lyrics"] && set["no-lyrics"] && *lyricsFlag == *noLyricsFlag {
+		return fmt.Errorf("--lyrics and --no-lyrics conflict")
+	}
+
+	if *inputFlag != "" {
+		config.InputDir = expandPath(*inputFlag)
+	}
+	if *outputFlag != "" {
+		config.OutputDir = expandPath(*outputFlag)
+	}
+	if *codecFlag != "" {
+		config.Codec = *codecFlag
+	}
+	if set["ipod"] {
+		config.IPod = *ipodFlag
+	}
+	if set["no-ipod"] {
+		config.IPod = !*noIPodFlag
+	}
+	if set["no-lyrics"] {
+		config.NoLyrics = *noLyricsFlag
+	}
+	if set["lyrics"] {
+		config.NoLyrics = !*lyricsFlag
+	}
+	if set["lrc-import"] {
+		config.LyricsImport = *lrcImportFlag
+	}
+	if set["lrc-export"] {
+		config.LyricsExport = *lrcExportFlag
+	}
+	if set["plain-lyrics"] {
+		config.PlainLyrics = *plainLyricsFlag
+	}
+	if *templateFlag != "" {
+		config.OutputTemplate = *templateFlag
+	}
+	if *filesystemFlag != "" {
+		config.Filesystem = *filesystemFlag
+	}
+	if *collisionsFlag != "" {
+		config.Collisions = *collisionsFlag
+	}
+	if set["playlists"] {
+		config.Playlists = *playlistsFlag
+	}
+	if *playlistPathsFlag != "" {
+		config.PlaylistPathStyle = *playlistPathsFlag
+	}
+	if *playlistEOLFlag != "" {
+		config.PlaylistLineEndings = *playlistEOLFlag
+	}
+	if len(includeFlag) > 0 {
+		config.Include = includeFlag
+	}
+	if len(excludeFlag) > 0 {
+		config.Exclude = excludeFlag
+	}
+	if len(genreFlag) > 0 {
+		config.Genres = genreFlag
+	}
+	if len(artistFlag) > 0 {
+		config.Artists = artistFlag
+	}
+	if *minDurationFlag != "" {
+		config.MinDuration = *minDurationFlag
+	}
+	if set["follow-symlinks"] {
+		config.FollowSymlinks = *followLinksFlag
+	}
+	if set["skip-hidden"] {
+		config.SkipHidden = *hiddenFlag
+	}
+	if set["probe"] {
+		config.ProbeDetection = *probeFlag
+	}
+	if set["video"] {
+		config.VideoInput = *videoFlag
+	}
+	if len(langFlag) > 0 {
+		config.AudioLanguages = langFlag
+	}
+	if *ffmpegModeFlag != "" {
+		config.FFmpegMode = *ffmpegModeFlag
+	}
+	if *ffmpegPathFlag != "" {
+		config.FFmpegPath = expandPath(*ffmpegPathFlag)
+	}
+	if *timeoutFlag != "" {
+		config.JobTimeout = *timeoutFlag
+	}
+	if set["timeout-scale"] {
+		scale := *timeoutScaleFlag
+		config.JobTimeoutScale = &scale
+	}
+	if *cpuLimitFlag != "" {
+		config.CPULimit = *cpuLimitFlag
+	}
+	if *memoryLimitFlag != "" {
+		config.MemoryLimit = *memoryLimitFlag
+	}
+	if set["audio-stream"] {
+		config.AudioStream = nil
+		if *audioStreamFlag >= 0 {
+			index := *audioStreamFlag
+			config.AudioStream = &index
+		}
+	}
+	return nil
+}
+
 func getConfigDir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
@@ -116,38 +404,20 @@ func getConfigDir() (string, error) {
 	return filepath.Join(homeDir, ".podhnologic"), nil
 }
 
+// loadConfig returns the active profile.
 func loadConfig(configDir string) Config {
-	configPath := filepath.Join(configDir, "config.json")
-
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		// Config doesn't exist yet, return empty config
-		return Config{}
-	}
-
-	var config Config
-	if err := json.Unmarshal(data, &config); err != nil {
-		log.Printf("Warning: Failed to parse config file: %v", err)
-		return Config{}
-	}
-
+	config, _ := loadConfigFile(configDir).profile("")
 	return config
 }
 
+// saveConfig stores config as the active profile.
 func saveConfig(configDir string, config Config) error {
-	configPath := filepath.Join(configDir, "config.json")
-
-	data, err := json.MarshalIndent(config, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	return os.WriteFile(configPath, data, 0644)
+	return saveProfile(configDir, "", config)
 }
 
-func runInteractive(config *Config, configDir string) error {
+func runInteractive(config *Config, configDir, profile string) error {
 	// Create and run the interactive menu
-	menu := NewMenuModel(config, configDir)
+	menu := NewMenuModel(config, configDir, profile)
 
 	p := tea.NewProgram(menu, tea.WithAltScreen())
 	finalModel, err := p.Run()
@@ -2,8 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"flag"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -329,3 +332,25 @@ func TestIsAudioFile(t *testing.T) {
 		})
 	}
 }
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	profile := fs.String("profile", "", "")
+	dryRun := fs.Bool("dry-run", false, "")
+
+	args, err := parseInterspersed(fs, []string{"set", "--dry-run", "codec", "aac", "--profile", "car", "--", "--literal"})
+	if err != nil {
+		t.Fatalf("parseInterspersed error = %v", err)
+	}
+	if want := []string{"set", "codec", "aac", "--literal"}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+	if *profile != "car" || !*dryRun {
+		t.Errorf("profile = %q, dry-run = %v; want flags after the arguments to be parsed", *profile, *dryRun)
+	}
+
+	fs.SetOutput(io.Discard)
+	if _, err := parseInterspersed(fs, []string{"doctor", "--no-such-flag"}); err == nil {
+		t.Error("parseInterspersed accepted an unknown flag")
+	}
+}
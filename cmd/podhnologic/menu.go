@@ -20,6 +20,7 @@ type menuItem struct {
 type menuModel struct {
 	config         *Config
 	configDir      string
+	profile        string
 	items          []menuItem
 	cursor         int
 	width          int
@@ -133,7 +134,7 @@ func initMenuStyles() {
 		PaddingRight(2)
 }
 
-func NewMenuModel(config *Config, configDir string) menuModel {
+func NewMenuModel(config *Config, configDir, profile string) menuModel {
 	items := []menuItem{
 		{
 			label:    "Input Directory",
@@ -153,6 +154,9 @@ func NewMenuModel(config *Config, configDir string) menuModel {
 				if c.OutputDir == "" {
 					return "(not set)"
 				}
+				if c.InputDir != "" && outputOverlap(c.InputDir, c.OutputDir) == overlapNested {
+					return shortenPath(c.OutputDir) + " (inside input, skipped when scanning)"
+				}
 				return shortenPath(c.OutputDir)
 			},
 			action: "output",
@@ -190,16 +194,54 @@ func NewMenuModel(config *Config, configDir string) menuModel {
 			},
 			action: "lyrics",
 		},
+		{
+			label:    "Output Template",
+			shortcut: "T",
+			value: func(c *Config) string {
+				if c.OutputTemplate == "" {
+					return "mirror input"
+				}
+				return c.OutputTemplate
+			},
+			action: "template",
+		},
+		{
+			label:    "Filters",
+			shortcut: "F",
+			value: func(c *Config) string {
+				return describeFilters(*c)
+			},
+			action: "filters",
+		},
+		{
+			label:    "Profile",
+			shortcut: "R",
+			value: func(c *Config) string {
+				return "switch, new, rename, delete"
+			},
+			action: "profile",
+		},
 	}
 
 	return menuModel{
 		config:    config,
 		configDir: configDir,
+		profile:   profile,
 		items:     items,
 		cursor:    0,
 	}
 }
 
+// save stores the current settings under the profile being edited and shows
+// any failure in the menu.
+func (m *menuModel) save() error {
+	err := saveProfile(m.configDir, m.profile, *m.config)
+	if err != nil {
+		m.errorMessage = "⚠ Could not save settings: " + err.Error()
+	}
+	return err
+}
+
 func (m menuModel) Init() tea.Cmd {
 	return nil
 }
@@ -250,6 +292,18 @@ func (m menuModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.cursor = 4
 			return m.handleAction()
 
+		case "t", "T":
+			m.cursor = 5
+			return m.handleAction()
+
+		case "f", "F":
+			m.cursor = 6
+			return m.handleAction()
+
+		case "r", "R":
+			m.cursor = 7
+			return m.handleAction()
+
 		case "s", "S":
 			return m.startConversion()
 		}
@@ -270,7 +324,7 @@ func (m menuModel) handleAction() (tea.Model, tea.Cmd) {
 		dir, err := RunBubbleTeaDirectoryPicker("📥 Select Input Directory (audio files to convert)", m.config.InputDir)
 		if err == nil && dir != "" {
 			m.config.InputDir = dir
-			saveConfig(m.configDir, *m.config)
+			m.save()
 		}
 		// Force a full redraw after returning from sub-program
 		return m, tea.ClearScreen
@@ -279,7 +333,7 @@ func (m menuModel) handleAction() (tea.Model, tea.Cmd) {
 		dir, err := RunBubbleTeaDirectoryPicker("📤 Select Output Directory (converted files)", m.config.OutputDir)
 		if err == nil && dir != "" {
 			m.config.OutputDir = dir
-			saveConfig(m.configDir, *m.config)
+			m.save()
 		}
 		// Force a full redraw after returning from sub-program
 		return m, tea.ClearScreen
@@ -288,7 +342,7 @@ func (m menuModel) handleAction() (tea.Model, tea.Cmd) {
 		codec, err := selectCodec(m.config.Codec)
 		if err == nil && codec != "" {
 			m.config.Codec = codec
-			saveConfig(m.configDir, *m.config)
+			m.save()
 		}
 		// Force a full redraw after returning from sub-program
 		return m, tea.ClearScreen
@@ -298,26 +352,133 @@ func (m menuModel) handleAction() (tea.Model, tea.Cmd) {
 		if m.config.IPod && m.config.Codec == "" {
 			m.config.Codec = "aac"
 		}
-		saveConfig(m.configDir, *m.config)
+		m.save()
 
 	case "lyrics":
 		m.config.NoLyrics = !m.config.NoLyrics
-		saveConfig(m.configDir, *m.config)
+		m.save()
+
+	case "template":
+		tmpl, err := editOutputTemplate(m.config.OutputTemplate)
+		if err == nil {
+			if err := validateOutputTemplate(tmpl); err != nil {
+				m.errorMessage = "⚠ " + err.Error()
+				return m, tea.ClearScreen
+			}
+			m.config.OutputTemplate = tmpl
+			m.save()
+		}
+		// Force a full redraw after returning from sub-program
+		return m, tea.ClearScreen
+
+	case "filters":
+		filters, err := editFilters(*m.config)
+		if err == nil {
+			if err := validateFilters(filters); err != nil {
+				m.errorMessage = "⚠ " + err.Error()
+				return m, tea.ClearScreen
+			}
+			*m.config = filters
+			m.save()
+		}
+		// Force a full redraw after returning from sub-program
+		return m, tea.ClearScreen
+
+	case "profile":
+		return m.manageProfiles()
 	}
 
 	return m, nil
 }
 
+const (
+	profileActionNew    = "+ New profile (copy of current)"
+	profileActionRename = "Rename current profile"
+	profileActionDelete = "Delete current profile"
+)
+
+// manageProfiles switches to another profile or creates, renames or deletes one.
+func (m menuModel) manageProfiles() (tea.Model, tea.Cmd) {
+	// Make sure the profile being edited exists before listing
+	if err := m.save(); err != nil {
+		return m, tea.ClearScreen
+	}
+
+	names := loadConfigFile(m.configDir).profileNames()
+	choice, err := selectProfile(names, m.profile)
+	if err != nil {
+		return m, tea.ClearScreen
+	}
+
+	switch choice {
+	case profileActionNew:
+		name, err := promptProfileName("New profile name", "")
+		if err != nil {
+			break
+		}
+		if err := createProfile(m.configDir, name, *m.config); err != nil {
+			m.errorMessage = "⚠ " + err.Error()
+			break
+		}
+		m.profile = strings.TrimSpace(name)
+		m.successMessage = fmt.Sprintf("✓ Created profile %s", m.profile)
+
+	case profileActionRename:
+		name, err := promptProfileName("Rename profile", m.profile)
+		if err != nil || name == m.profile {
+			break
+		}
+		if err := renameProfile(m.configDir, m.profile, name); err != nil {
+			m.errorMessage = "⚠ " + err.Error()
+			break
+		}
+		m.profile = strings.TrimSpace(name)
+		m.successMessage = fmt.Sprintf("✓ Renamed profile to %s", m.profile)
+
+	case profileActionDelete:
+		deleted := m.profile
+		next, err := deleteProfile(m.configDir, deleted)
+		if err != nil {
+			m.errorMessage = "⚠ " + err.Error()
+			break
+		}
+		config, err := switchProfile(m.configDir, next)
+		if err != nil {
+			m.errorMessage = "⚠ " + err.Error()
+			break
+		}
+		*m.config = config
+		m.profile = next
+		m.successMessage = fmt.Sprintf("✓ Deleted profile %s", deleted)
+
+	default:
+		config, err := switchProfile(m.configDir, choice)
+		if err != nil {
+			m.errorMessage = "⚠ " + err.Error()
+			break
+		}
+		*m.config = config
+		m.profile = choice
+	}
+
+	// Force a full redraw after returning from sub-program
+	return m, tea.ClearScreen
+}
+
 func (m menuModel) startConversion() (tea.Model, tea.Cmd) {
 	// Validate configuration
-	if m.config.InputDir == "" || m.config.OutputDir == "" {
+	if m.config.InputDir == "" || !hasOutput(*m.config) {
 		m.errorMessage = "⚠ Please set both input and output directories"
 		return m, nil
 	}
-	if m.config.Codec == "" && !m.config.IPod {
+	if !hasCodec(*m.config) {
 		m.errorMessage = "⚠ Please set a codec or enable iPod mode"
 		return m, nil
 	}
+	if err := m.config.Validate(); err != nil {
+		m.errorMessage = "⚠ " + strings.ReplaceAll(err.Error(), "\n", "\n  ")
+		return m, nil
+	}
 
 	m.shouldStart = true
 	return m, tea.Quit
@@ -339,7 +500,7 @@ func (m menuModel) View() string {
 	b.WriteString("\n")
 
 	// Title
-	b.WriteString(menuTitleStyle.Render("  Configuration"))
+	b.WriteString(menuTitleStyle.Render("  Configuration: " + m.profile))
 	b.WriteString("\n\n")
 
 	// Menu items
@@ -431,7 +592,7 @@ func renderBanner() string {
 }
 
 func selectCodec(current string) (string, error) {
-	codecs := []string{"flac", "alac", "aac", "wav", "mp3", "opus"}
+	codecs := supportedCodecs
 
 	// Find current index
 	currentIndex := 0
@@ -457,3 +618,86 @@ func selectCodec(current string) (string, error) {
 	_, result, err := prompt.Run()
 	return result, err
 }
+
+func selectProfile(names []string, current string) (string, error) {
+	items := append(append([]string(nil), names...), profileActionNew, profileActionRename, profileActionDelete)
+
+	prompt := promptui.Select{
+		Label: "Switch or Edit Profile",
+		Items: items,
+		Templates: &promptui.SelectTemplates{
+			Active:   ansiHex(appleRainbowYellow) + "▶ {{ . }}" + colorReset,
+			Inactive: "  {{ . }}",
+			Selected: ansiHex(applePhosphorBright) + "✓ {{ . }}" + colorReset,
+		},
+		CursorPos: findIndex(names, current),
+		Size:      8,
+	}
+
+	_, result, err := prompt.Run()
+	return result, err
+}
+
+func promptProfileName(label, current string) (string, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		Default:   current,
+		AllowEdit: true,
+	}
+
+	result, err := prompt.Run()
+	return strings.TrimSpace(result), err
+}
+
+func editOutputTemplate(current string) (string, error) {
+	prompt := promptui.Prompt{
+		Label:     "Output Template (empty mirrors input paths)",
+		Default:   current,
+		AllowEdit: true,
+	}
+
+	result, err := prompt.Run()
+	return strings.TrimSpace(result), err
+}
+
+// editFilters prompts for each filter in turn; lists are comma-separated.
+func editFilters(config Config) (Config, error) {
+	fields := []struct {
+		label string
+		list  *[]string
+		value *string
+	}{
+		{label: "Include globs (comma-separated)", list: &config.Include},
+		{label: "Exclude globs (comma-separated)", list: &config.Exclude},
+		{label: "Genres (comma-separated)", list: &config.Genres},
+		{label: "Artists (comma-separated)", list: &config.Artists},
+		{label: "Minimum duration (e.g. 30s)", value: &config.MinDuration},
+	}
+
+	for _, field := range fields {
+		current := ""
+		if field.list != nil {
+			current = strings.Join(*field.list, ", ")
+		} else {
+			current = *field.value
+		}
+
+		prompt := promptui.Prompt{
+			Label:     field.label,
+			Default:   current,
+			AllowEdit: true,
+		}
+		result, err := prompt.Run()
+		if err != nil {
+			return config, err
+		}
+
+		if field.list != nil {
+			*field.list = splitListInput(result)
+		} else {
+			*field.value = strings.TrimSpace(result)
+		}
+	}
+
+	return config, nil
+}
@@ -1,12 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func probeMetadata(filePath string) (*Metadata, error) {
-	result, err := runFFprobe([]string{
+// metadataProber is implemented by backends that can read metadata without
+// running ffprobe.
+type metadataProber interface {
+	ProbeMetadata(ctx context.Context, path string) (*Metadata, error)
+}
+
+// probeMetadata reads a file's tags and streams as libavformat numbers them:
+// in process when the backend can, and with ffprobe otherwise. Files the
+// in-process prober cannot read are handed to ffprobe too, whose error output
+// explains the problem. A timeout other than 0 bounds the whole probe.
+func probeMetadata(backend FFmpegBackend, filePath string, timeout time.Duration) (*Metadata, error) {
+	ctx, cancel := jobContext(timeout)
+	defer cancel()
+
+	if prober, ok := backend.(metadataProber); ok {
+		if metadata, err := prober.ProbeMetadata(ctx, filePath); err == nil {
+			return metadata, nil
+		}
+	}
+
+	result, err := runFFprobeContext(ctx, backend, []string{
 		"-v", "quiet",
 		"-print_format", "json",
 		"-show_format",
@@ -24,3 +47,61 @@ func probeMetadata(filePath string) (*Metadata, error) {
 
 	return &metadata, nil
 }
+
+// Tag looks up a format tag case-insensitively.
+func (m *Metadata) Tag(key string) string {
+	if m == nil {
+		return ""
+	}
+	if value, ok := m.Format.Tags[key]; ok {
+		return value
+	}
+	for k, value := range m.Format.Tags {
+		if strings.EqualFold(k, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+// SetTag replaces a format tag, dropping any differently-cased duplicates.
+func (m *Metadata) SetTag(key, value string) {
+	if m.Format.Tags == nil {
+		m.Format.Tags = make(map[string]string)
+	}
+	for k := range m.Format.Tags {
+		if strings.EqualFold(k, key) {
+			delete(m.Format.Tags, k)
+		}
+	}
+	m.Format.Tags[key] = value
+}
+
+// Duration returns the longest audio stream duration, or 0 when unknown.
+func (m *Metadata) Duration() time.Duration {
+	if m == nil {
+		return 0
+	}
+	var longest float64
+	for _, stream := range m.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		if seconds, err := strconv.ParseFloat(stream.Duration, 64); err == nil && seconds > longest {
+			longest = seconds
+		}
+	}
+	return time.Duration(longest * float64(time.Second))
+}
+
+// metadataSummary names the source as "artist - album - title", leaving out
+// missing tags.
+func metadataSummary(m *Metadata) string {
+	var parts []string
+	for _, key := range []string{"artist", "album", "title"} {
+		if value := strings.TrimSpace(m.Tag(key)); value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, " - ")
+}
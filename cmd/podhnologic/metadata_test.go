@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// probingBackend is a fake backend that can also probe in process.
+type probingBackend struct {
+	*fakeBackend
+	probed map[string]*Metadata
+	probes int
+}
+
+func (b *probingBackend) ProbeMetadata(ctx context.Context, path string) (*Metadata, error) {
+	b.probes++
+	if metadata, ok := b.probed[path]; ok {
+		return metadata, nil
+	}
+	return nil, errors.New("unsupported input")
+}
+
+func TestProbeMetadataPrefersInProcessProbe(t *testing.T) {
+	backend := &probingBackend{
+		fakeBackend: newFakeBackend(),
+		probed: map[string]*Metadata{
+			"/music/song.flac": {Streams: []MetadataStream{{CodecType: "audio", CodecName: "flac", Duration: "180.000000"}}},
+		},
+	}
+
+	metadata, err := probeMetadata(backend, "/music/song.flac", 0)
+	if err != nil {
+		t.Fatalf("probeMetadata failed: %v", err)
+	}
+	if metadata.Duration().Seconds() != 180 {
+		t.Errorf("duration = %v, want the in-process probe's 3m", metadata.Duration())
+	}
+	if calls := backend.Calls(LinkedFFmpegToolFFprobe); len(calls) != 0 {
+		t.Errorf("ffprobe ran %d times, want 0", len(calls))
+	}
+}
+
+func TestProbeMetadataFallsBackToFFprobe(t *testing.T) {
+	backend := &probingBackend{fakeBackend: newFakeBackend()}
+	backend.Metadata["/music/odd.tak"] = &Metadata{Streams: []MetadataStream{{CodecType: "audio", CodecName: "tak"}}}
+
+	metadata, err := probeMetadata(backend, "/music/odd.tak", 0)
+	if err != nil {
+		t.Fatalf("probeMetadata failed: %v", err)
+	}
+	if backend.probes != 1 || len(backend.Calls(LinkedFFmpegToolFFprobe)) != 1 {
+		t.Errorf("in-process probes = %d, ffprobe calls = %d; want 1 each", backend.probes, len(backend.Calls(LinkedFFmpegToolFFprobe)))
+	}
+	if len(metadata.Streams) != 1 || metadata.Streams[0].CodecName != "tak" {
+		t.Errorf("metadata = %+v, want ffprobe's", metadata)
+	}
+}
+
+func TestLinkedFFmpegRunnerProbesInProcessOnlyInDirectMode(t *testing.T) {
+	for _, mode := range []LinkedFFmpegMode{LinkedFFmpegModeSystem, LinkedFFmpegModeHidden} {
+		runner := LinkedFFmpegRunner{Mode: mode}
+		if _, err := runner.ProbeMetadata(context.Background(), "song.flac"); !errors.Is(err, ErrLinkedFFmpegUnavailable) {
+			t.Errorf("%s mode ProbeMetadata error = %v, want ErrLinkedFFmpegUnavailable", mode, err)
+		}
+	}
+}
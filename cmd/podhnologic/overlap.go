@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type directoryOverlap int
+
+const (
+	overlapNone directoryOverlap = iota
+	// overlapSame means both paths name one directory, possibly through a symlink.
+	overlapSame
+	// overlapNested means the output directory is inside the input directory.
+	overlapNested
+)
+
+// resolvePath makes path absolute and resolves symlinks in the part of it
+// that exists, so a directory that has not been created yet still resolves.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+
+	existing := existingAncestor(abs)
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return abs
+	}
+	rest, err := filepath.Rel(existing, abs)
+	if err != nil || rest == "." {
+		return resolved
+	}
+	return filepath.Join(resolved, rest)
+}
+
+// outputOverlap reports how outputDir relates to inputDir. Symlinks are
+// resolved, and the output's existing ancestors are compared by file identity
+// so bind mounts and case-insensitive aliases are caught too.
+func outputOverlap(inputDir, outputDir string) directoryOverlap {
+	input, output := resolvePath(inputDir), resolvePath(outputDir)
+	if input == output {
+		return overlapSame
+	}
+	if strings.HasPrefix(output, strings.TrimSuffix(input, string(filepath.Separator))+string(filepath.Separator)) {
+		return overlapNested
+	}
+
+	inputInfo, err := os.Stat(input)
+	if err != nil {
+		return overlapNone
+	}
+	dir := output
+	for {
+		if info, err := os.Stat(dir); err == nil && os.SameFile(info, inputInfo) {
+			if dir == output {
+				return overlapSame
+			}
+			return overlapNested
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return overlapNone
+		}
+		dir = parent
+	}
+}
+
+// nestedOutputDirs lists the output directories that live inside the input
+// directory and must be left out of the scan.
+func nestedOutputDirs(config Config) []string {
+	var dirs []string
+	for _, target := range config.targetConfigs() {
+		if outputOverlap(config.InputDir, target.Config.OutputDir) == overlapNested {
+			dirs = append(dirs, target.Config.OutputDir)
+		}
+	}
+	return dirs
+}
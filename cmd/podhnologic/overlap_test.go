@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestOutputOverlap(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	if got := outputOverlap(helper.inputDir, helper.outputDir); got != overlapNone {
+		t.Errorf("separate directories = %v, want none", got)
+	}
+	if got := outputOverlap(helper.inputDir, helper.inputDir+string(filepath.Separator)); got != overlapSame {
+		t.Errorf("same directory = %v, want same", got)
+	}
+	if got := outputOverlap(helper.inputDir, filepath.Join(helper.inputDir, "iPod", "Music")); got != overlapNested {
+		t.Errorf("nested, not yet created = %v, want nested", got)
+	}
+	if got := outputOverlap(filepath.Join(helper.inputDir, "Rock"), helper.inputDir); got != overlapNone {
+		t.Errorf("input inside output = %v, want none", got)
+	}
+}
+
+func TestOutputOverlapThroughSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	alias := filepath.Join(helper.tempDir, "alias")
+	if err := os.Symlink(helper.inputDir, alias); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	if got := outputOverlap(helper.inputDir, alias); got != overlapSame {
+		t.Errorf("symlink to input = %v, want same", got)
+	}
+	if got := outputOverlap(alias, filepath.Join(helper.inputDir, "out")); got != overlapNested {
+		t.Errorf("output inside symlinked input = %v, want nested", got)
+	}
+	if got := outputOverlap(helper.inputDir, filepath.Join(alias, "out")); got != overlapNested {
+		t.Errorf("output inside input through symlink = %v, want nested", got)
+	}
+
+	config := Config{InputDir: helper.inputDir, OutputDir: alias, Codec: "flac"}
+	if err := config.Validate(); err == nil {
+		t.Error("Validate accepted an output that aliases the input")
+	}
+}
+
+func TestCollectAudioFilesSkipsNestedOutput(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	song := helper.WriteInputFile("Artist/song.flac", []byte("audio"))
+	helper.WriteInputFile("Converted/Artist/song.m4a", []byte("converted"))
+
+	config := Config{InputDir: helper.inputDir, OutputDir: filepath.Join(helper.inputDir, "Converted"), Codec: "aac"}
+	excluded := nestedOutputDirs(config)
+	if len(excluded) != 1 {
+		t.Fatalf("nestedOutputDirs = %v, want the output directory", excluded)
+	}
+
+	files, err := collectAudioFiles(helper.inputDir, excluded...)
+	if err != nil {
+		t.Fatalf("collectAudioFiles failed: %v", err)
+	}
+	if len(files) != 1 || files[0] != song {
+		t.Errorf("collectAudioFiles = %v, want only %s", files, song)
+	}
+}
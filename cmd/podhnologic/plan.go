@@ -0,0 +1,353 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	collisionLossless = "lossless"
+	collisionSuffix   = "suffix"
+	collisionError    = "error"
+)
+
+var collisionStrategies = []string{collisionLossless, collisionSuffix, collisionError}
+
+var losslessExtensions = map[string]bool{
+	".aif": true, ".aiff": true, ".ape": true, ".dsf": true, ".flac": true,
+	".shn": true, ".tak": true, ".tta": true, ".w64": true, ".wav": true, ".wv": true,
+}
+
+var losslessCodecs = map[string]bool{
+	"alac": true, "ape": true, "flac": true, "mlp": true, "shorten": true,
+	"tak": true, "truehd": true, "tta": true, "wavpack": true, "wmalossless": true,
+}
+
+// conversionJob is one source file and the output path planned for it in one target.
+type conversionJob struct {
+	InputPath  string
+	RelPath    string
+	OutputPath string
+	Metadata   *Metadata
+	Target     string
+	Config     Config
+	Renamed    bool
+	Filtered   string
+}
+
+// outputCollision records several sources that map onto the same output path.
+type outputCollision struct {
+	Target     string
+	OutputPath string
+	Inputs     []string
+	Kept       []string
+	CaseOnly   bool
+}
+
+func validateCollisionStrategy(strategy string) error {
+	if strategy == "" {
+		return nil
+	}
+	for _, known := range collisionStrategies {
+		if strategy == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported collision strategy %q (want %s)", strategy, strings.Join(collisionStrategies, ", "))
+}
+
+// needsPlanMetadata reports whether output paths or filters depend on tags.
+func needsPlanMetadata(config Config) bool {
+	return config.OutputTemplate != "" || hasTagFilters(config)
+}
+
+// planMetadata reads the tags a plan needs, with the built-in tag reader when
+// it knows the format. That reader numbers streams its own way, so its result
+// is only returned as probed, for the conversion to reuse, in a dry run.
+func planMetadata(backend FFmpegBackend, inputPath string, config Config, dryRun bool) (metadata, probed *Metadata, err error) {
+	if tags, err := readTags(inputPath); err == nil {
+		if dryRun {
+			return tags, tags, nil
+		}
+		return tags, nil, nil
+	}
+	metadata, err = conversionMetadata(backend, inputPath, config, dryRun)
+	return metadata, metadata, err
+}
+
+func planFile(backend FFmpegBackend, inputPath string, config Config, dryRun bool) (conversionJob, error) {
+	jobs, err := planTargets(backend, inputPath, []targetConfig{{Config: config}}, dryRun)
+	if err != nil {
+		return conversionJob{}, err
+	}
+	return jobs[0], nil
+}
+
+// planTargets plans one source for every target, probing it at most once.
+func planTargets(backend FFmpegBackend, inputPath string, targets []targetConfig, dryRun bool) ([]conversionJob, error) {
+	// Templated output paths and tag filters need the source tags before anything else
+	var metadata, probed *Metadata
+	for _, target := range targets {
+		if needsPlanMetadata(target.Config) {
+			var err error
+			metadata, probed, err = planMetadata(backend, inputPath, target.Config, dryRun)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	jobs := make([]conversionJob, 0, len(targets))
+	for _, target := range targets {
+		config := target.Config
+
+		// Get relative path from input dir
+		relPath, err := filepath.Rel(config.InputDir, inputPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get relative path: %w", err)
+		}
+
+		job := conversionJob{
+			InputPath:  inputPath,
+			RelPath:    relPath,
+			OutputPath: buildOutputPath(config, relPath, metadata),
+			Metadata:   probed,
+			Target:     target.Name,
+			Config:     config,
+		}
+		selected, err := pathSelected(relPath, config)
+		if err != nil {
+			return nil, err
+		}
+		if !selected {
+			job.Filtered = "path"
+		} else if hasTagFilters(config) {
+			job.Filtered = tagFilterReason(metadata, config)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// planConversion plans every file for every target and resolves output path
+// collisions per target with the configured strategy. Files that fail to plan
+// are returned as errors.
+func planConversion(backend FFmpegBackend, files []string, config Config, dryRun bool) ([]conversionJob, []outputCollision, []error) {
+	targets := config.targetConfigs()
+	jobs := make([][]conversionJob, len(files))
+	planErrs := make([]error, len(files))
+
+	numWorkers := 1
+	for _, target := range targets {
+		if needsPlanMetadata(target.Config) {
+			numWorkers = runtime.NumCPU()
+		}
+	}
+	indexChan := make(chan int, len(files))
+	for i := range files {
+		indexChan <- i
+	}
+	close(indexChan)
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexChan {
+				jobs[i], planErrs[i] = planTargets(backend, files[i], targets, dryRun)
+			}
+		}()
+	}
+	wg.Wait()
+
+	byTarget := make(map[string][]conversionJob, len(targets))
+	var errs []error
+	filtered := 0
+	for i, err := range planErrs {
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if jobs[i][0].Filtered != "" {
+			filtered++
+			continue
+		}
+		for _, job := range jobs[i] {
+			byTarget[job.Target] = append(byTarget[job.Target], job)
+		}
+	}
+	if filtered > 0 {
+		fmt.Printf("Filtered out %d files\n", filtered)
+	}
+
+	var planned []conversionJob
+	var collisions []outputCollision
+	for _, target := range targets {
+		resolved, targetCollisions := resolveCollisions(byTarget[target.Name], target.Config)
+		for i := range targetCollisions {
+			targetCollisions[i].Target = target.Name
+		}
+		planned = append(planned, resolved...)
+		collisions = append(collisions, targetCollisions...)
+	}
+	return planned, collisions, errs
+}
+
+// batchJobsByInput groups jobs for the same source so each source is decoded once.
+func batchJobsByInput(jobs []conversionJob) [][]conversionJob {
+	index := make(map[string]int)
+	var batches [][]conversionJob
+	for _, job := range jobs {
+		i, ok := index[job.InputPath]
+		if !ok {
+			i = len(batches)
+			index[job.InputPath] = i
+			batches = append(batches, nil)
+		}
+		batches[i] = append(batches[i], job)
+	}
+	return batches
+}
+
+// resolveCollisions groups jobs by output path, case-folded when the target
+// filesystem is case-insensitive, and applies the collision strategy.
+func resolveCollisions(jobs []conversionJob, config Config) ([]conversionJob, []outputCollision) {
+	caseInsensitive := targetFilesystem(config).CaseInsensitive
+	key := func(path string) string {
+		if caseInsensitive {
+			return strings.ToLower(path)
+		}
+		return path
+	}
+
+	groups := make(map[string][]int)
+	var order []string
+	for i, job := range jobs {
+		k := key(job.OutputPath)
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], i)
+	}
+
+	taken := make(map[string]bool, len(groups))
+	for k := range groups {
+		taken[k] = true
+	}
+
+	drop := make(map[int]bool)
+	var collisions []outputCollision
+	for _, k := range order {
+		indexes := groups[k]
+		if len(indexes) < 2 {
+			continue
+		}
+
+		sort.SliceStable(indexes, func(a, b int) bool {
+			if config.Collisions == "" || config.Collisions == collisionLossless {
+				la, lb := isLosslessSource(jobs[indexes[a]]), isLosslessSource(jobs[indexes[b]])
+				if la != lb {
+					return la
+				}
+			}
+			return jobs[indexes[a]].InputPath < jobs[indexes[b]].InputPath
+		})
+
+		collision := outputCollision{OutputPath: jobs[indexes[0]].OutputPath}
+		for _, i := range indexes {
+			collision.Inputs = append(collision.Inputs, jobs[i].InputPath)
+			if jobs[i].OutputPath != collision.OutputPath {
+				collision.CaseOnly = true
+			}
+		}
+
+		switch config.Collisions {
+		case collisionError:
+			for _, i := range indexes {
+				drop[i] = true
+			}
+		case collisionSuffix:
+			collision.Kept = collision.Inputs
+			for n, i := range indexes[1:] {
+				jobs[i].OutputPath = uniqueOutputPath(jobs[i].OutputPath, n+2, taken, key)
+				jobs[i].Renamed = true
+			}
+		default:
+			collision.Kept = []string{jobs[indexes[0]].InputPath}
+			for _, i := range indexes[1:] {
+				drop[i] = true
+			}
+		}
+
+		collisions = append(collisions, collision)
+	}
+
+	var resolved []conversionJob
+	for i, job := range jobs {
+		if !drop[i] {
+			resolved = append(resolved, job)
+		}
+	}
+	return resolved, collisions
+}
+
+func uniqueOutputPath(outputPath string, n int, taken map[string]bool, key func(string) string) string {
+	ext := filepath.Ext(outputPath)
+	stem := strings.TrimSuffix(outputPath, ext)
+	for ; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, n, ext)
+		if !taken[key(candidate)] {
+			taken[key(candidate)] = true
+			return candidate
+		}
+	}
+}
+
+func isLosslessSource(job conversionJob) bool {
+	if job.Metadata != nil {
+		for _, stream := range job.Metadata.Streams {
+			if stream.CodecType == "audio" && stream.CodecName != "" {
+				return losslessCodecs[stream.CodecName] || strings.HasPrefix(stream.CodecName, "pcm_")
+			}
+		}
+	}
+	return losslessExtensions[strings.ToLower(filepath.Ext(job.InputPath))]
+}
+
+func reportCollisions(collisions []outputCollision) {
+	if len(collisions) == 0 {
+		return
+	}
+
+	fmt.Printf("⚠ %d output path collisions:\n", len(collisions))
+	for _, collision := range collisions {
+		kind := ""
+		if collision.CaseOnly {
+			kind = " (case-only)"
+		}
+		target := ""
+		if collision.Target != "" {
+			target = "[" + collision.Target + "] "
+		}
+		fmt.Printf("  %s%s%s\n", target, collision.OutputPath, kind)
+
+		kept := make(map[string]bool, len(collision.Kept))
+		for _, input := range collision.Kept {
+			kept[input] = true
+		}
+		for _, input := range collision.Inputs {
+			status := "skipped"
+			if kept[input] {
+				status = "kept"
+			}
+			fmt.Printf("    <- %s (%s)\n", input, status)
+		}
+	}
+	fmt.Println()
+}
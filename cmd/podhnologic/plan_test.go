@@ -0,0 +1,122 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func planTestFiles(t *testing.T, helper *TestHelper, names ...string) []string {
+	t.Helper()
+	var files []string
+	for _, name := range names {
+		files = append(files, helper.WriteInputFile(name, []byte("audio")))
+	}
+	return files
+}
+
+func TestPlanConversionPrefersLossless(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	files := planTestFiles(t, helper, "song.mp3", "song.flac", "other.mp3")
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac"}
+	jobs, collisions, errs := planConversion(newFakeBackend(), files, config, true)
+	if len(errs) != 0 {
+		t.Fatalf("planConversion errors: %v", errs)
+	}
+	if len(collisions) != 1 {
+		t.Fatalf("collisions = %d, want 1", len(collisions))
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("jobs = %d, want 2", len(jobs))
+	}
+
+	collision := collisions[0]
+	if collision.OutputPath != filepath.Join(helper.outputDir, "song.m4a") {
+		t.Errorf("collision output = %q", collision.OutputPath)
+	}
+	if len(collision.Kept) != 1 || filepath.Base(collision.Kept[0]) != "song.flac" {
+		t.Errorf("kept = %v, want song.flac", collision.Kept)
+	}
+	for _, job := range jobs {
+		if filepath.Base(job.InputPath) == "song.mp3" {
+			t.Errorf("lossy duplicate was planned: %v", job)
+		}
+	}
+}
+
+func TestPlanConversionDryRunProbesForTagFilters(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	files := planTestFiles(t, helper, "rock.wma", "jazz.wma")
+
+	backend := newFakeBackend()
+	for i, genre := range []string{"Rock", "Jazz"} {
+		metadata := &Metadata{}
+		metadata.Format.Tags = map[string]string{"genre": genre}
+		backend.Metadata[files[i]] = metadata
+	}
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac", Genres: []string{"rock"}}
+	jobs, _, errs := planConversion(backend, files, config, true)
+	if len(errs) != 0 {
+		t.Fatalf("planConversion errors: %v", errs)
+	}
+	if len(jobs) != 1 || filepath.Base(jobs[0].InputPath) != "rock.wma" {
+		t.Fatalf("jobs = %+v, want only rock.wma", jobs)
+	}
+}
+
+func TestPlanConversionSuffixesCollisions(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	files := planTestFiles(t, helper, "song.flac", "song.mp3", "song (2).wav")
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac", Collisions: collisionSuffix}
+	jobs, collisions, _ := planConversion(newFakeBackend(), files, config, true)
+	if len(collisions) != 1 || len(jobs) != 3 {
+		t.Fatalf("collisions = %d, jobs = %d; want 1 and 3", len(collisions), len(jobs))
+	}
+
+	outputs := make(map[string]bool)
+	for _, job := range jobs {
+		if outputs[job.OutputPath] {
+			t.Fatalf("duplicate output path after suffixing: %s", job.OutputPath)
+		}
+		outputs[job.OutputPath] = true
+	}
+	if !outputs[filepath.Join(helper.outputDir, "song (3).m4a")] {
+		t.Errorf("expected suffix to skip the existing song (2) output: %v", outputs)
+	}
+}
+
+func TestPlanConversionCaseOnlyCollisions(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	files := planTestFiles(t, helper, "Song.flac", "song.flac")
+
+	posix := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac"}
+	if _, collisions, _ := planConversion(newFakeBackend(), files, posix, true); len(collisions) != 0 {
+		t.Fatalf("posix target reported case-only collisions: %v", collisions)
+	}
+
+	fat32 := posix
+	fat32.Filesystem = "fat32"
+	_, collisions, _ := planConversion(newFakeBackend(), files, fat32, true)
+	if len(collisions) != 1 || !collisions[0].CaseOnly {
+		t.Fatalf("fat32 collisions = %+v, want one case-only collision", collisions)
+	}
+}
+
+func TestProcessFilesParallelErrorsOnCollisions(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	files := planTestFiles(t, helper, "song.flac", "song.mp3")
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac", Collisions: collisionError}
+	err := processFilesParallel(newFakeBackend(), files, config, true)
+	if err == nil || !strings.Contains(err.Error(), "collision") {
+		t.Fatalf("processFilesParallel error = %v, want collision error", err)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var playlistExtensions = []string{".m3u", ".m3u8", ".pls"}
+
+var playlistPathStyles = []string{"relative", "absolute"}
+
+var playlistLineEndings = map[string]string{
+	"lf":   "\n",
+	"crlf": "\r\n",
+}
+
+var plsEntryPattern = regexp.MustCompile(`^(?i)(file|title|length)(\d+)=(.*)$`)
+
+func isPlaylistFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, playlistExt := range playlistExtensions {
+		if ext == playlistExt {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePlaylistOptions(config Config) error {
+	if config.PlaylistPathStyle != "" && config.PlaylistPathStyle != "relative" && config.PlaylistPathStyle != "absolute" {
+		return fmt.Errorf("unsupported playlist path style %q (want %s)", config.PlaylistPathStyle, strings.Join(playlistPathStyles, ", "))
+	}
+	if config.PlaylistLineEndings != "" {
+		if _, ok := playlistLineEndings[config.PlaylistLineEndings]; !ok {
+			return fmt.Errorf("unsupported playlist line endings %q (want lf, crlf)", config.PlaylistLineEndings)
+		}
+	}
+	return nil
+}
+
+// collectPlaylistFiles lists the playlists under the input directory with the
+// same scan rules as the audio files, skipping the excluded directories.
+func collectPlaylistFiles(config Config, exclude []string) ([]string, error) {
+	options := scanOptionsFor(config, exclude)
+	options.Playlists = true
+	files, _, err := scanAudioFiles(config.InputDir, options)
+	return files, err
+}
+
+// playlistEntry is one track reference, with any lines that describe it.
+type playlistEntry struct {
+	Path   string
+	Title  string
+	Length string
+	Extra  []string
+}
+
+// convertPlaylists rewrites every playlist under the input directory, outside
+// the excluded directories, to point at converted outputs and writes it into
+// the output tree.
+func convertPlaylists(jobs []conversionJob, collisions []outputCollision, config Config, exclude []string, dryRun bool) []error {
+	playlists, err := collectPlaylistFiles(config, exclude)
+	if err != nil {
+		return []error{fmt.Errorf("failed to scan playlists: %w", err)}
+	}
+	if len(playlists) == 0 {
+		return nil
+	}
+
+	outputs := make(map[string]string, len(jobs))
+	for _, job := range jobs {
+		outputs[playlistKey(job.InputPath)] = job.OutputPath
+	}
+	if config.Collisions == "" || config.Collisions == collisionLossless {
+		for _, collision := range collisions {
+			for _, input := range collision.Inputs {
+				outputs[playlistKey(input)] = collision.OutputPath
+			}
+		}
+	}
+
+	fmt.Printf("\nConverting %d playlists\n", len(playlists))
+
+	var errs []error
+	for _, playlist := range playlists {
+		if err := convertPlaylist(playlist, outputs, config, dryRun); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func convertPlaylist(playlistPath string, outputs map[string]string, config Config, dryRun bool) error {
+	relPath, err := filepath.Rel(config.InputDir, playlistPath)
+	if err != nil {
+		return fmt.Errorf("failed to get relative path: %w", err)
+	}
+	outputPath := filepath.Join(config.OutputDir, sanitizeOutputPath(relPath, targetFilesystem(config)))
+
+	data, err := os.ReadFile(playlistPath)
+	if err != nil {
+		return fmt.Errorf("failed to read playlist %s: %w", playlistPath, err)
+	}
+
+	isPLS := strings.EqualFold(filepath.Ext(playlistPath), ".pls")
+	var header []string
+	var entries []playlistEntry
+	if isPLS {
+		entries = parsePLS(string(data))
+	} else {
+		header, entries = parseM3U(string(data))
+	}
+
+	var kept []playlistEntry
+	var missing []string
+	for _, entry := range entries {
+		if isPlaylistURL(entry.Path) {
+			kept = append(kept, entry)
+			continue
+		}
+
+		target, ok := outputs[playlistKey(resolvePlaylistEntry(playlistPath, entry.Path))]
+		if !ok || (!dryRun && !fileExists(target)) {
+			missing = append(missing, entry.Path)
+			continue
+		}
+
+		entry.Path = playlistEntryPath(outputPath, target, config.PlaylistPathStyle)
+		kept = append(kept, entry)
+	}
+
+	newline := playlistLineEndings[config.PlaylistLineEndings]
+	if newline == "" {
+		newline = "\n"
+	}
+	var content string
+	if isPLS {
+		content = formatPLS(kept, newline)
+	} else {
+		content = formatM3U(header, kept, newline)
+	}
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Playlist %s -> %s (%d entries)\n", playlistPath, outputPath, len(kept))
+	} else {
+		if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+		if err := os.WriteFile(outputPath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write playlist %s: %w", outputPath, err)
+		}
+		fmt.Printf("✓ Playlist: %s (%d entries)\n", relPath, len(kept))
+	}
+
+	if len(missing) > 0 {
+		fmt.Printf("  %d entries in %s were not converted:\n", len(missing), relPath)
+		for _, entry := range missing {
+			fmt.Printf("    - %s\n", entry)
+		}
+	}
+
+	return nil
+}
+
+func parseM3U(content string) ([]string, []playlistEntry) {
+	var header []string
+	var entries []playlistEntry
+	var pending []string
+
+	for i, line := range splitPlaylistLines(content) {
+		trimmed := strings.TrimSpace(line)
+		if i == 0 {
+			trimmed = strings.TrimPrefix(trimmed, "\ufeff")
+		}
+		switch {
+		case trimmed == "":
+			continue
+		case strings.HasPrefix(trimmed, "#EXTM3U") || (strings.HasPrefix(trimmed, "#PLAYLIST") && len(entries) == 0):
+			header = append(header, trimmed)
+		case strings.HasPrefix(trimmed, "#"):
+			pending = append(pending, trimmed)
+		default:
+			entries = append(entries, playlistEntry{Path: trimmed, Extra: pending})
+			pending = nil
+		}
+	}
+
+	return header, entries
+}
+
+func formatM3U(header []string, entries []playlistEntry, newline string) string {
+	var b strings.Builder
+	for _, line := range header {
+		b.WriteString(line + newline)
+	}
+	for _, entry := range entries {
+		for _, line := range entry.Extra {
+			b.WriteString(line + newline)
+		}
+		b.WriteString(entry.Path + newline)
+	}
+	return b.String()
+}
+
+func parsePLS(content string) []playlistEntry {
+	byIndex := make(map[int]*playlistEntry)
+	for _, line := range splitPlaylistLines(content) {
+		match := plsEntryPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		index, _ := strconv.Atoi(match[2])
+		entry, ok := byIndex[index]
+		if !ok {
+			entry = &playlistEntry{}
+			byIndex[index] = entry
+		}
+		switch strings.ToLower(match[1]) {
+		case "file":
+			entry.Path = match[3]
+		case "title":
+			entry.Title = match[3]
+		case "length":
+			entry.Length = match[3]
+		}
+	}
+
+	indexes := make([]int, 0, len(byIndex))
+	for index := range byIndex {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	var entries []playlistEntry
+	for _, index := range indexes {
+		if byIndex[index].Path != "" {
+			entries = append(entries, *byIndex[index])
+		}
+	}
+	return entries
+}
+
+func formatPLS(entries []playlistEntry, newline string) string {
+	var b strings.Builder
+	b.WriteString("[playlist]" + newline)
+	for i, entry := range entries {
+		n := i + 1
+		fmt.Fprintf(&b, "File%d=%s%s", n, entry.Path, newline)
+		if entry.Title != "" {
+			fmt.Fprintf(&b, "Title%d=%s%s", n, entry.Title, newline)
+		}
+		if entry.Length != "" {
+			fmt.Fprintf(&b, "Length%d=%s%s", n, entry.Length, newline)
+		}
+	}
+	fmt.Fprintf(&b, "NumberOfEntries=%d%s", len(entries), newline)
+	b.WriteString("Version=2" + newline)
+	return b.String()
+}
+
+func splitPlaylistLines(content string) []string {
+	return strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+}
+
+func isPlaylistURL(entry string) bool {
+	return strings.Contains(entry, "://") && !strings.HasPrefix(strings.ToLower(entry), "file://")
+}
+
+// resolvePlaylistEntry turns an entry into a path, accepting file:// URLs,
+// Windows separators, and paths relative to the playlist.
+func resolvePlaylistEntry(playlistPath, entry string) string {
+	if strings.HasPrefix(strings.ToLower(entry), "file://") {
+		if u, err := url.Parse(entry); err == nil {
+			entry = u.Path
+		}
+	}
+	if filepath.Separator == '/' {
+		entry = strings.ReplaceAll(entry, "\\", "/")
+	}
+	if !filepath.IsAbs(entry) {
+		entry = filepath.Join(filepath.Dir(playlistPath), entry)
+	}
+	return filepath.Clean(entry)
+}
+
+func playlistEntryPath(playlistOutputPath, target, style string) string {
+	if style == "absolute" {
+		if abs, err := filepath.Abs(target); err == nil {
+			return abs
+		}
+		return target
+	}
+	rel, err := filepath.Rel(filepath.Dir(playlistOutputPath), target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+func playlistKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return filepath.Clean(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
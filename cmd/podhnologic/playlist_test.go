@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConvertPlaylistsRewritesM3U(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	song := helper.WriteInputFile("Artist/Album/01 Song.flac", []byte("audio"))
+	helper.WriteInputFile("Playlists/mix.m3u8", []byte(strings.Join([]string{
+		"#EXTM3U",
+		"#EXTINF:180,Artist - Song",
+		`..\Artist\Album\01 Song.flac`,
+		"#EXTINF:200,Artist - Missing",
+		"../Artist/Album/02 Missing.flac",
+		"http://example.com/stream.mp3",
+	}, "\r\n")))
+
+	songOutput := filepath.Join(helper.outputDir, "Artist", "Album", "01 Song.m4a")
+	if err := os.MkdirAll(filepath.Dir(songOutput), 0755); err != nil {
+		t.Fatalf("failed to create output dir: %v", err)
+	}
+	if err := os.WriteFile(songOutput, []byte("converted"), 0644); err != nil {
+		t.Fatalf("failed to create output: %v", err)
+	}
+
+	config := Config{
+		InputDir:            helper.inputDir,
+		OutputDir:           helper.outputDir,
+		Codec:               "aac",
+		PlaylistLineEndings: "crlf",
+	}
+	jobs := []conversionJob{{InputPath: song, OutputPath: songOutput}}
+	if errs := convertPlaylists(jobs, nil, config, nil, false); len(errs) != 0 {
+		t.Fatalf("convertPlaylists errors: %v", errs)
+	}
+
+	data, err := os.ReadFile(filepath.Join(helper.outputDir, "Playlists", "mix.m3u8"))
+	if err != nil {
+		t.Fatalf("failed to read playlist: %v", err)
+	}
+	expected := strings.Join([]string{
+		"#EXTM3U",
+		"#EXTINF:180,Artist - Song",
+		filepath.Join("..", "Artist", "Album", "01 Song.m4a"),
+		"http://example.com/stream.mp3",
+		"",
+	}, "\r\n")
+	if string(data) != expected {
+		t.Fatalf("playlist content = %q, want %q", data, expected)
+	}
+}
+
+func TestConvertPlaylistsRewritesPLS(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	song := helper.WriteInputFile("song.flac", []byte("audio"))
+	helper.WriteInputFile("list.pls", []byte("[playlist]\nFile1=missing.flac\nTitle1=Missing\nFile2=song.flac\nTitle2=Song\nLength2=120\nNumberOfEntries=2\nVersion=2\n"))
+
+	songOutput := filepath.Join(helper.outputDir, "song.m4a")
+	if err := os.WriteFile(songOutput, []byte("converted"), 0644); err != nil {
+		t.Fatalf("failed to create output: %v", err)
+	}
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac", PlaylistPathStyle: "absolute"}
+	jobs := []conversionJob{{InputPath: song, OutputPath: songOutput}}
+	if errs := convertPlaylists(jobs, nil, config, nil, false); len(errs) != 0 {
+		t.Fatalf("convertPlaylists errors: %v", errs)
+	}
+
+	data, err := os.ReadFile(filepath.Join(helper.outputDir, "list.pls"))
+	if err != nil {
+		t.Fatalf("failed to read playlist: %v", err)
+	}
+	absOutput, _ := filepath.Abs(songOutput)
+	expected := "[playlist]\nFile1=" + absOutput + "\nTitle1=Song\nLength1=120\nNumberOfEntries=1\nVersion=2\n"
+	if string(data) != expected {
+		t.Fatalf("playlist content = %q, want %q", data, expected)
+	}
+}
+
+func TestConvertPlaylistsSkipsExcludedAndJunkEntries(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	outputDir := filepath.Join(helper.inputDir, "converted")
+	helper.WriteInputFile("mix.m3u", []byte("song.flac\n"))
+	helper.WriteInputFile("._mix.m3u", []byte("junk"))
+	helper.WriteInputFile("__MACOSX/mix.m3u", []byte("junk"))
+	helper.WriteInputFile("converted/old.m3u", []byte("song.m4a\n"))
+
+	config := Config{InputDir: helper.inputDir, OutputDir: outputDir, Codec: "aac"}
+	if errs := convertPlaylists(nil, nil, config, []string{outputDir}, false); len(errs) != 0 {
+		t.Fatalf("convertPlaylists errors: %v", errs)
+	}
+
+	if _, err := os.Stat(filepath.Join(outputDir, "mix.m3u")); err != nil {
+		t.Errorf("playlist was not converted: %v", err)
+	}
+	for _, name := range []string{"._mix.m3u", "__MACOSX", filepath.Join("converted", "old.m3u")} {
+		if _, err := os.Stat(filepath.Join(outputDir, name)); err == nil {
+			t.Errorf("%s was converted", name)
+		}
+	}
+}
+
+func TestIsPlaylistFile(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"mix.m3u":  true,
+		"mix.M3U8": true,
+		"mix.pls":  true,
+		"mix.flac": false,
+		"mix.txt":  false,
+	} {
+		if got := isPlaylistFile(path); got != expected {
+			t.Errorf("isPlaylistFile(%q) = %v, want %v", path, got, expected)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultProfile = "default"
+
+// configVersion is the schema version written to config.json. Version 1 is
+// the original flat layout; version 2 introduced named profiles.
+const configVersion = 2
+
+var errConfigTooNew = errors.New("config file was written by a newer release")
+
+// configFile is the on-disk layout of config.json: named profiles and the
+// one used when --profile is not given.
+type configFile struct {
+	Version       int               `json:"version"`
+	ActiveProfile string            `json:"active_profile"`
+	Profiles      map[string]Config `json:"profiles"`
+}
+
+func newConfigFile() configFile {
+	return configFile{
+		Version:       configVersion,
+		ActiveProfile: defaultProfile,
+		Profiles:      map[string]Config{defaultProfile: {}},
+	}
+}
+
+// loadConfigFile reads config.json. A flat config from older versions becomes
+// the default profile; a missing or unreadable file yields an empty default.
+func loadConfigFile(configDir string) configFile {
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		// Config doesn't exist yet
+		return newConfigFile()
+	}
+
+	file, err := parseConfigFile(data)
+	if err != nil {
+		log.Printf("Warning: Failed to parse config file: %v", err)
+		return newConfigFile()
+	}
+	return file
+}
+
+// loadConfigFileForUpdate is loadConfigFile for callers that write the file
+// back. It refuses to overwrite a file it could not read or parse, or one
+// from a newer release, so saved profiles are never lost.
+func loadConfigFileForUpdate(configDir string) (configFile, error) {
+	path := filepath.Join(configDir, "config.json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return newConfigFile(), nil
+	}
+	if err != nil {
+		return configFile{}, fmt.Errorf("read config file: %w", err)
+	}
+
+	file, err := parseConfigFile(data)
+	if errors.Is(err, errConfigTooNew) {
+		return configFile{}, err
+	}
+	if err != nil {
+		return configFile{}, fmt.Errorf("parse config file %s: %w; fix or remove it before saving settings", path, err)
+	}
+	return file, nil
+}
+
+func parseConfigFile(data []byte) (configFile, error) {
+	var file configFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return configFile{}, err
+	}
+	if file.Version > configVersion {
+		return configFile{}, fmt.Errorf("%w: version %d, this release supports %d", errConfigTooNew, file.Version, configVersion)
+	}
+
+	if file.Profiles == nil {
+		// Migrate the flat version 1 layout
+		var config Config
+		if err := json.Unmarshal(data, &config); err != nil {
+			return configFile{}, err
+		}
+		file.Profiles = map[string]Config{defaultProfile: config}
+	}
+	file.Version = configVersion
+	if file.ActiveProfile == "" {
+		file.ActiveProfile = defaultProfile
+	}
+	if _, ok := file.Profiles[file.ActiveProfile]; !ok {
+		file.Profiles[file.ActiveProfile] = Config{}
+	}
+	return file, nil
+}
+
+func saveConfigFile(configDir string, file configFile) error {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(configDir, "config.json"), data, 0644)
+}
+
+// profile returns the named profile, or the active one when name is empty.
+func (f configFile) profile(name string) (Config, bool) {
+	if name == "" {
+		name = f.ActiveProfile
+	}
+	config, ok := f.Profiles[name]
+	return config, ok
+}
+
+func (f configFile) profileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// saveProfile stores config under name, or under the active profile when name
+// is empty, leaving the other profiles untouched.
+func saveProfile(configDir, name string, config Config) error {
+	file, err := loadConfigFileForUpdate(configDir)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = file.ActiveProfile
+	}
+	file.Profiles[name] = config
+	return saveConfigFile(configDir, file)
+}
+
+func validateProfileName(name string, file configFile) error {
+	if strings.TrimSpace(name) == "" {
+		return fmt.Errorf("profile name cannot be empty")
+	}
+	if _, ok := file.Profiles[name]; ok {
+		return fmt.Errorf("profile %q already exists", name)
+	}
+	return nil
+}
+
+// switchProfile makes name the active profile and returns its settings.
+func switchProfile(configDir, name string) (Config, error) {
+	file, err := loadConfigFileForUpdate(configDir)
+	if err != nil {
+		return Config{}, err
+	}
+	config, ok := file.Profiles[name]
+	if !ok {
+		return Config{}, fmt.Errorf("unknown profile %q (configured: %s)", name, strings.Join(file.profileNames(), ", "))
+	}
+	file.ActiveProfile = name
+	return config, saveConfigFile(configDir, file)
+}
+
+// createProfile adds a profile seeded from config and makes it active.
+func createProfile(configDir, name string, config Config) error {
+	file, err := loadConfigFileForUpdate(configDir)
+	if err != nil {
+		return err
+	}
+	name = strings.TrimSpace(name)
+	if err := validateProfileName(name, file); err != nil {
+		return err
+	}
+	file.Profiles[name] = config
+	file.ActiveProfile = name
+	return saveConfigFile(configDir, file)
+}
+
+func renameProfile(configDir, from, to string) error {
+	file, err := loadConfigFileForUpdate(configDir)
+	if err != nil {
+		return err
+	}
+	to = strings.TrimSpace(to)
+	config, ok := file.Profiles[from]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", from)
+	}
+	if err := validateProfileName(to, file); err != nil {
+		return err
+	}
+	delete(file.Profiles, from)
+	file.Profiles[to] = config
+	if file.ActiveProfile == from {
+		file.ActiveProfile = to
+	}
+	return saveConfigFile(configDir, file)
+}
+
+// deleteProfile removes a profile. When it was active, the first remaining
+// profile becomes active and its name is returned.
+func deleteProfile(configDir, name string) (string, error) {
+	file, err := loadConfigFileForUpdate(configDir)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := file.Profiles[name]; !ok {
+		return "", fmt.Errorf("unknown profile %q", name)
+	}
+	if len(file.Profiles) == 1 {
+		return "", fmt.Errorf("cannot delete the only profile")
+	}
+	delete(file.Profiles, name)
+	if file.ActiveProfile == name {
+		file.ActiveProfile = file.profileNames()[0]
+	}
+	return file.ActiveProfile, saveConfigFile(configDir, file)
+}
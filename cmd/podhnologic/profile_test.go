@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfigFileMigratesFlatConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	flat := `{"input_dir": "/music", "output_dir": "/ipod", "codec": "aac", "ipod": true}`
+	if err := os.WriteFile(filepath.Join(tempDir, "config.json"), []byte(flat), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	file := loadConfigFile(tempDir)
+	if file.ActiveProfile != defaultProfile {
+		t.Errorf("ActiveProfile = %q, want %q", file.ActiveProfile, defaultProfile)
+	}
+	config, ok := file.profile("")
+	if !ok || config.InputDir != "/music" || config.OutputDir != "/ipod" || !config.IPod {
+		t.Errorf("migrated profile = %+v", config)
+	}
+
+	// Saving writes the profile layout and keeps the settings
+	if err := saveConfig(tempDir, config); err != nil {
+		t.Fatalf("saveConfig error: %v", err)
+	}
+	if got := loadConfigFile(tempDir).Profiles[defaultProfile]; got.OutputDir != "/ipod" {
+		t.Errorf("saved default profile = %+v", got)
+	}
+}
+
+func TestSaveProfileKeepsOtherProfiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	if err := saveConfig(tempDir, Config{Codec: "aac"}); err != nil {
+		t.Fatalf("saveConfig error: %v", err)
+	}
+	if err := saveProfile(tempDir, "car", Config{Codec: "mp3"}); err != nil {
+		t.Fatalf("saveProfile error: %v", err)
+	}
+
+	file := loadConfigFile(tempDir)
+	if names := file.profileNames(); len(names) != 2 || names[0] != "car" || names[1] != defaultProfile {
+		t.Fatalf("profiles = %v", names)
+	}
+	if loadConfig(tempDir).Codec != "aac" {
+		t.Errorf("active profile changed: %+v", loadConfig(tempDir))
+	}
+}
+
+func TestSaveProfileKeepsAnUnparsableConfig(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "config.json")
+	broken := []byte(`{"profiles": {"default": {"codec": "aac"}, `)
+	if err := os.WriteFile(path, broken, 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if err := saveProfile(tempDir, "", Config{Codec: "mp3"}); err == nil {
+		t.Fatal("saveProfile overwrote a config file it could not parse")
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(broken) {
+		t.Errorf("config file = %s, want it untouched", data)
+	}
+}
+
+func TestProfileLifecycle(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := saveConfig(tempDir, Config{Codec: "aac", IPod: true}); err != nil {
+		t.Fatalf("saveConfig error: %v", err)
+	}
+
+	if err := createProfile(tempDir, "car", Config{Codec: "mp3"}); err != nil {
+		t.Fatalf("createProfile error: %v", err)
+	}
+	if err := createProfile(tempDir, "car", Config{}); err == nil {
+		t.Error("createProfile accepted a duplicate name")
+	}
+	if loadConfig(tempDir).Codec != "mp3" {
+		t.Errorf("new profile is not active: %+v", loadConfig(tempDir))
+	}
+
+	if err := renameProfile(tempDir, "car", "usb stick"); err != nil {
+		t.Fatalf("renameProfile error: %v", err)
+	}
+	if file := loadConfigFile(tempDir); file.ActiveProfile != "usb stick" {
+		t.Errorf("ActiveProfile = %q after rename", file.ActiveProfile)
+	}
+
+	config, err := switchProfile(tempDir, defaultProfile)
+	if err != nil || !config.IPod {
+		t.Fatalf("switchProfile = %+v, %v", config, err)
+	}
+	if _, err := switchProfile(tempDir, "missing"); err == nil {
+		t.Error("switchProfile accepted an unknown profile")
+	}
+
+	next, err := deleteProfile(tempDir, defaultProfile)
+	if err != nil || next != "usb stick" {
+		t.Fatalf("deleteProfile = %q, %v", next, err)
+	}
+	if _, err := deleteProfile(tempDir, "usb stick"); err == nil {
+		t.Error("deleteProfile removed the only profile")
+	}
+}
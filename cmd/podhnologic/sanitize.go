@@ -0,0 +1,282 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// filesystemProfile describes the naming rules of the volume outputs are written to.
+type filesystemProfile struct {
+	Name            string
+	InvalidChars    string
+	TrimTrailing    bool
+	ReservedNames   bool
+	CaseInsensitive bool
+	UTF16Lengths    bool
+	MaxComponent    int
+	MaxPath         int
+
+	// Root is the output directory below the mount point of its volume.
+	// Path limits count from the volume root, so it takes up part of MaxPath.
+	Root string
+}
+
+var filesystemProfiles = map[string]filesystemProfile{
+	"posix": {
+		Name:         "posix",
+		MaxComponent: 255,
+		MaxPath:      4095,
+	},
+	"fat32": {
+		Name:            "fat32",
+		InvalidChars:    `<>:"\|?*`,
+		TrimTrailing:    true,
+		ReservedNames:   true,
+		CaseInsensitive: true,
+		UTF16Lengths:    true,
+		MaxComponent:    255,
+		MaxPath:         255,
+	},
+	// exFAT itself has no practical path limit, but Windows refuses paths
+	// past MAX_PATH on it, as on NTFS.
+	"exfat": {
+		Name:            "exfat",
+		InvalidChars:    `<>:"\|?*`,
+		TrimTrailing:    true,
+		ReservedNames:   true,
+		CaseInsensitive: true,
+		UTF16Lengths:    true,
+		MaxComponent:    255,
+		MaxPath:         259,
+	},
+	"ntfs": {
+		Name:            "ntfs",
+		InvalidChars:    `<>:"\|?*`,
+		TrimTrailing:    true,
+		ReservedNames:   true,
+		CaseInsensitive: true,
+		UTF16Lengths:    true,
+		MaxComponent:    255,
+		MaxPath:         259,
+	},
+}
+
+var filesystemNames = []string{"posix", "fat32", "exfat", "ntfs"}
+
+func lookupFilesystemProfile(name string) (filesystemProfile, error) {
+	profile, ok := filesystemProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return filesystemProfile{}, fmt.Errorf("unsupported filesystem %q (want %s)", name, strings.Join(filesystemNames, ", "))
+	}
+	return profile, nil
+}
+
+// targetFilesystem resolves the configured profile, posix when none is set.
+// iPod mode does not pick fat32 by itself, since that would rename outputs
+// converted before profiles existed.
+func targetFilesystem(config Config) filesystemProfile {
+	profile := namedFilesystem(config)
+	if config.OutputDir != "" {
+		profile.Root = outputVolumeRoot(config.OutputDir)
+	}
+	return profile
+}
+
+func namedFilesystem(config Config) filesystemProfile {
+	name := config.Filesystem
+	if name == "" {
+		name = "posix"
+	}
+	profile, err := lookupFilesystemProfile(name)
+	if err != nil {
+		return filesystemProfiles["posix"]
+	}
+	return profile
+}
+
+var volumeRoots sync.Map
+
+// outputVolumeRoot returns dir below its mount point, looked up once per
+// directory.
+func outputVolumeRoot(dir string) string {
+	if root, ok := volumeRoots.Load(dir); ok {
+		return root.(string)
+	}
+	root := volumeRelativeDir(dir)
+	volumeRoots.Store(dir, root)
+	return root
+}
+
+var reservedWindowsNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// minFileNameRoom is how much of the path limit shortening directories
+// leaves for file names.
+const minFileNameRoom = 64
+
+// sanitizeOutputPath rewrites a relative output path so every component is
+// valid on the profile's filesystem and the whole path, below the profile's
+// root, fits its length limits. Directories are shortened from their own path
+// alone, so every file in a directory lands in the same one, and the file name
+// takes what room is left.
+// Truncated components get a short hash of the original name so distinct long
+// names stay distinct.
+func sanitizeOutputPath(relPath string, profile filesystemProfile) string {
+	dir, file := path.Split(filepath.ToSlash(relPath))
+	components := append([]string(nil), sanitizedOutputDir(strings.TrimSuffix(dir, "/"), profile)...)
+	name := sanitizePathComponent(file, profile, true)
+
+	if profile.MaxPath > 0 {
+		current := profile.length(name)
+		limit := current - (profile.pathLength(append(components, name)) - profile.MaxPath)
+		if limit < 16 {
+			limit = 16
+		}
+		if limit < current {
+			name = truncatePathComponent(name, limit, profile, true)
+		}
+	}
+
+	return filepath.Join(append(components, name)...)
+}
+
+var sanitizedDirs sync.Map
+
+// sanitizedOutputDir sanitizes the directories of an output path, shortening
+// the longest until they leave minFileNameRoom for the file name. Each
+// directory is worked out once per profile and root.
+func sanitizedOutputDir(dir string, profile filesystemProfile) []string {
+	if dir == "" {
+		return nil
+	}
+	key := profile.Name + "\x00" + profile.Root + "\x00" + dir
+	if components, ok := sanitizedDirs.Load(key); ok {
+		return components.([]string)
+	}
+
+	components := strings.Split(dir, "/")
+	for i, component := range components {
+		components[i] = sanitizePathComponent(component, profile, false)
+	}
+
+	for profile.MaxPath > 0 {
+		excess := profile.pathLength(components) + 1 + minFileNameRoom - profile.MaxPath
+		if excess <= 0 {
+			break
+		}
+
+		longest := 0
+		for i := range components {
+			if profile.length(components[i]) > profile.length(components[longest]) {
+				longest = i
+			}
+		}
+		current := profile.length(components[longest])
+		limit := current - excess
+		if limit < 16 {
+			limit = 16
+		}
+		if limit >= current {
+			break
+		}
+		components[longest] = truncatePathComponent(components[longest], limit, profile, false)
+	}
+
+	sanitizedDirs.Store(key, components)
+	return components
+}
+
+func sanitizePathComponent(component string, profile filesystemProfile, isFile bool) string {
+	original := component
+
+	var b strings.Builder
+	for _, r := range component {
+		switch {
+		case r == 0 || r == '/':
+			b.WriteRune('_')
+		case profile.InvalidChars != "" && (r < 0x20 || strings.ContainsRune(profile.InvalidChars, r)):
+			b.WriteRune('_')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	component = b.String()
+
+	if profile.TrimTrailing {
+		component = strings.TrimRight(component, ". ")
+	}
+	if profile.ReservedNames {
+		base := strings.ToUpper(strings.SplitN(component, ".", 2)[0])
+		if reservedWindowsNames[strings.TrimSpace(base)] {
+			component = "_" + component
+		}
+	}
+	if component == "" || component == "." || component == ".." {
+		component = "_"
+	}
+
+	if profile.MaxComponent > 0 && profile.length(component) > profile.MaxComponent {
+		component = truncatePathComponentFrom(component, original, profile.MaxComponent, profile, isFile)
+	}
+
+	return component
+}
+
+func truncatePathComponent(component string, limit int, profile filesystemProfile, isFile bool) string {
+	return truncatePathComponentFrom(component, component, limit, profile, isFile)
+}
+
+// truncatePathComponentFrom shortens component to limit, keeping a file's
+// extension and appending a hash of original.
+func truncatePathComponentFrom(component, original string, limit int, profile filesystemProfile, isFile bool) string {
+	ext := ""
+	if isFile {
+		ext = filepath.Ext(component)
+		if profile.length(ext) > limit/2 {
+			ext = ""
+		}
+	}
+	stem := strings.TrimSuffix(component, ext)
+
+	sum := sha1.Sum([]byte(original))
+	suffix := "~" + hex.EncodeToString(sum[:])[:6]
+
+	budget := limit - profile.length(ext) - profile.length(suffix)
+	for profile.length(stem) > budget && stem != "" {
+		_, size := utf8.DecodeLastRuneInString(stem)
+		stem = stem[:len(stem)-size]
+	}
+	if profile.TrimTrailing {
+		stem = strings.TrimRight(stem, ". ")
+	}
+
+	return stem + suffix + ext
+}
+
+func (p filesystemProfile) length(s string) int {
+	if p.UTF16Lengths {
+		return len(utf16.Encode([]rune(s)))
+	}
+	return len(s)
+}
+
+func (p filesystemProfile) pathLength(components []string) int {
+	total := len(components) - 1
+	if p.Root != "" {
+		total += p.length(p.Root) + 1
+	}
+	for _, component := range components {
+		total += p.length(component)
+	}
+	return total
+}
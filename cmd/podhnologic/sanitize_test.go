@@ -0,0 +1,132 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestSanitizeOutputPath(t *testing.T) {
+	fat32 := filesystemProfiles["fat32"]
+	posix := filesystemProfiles["posix"]
+
+	tests := []struct {
+		name     string
+		relPath  string
+		profile  filesystemProfile
+		expected string
+	}{
+		{
+			name:     "fat32 invalid characters",
+			relPath:  `AC:DC/What? "Live" <1979>*|.m4a`,
+			profile:  fat32,
+			expected: filepath.Join("AC_DC", `What_ _Live_ _1979___.m4a`),
+		},
+		{
+			name:     "fat32 trailing dots and spaces",
+			relPath:  "Artist. /Album.../song.m4a",
+			profile:  fat32,
+			expected: filepath.Join("Artist", "Album", "song.m4a"),
+		},
+		{
+			name:     "fat32 reserved names",
+			relPath:  "CON/aux.m4a",
+			profile:  fat32,
+			expected: filepath.Join("_CON", "_aux.m4a"),
+		},
+		{
+			name:     "posix keeps punctuation",
+			relPath:  `AC:DC/What?.m4a`,
+			profile:  posix,
+			expected: filepath.Join("AC:DC", "What?.m4a"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeOutputPath(tt.relPath, tt.profile); got != tt.expected {
+				t.Errorf("sanitizeOutputPath(%q) = %q, want %q", tt.relPath, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSanitizeOutputPathTruncatesLongNames(t *testing.T) {
+	fat32 := filesystemProfiles["fat32"]
+
+	long := strings.Repeat("ü", 300)
+	first := sanitizeOutputPath("Album/"+long+"a.m4a", fat32)
+	second := sanitizeOutputPath("Album/"+long+"b.m4a", fat32)
+
+	if first == second {
+		t.Fatalf("truncated names collide: %q", first)
+	}
+	for _, path := range []string{first, second} {
+		name := filepath.Base(path)
+		if n := len(utf16.Encode([]rune(name))); n > fat32.MaxComponent {
+			t.Errorf("component has %d UTF-16 units, want <= %d", n, fat32.MaxComponent)
+		}
+		if n := len(utf16.Encode([]rune(filepath.ToSlash(path)))); n > fat32.MaxPath {
+			t.Errorf("path has %d UTF-16 units, want <= %d", n, fat32.MaxPath)
+		}
+		if filepath.Ext(name) != ".m4a" {
+			t.Errorf("truncated name lost its extension: %q", name)
+		}
+	}
+}
+
+func TestSanitizeOutputPathCountsTheOutputRoot(t *testing.T) {
+	fat32 := filesystemProfiles["fat32"]
+	fat32.Root = "Music/" + strings.Repeat("r", 150)
+
+	path := sanitizeOutputPath("Album/"+strings.Repeat("n", 150)+".m4a", fat32)
+
+	full := fat32.Root + "/" + filepath.ToSlash(path)
+	if n := len(utf16.Encode([]rune(full))); n > fat32.MaxPath {
+		t.Errorf("path from the volume root has %d UTF-16 units, want <= %d", n, fat32.MaxPath)
+	}
+	if filepath.Ext(path) != ".m4a" {
+		t.Errorf("truncated name lost its extension: %q", path)
+	}
+}
+
+func TestSanitizeOutputPathKeepsAnAlbumTogether(t *testing.T) {
+	fat32 := filesystemProfiles["fat32"]
+	album := strings.Repeat("a", 120) + "/" + strings.Repeat("b", 100)
+
+	short := sanitizeOutputPath(album+"/01 Intro.m4a", fat32)
+	long := sanitizeOutputPath(album+"/02 "+strings.Repeat("c", 100)+".m4a", fat32)
+
+	if filepath.Dir(short) != filepath.Dir(long) {
+		t.Errorf("tracks of one album went to %q and %q", filepath.Dir(short), filepath.Dir(long))
+	}
+	if n := len(utf16.Encode([]rune(filepath.ToSlash(long)))); n > fat32.MaxPath {
+		t.Errorf("path has %d UTF-16 units, want <= %d", n, fat32.MaxPath)
+	}
+}
+
+func TestVolumeRelativeDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "not", "yet")
+
+	root := volumeRelativeDir(dir)
+	if !strings.HasSuffix(root, "not/yet") || strings.HasPrefix(root, "/") {
+		t.Errorf("volumeRelativeDir(%q) = %q, want a relative path ending in not/yet", dir, root)
+	}
+}
+
+func TestTargetFilesystemDefaults(t *testing.T) {
+	if got := targetFilesystem(Config{}).Name; got != "posix" {
+		t.Errorf("default filesystem = %q, want posix", got)
+	}
+	// fat32 rules would rename outputs of earlier runs, so they are opt-in
+	if got := targetFilesystem(Config{IPod: true}).Name; got != "posix" {
+		t.Errorf("iPod filesystem = %q, want posix", got)
+	}
+	if got := targetFilesystem(Config{IPod: true, Filesystem: "NTFS"}).Name; got != "ntfs" {
+		t.Errorf("explicit filesystem = %q, want ntfs", got)
+	}
+	if _, err := lookupFilesystemProfile("hfs"); err == nil {
+		t.Error("lookupFilesystemProfile accepted an unknown filesystem")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// scanOptions controls how the input library is walked.
+type scanOptions struct {
+	FollowSymlinks bool
+	SkipHidden     bool
+	// ProbeCandidates lists every possible media file, not only known audio
+	// extensions, for content detection to decide on.
+	ProbeCandidates bool
+	// Video adds video container extensions to discovery.
+	Video bool
+	// Playlists lists playlist files instead of media.
+	Playlists bool
+	Exclude   []string
+}
+
+func scanOptionsFor(config Config, exclude []string) scanOptions {
+	return scanOptions{
+		FollowSymlinks:  config.FollowSymlinks,
+		SkipHidden:      config.SkipHidden,
+		ProbeCandidates: config.ProbeDetection,
+		Video:           config.VideoInput,
+		Exclude:         exclude,
+	}
+}
+
+type libraryScan struct {
+	options  scanOptions
+	excluded []os.FileInfo
+	visited  map[string]bool
+	files    []string
+	warnings []error
+}
+
+// scanAudioFiles lists the audio files under rootDir in lexical order.
+// Symlinked folders are followed only when asked, and each real directory is
+// visited once so symlink loops end. Unreadable directories and broken links
+// are skipped and returned as warnings; only an unreadable root is an error.
+func scanAudioFiles(rootDir string, options scanOptions) ([]string, []error, error) {
+	if _, err := os.Stat(rootDir); err != nil {
+		return nil, nil, err
+	}
+	realRoot, err := filepath.EvalSymlinks(rootDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scan := &libraryScan{
+		options: options,
+		visited: make(map[string]bool),
+	}
+	for _, dir := range options.Exclude {
+		if info, err := os.Stat(dir); err == nil {
+			scan.excluded = append(scan.excluded, info)
+		}
+	}
+
+	scan.walk(rootDir, realRoot)
+	return scan.files, scan.warnings, nil
+}
+
+func (s *libraryScan) walk(dir, realDir string) {
+	if s.visited[realDir] {
+		s.warnings = append(s.warnings, fmt.Errorf("skipping %s: already scanned as %s (symlink loop or duplicate link)", dir, realDir))
+		return
+	}
+	s.visited[realDir] = true
+
+	// ReadDir returns what it could read before failing, so keep going with that
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		s.warnings = append(s.warnings, fmt.Errorf("skipping unreadable directory %s: %w", dir, err))
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		if skipLibraryEntry(name, s.options) {
+			continue
+		}
+
+		if entry.Type()&fs.ModeSymlink != 0 {
+			s.walkSymlink(path)
+			continue
+		}
+
+		if entry.IsDir() {
+			if !s.isExcluded(path) {
+				s.walk(path, filepath.Join(realDir, name))
+			}
+			continue
+		}
+
+		if s.wants(name) {
+			s.files = append(s.files, path)
+		}
+	}
+}
+
+func (s *libraryScan) wants(name string) bool {
+	if s.options.Playlists {
+		return isPlaylistFile(name)
+	}
+	if s.options.ProbeCandidates {
+		return isProbeCandidate(name)
+	}
+	return isAudioFile(name) || (s.options.Video && isVideoFile(name))
+}
+
+func (s *libraryScan) walkSymlink(path string) {
+	target, err := os.Stat(path)
+	if err != nil {
+		s.warnings = append(s.warnings, fmt.Errorf("skipping broken symlink %s: %w", path, err))
+		return
+	}
+
+	if !target.IsDir() {
+		if s.wants(path) {
+			s.files = append(s.files, path)
+		}
+		return
+	}
+
+	if !s.options.FollowSymlinks || s.isExcluded(path) {
+		return
+	}
+	realDir, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		s.warnings = append(s.warnings, fmt.Errorf("skipping symlink %s: %w", path, err))
+		return
+	}
+	s.walk(path, realDir)
+}
+
+func (s *libraryScan) isExcluded(dir string) bool {
+	if len(s.excluded) == 0 {
+		return false
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	for _, skip := range s.excluded {
+		if os.SameFile(info, skip) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipLibraryEntry filters out files that are never music: macOS AppleDouble
+// resource forks and archive leftovers always, hidden entries when asked.
+func skipLibraryEntry(name string, options scanOptions) bool {
+	if strings.HasPrefix(name, "._") || name == "__MACOSX" {
+		return true
+	}
+	return options.SkipHidden && strings.HasPrefix(name, ".")
+}
+
+func reportScanWarnings(warnings []error) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Printf("⚠ %d problems while scanning:\n", len(warnings))
+	for _, warning := range warnings {
+		fmt.Printf("  %v\n", warning)
+	}
+	fmt.Println()
+}
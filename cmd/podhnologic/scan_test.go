@@ -0,0 +1,151 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func scannedNames(t *testing.T, root string, files []string) []string {
+	t.Helper()
+	var names []string
+	for _, file := range files {
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			t.Fatalf("failed to relativize %s: %v", file, err)
+		}
+		names = append(names, filepath.ToSlash(rel))
+	}
+	return names
+}
+
+func TestScanAudioFilesSkipsJunk(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	for _, name := range []string{
+		"Album/01.flac",
+		"Album/._01.flac",
+		"Album/.hidden.flac",
+		".Trashes/old.mp3",
+		"__MACOSX/Album/02.flac",
+	} {
+		helper.WriteInputFile(name, []byte("audio"))
+	}
+
+	files, warnings, err := scanAudioFiles(helper.inputDir, scanOptions{})
+	if err != nil || len(warnings) != 0 {
+		t.Fatalf("scanAudioFiles error = %v, warnings = %v", err, warnings)
+	}
+	if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != ".Trashes/old.mp3,Album/.hidden.flac,Album/01.flac" {
+		t.Errorf("default scan = %s, want hidden files but no junk", got)
+	}
+
+	files, _, _ = scanAudioFiles(helper.inputDir, scanOptions{SkipHidden: true})
+	if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != "Album/01.flac" {
+		t.Errorf("scan skipping hidden files = %s, want Album/01.flac", got)
+	}
+}
+
+func TestScanAudioFilesFollowsSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need extra privileges on Windows")
+	}
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	elsewhere := filepath.Join(helper.tempDir, "elsewhere")
+	if err := os.MkdirAll(filepath.Join(elsewhere, "Album"), 0755); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(elsewhere, "Album", "song.flac"), []byte("audio"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	helper.WriteInputFile("local.flac", []byte("audio"))
+	links := []struct{ link, target string }{
+		{filepath.Join(helper.inputDir, "Linked"), elsewhere},
+		{filepath.Join(elsewhere, "Loop"), helper.inputDir},
+		{filepath.Join(elsewhere, "Album2"), filepath.Join(elsewhere, "Album")},
+		{filepath.Join(helper.inputDir, "broken.flac"), filepath.Join(helper.tempDir, "missing.flac")},
+	}
+	for _, l := range links {
+		if err := os.Symlink(l.target, l.link); err != nil {
+			t.Fatalf("failed to create symlink %s: %v", l.link, err)
+		}
+	}
+
+	files, warnings, err := scanAudioFiles(helper.inputDir, scanOptions{})
+	if err != nil {
+		t.Fatalf("scanAudioFiles error: %v", err)
+	}
+	if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != "local.flac" {
+		t.Errorf("scan without following = %s, want local.flac", got)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "broken symlink") {
+		t.Errorf("warnings = %v, want the broken symlink", warnings)
+	}
+
+	files, warnings, err = scanAudioFiles(helper.inputDir, scanOptions{FollowSymlinks: true})
+	if err != nil {
+		t.Fatalf("scanAudioFiles error: %v", err)
+	}
+	if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != "Linked/Album/song.flac,local.flac" {
+		t.Errorf("scan following symlinks = %s", got)
+	}
+	// The broken link, the loop back to the input and the second link to Album
+	if len(warnings) != 3 {
+		t.Errorf("warnings = %v, want 3", warnings)
+	}
+}
+
+func TestScanAudioFilesContinuesPastUnreadableDirectories(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("needs POSIX permissions enforced for the current user")
+	}
+	helper := NewTestHelper(t)
+	helper.Setup()
+	helper.WriteInputFile("A/song.flac", []byte("audio"))
+	helper.WriteInputFile("B/locked.flac", []byte("audio"))
+	helper.WriteInputFile("C/song.flac", []byte("audio"))
+
+	locked := filepath.Join(helper.inputDir, "B")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	files, warnings, err := scanAudioFiles(helper.inputDir, scanOptions{})
+	if err != nil {
+		t.Fatalf("scanAudioFiles error: %v", err)
+	}
+	if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != "A/song.flac,C/song.flac" {
+		t.Errorf("scan = %s", got)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), "unreadable directory") {
+		t.Errorf("warnings = %v", warnings)
+	}
+}
+
+func TestScanAudioFilesVideo(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	helper.WriteInputFile("Live/01.flac", []byte("audio"))
+	helper.WriteInputFile("Live/concert.mkv", []byte("video"))
+
+	for _, tt := range []struct {
+		video bool
+		want  string
+	}{
+		{false, "Live/01.flac"},
+		{true, "Live/01.flac,Live/concert.mkv"},
+	} {
+		files, _, err := scanAudioFiles(helper.inputDir, scanOptions{Video: tt.video})
+		if err != nil {
+			t.Fatalf("scanAudioFiles error = %v", err)
+		}
+		if got := strings.Join(scannedNames(t, helper.inputDir, files), ","); got != tt.want {
+			t.Errorf("Video=%v scanned %s, want %s", tt.video, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Language returns the stream's language tag, if any.
+func (s MetadataStream) Language() string {
+	for key, value := range s.Tags {
+		if strings.EqualFold(key, "language") {
+			return value
+		}
+	}
+	return ""
+}
+
+// IsAttachedPicture reports whether the stream is embedded cover art rather
+// than a real video track.
+func (s MetadataStream) IsAttachedPicture() bool {
+	return s.Disposition["attached_pic"] == 1
+}
+
+func languageMatches(streamLanguage, wanted string) bool {
+	streamLanguage = strings.ToLower(strings.TrimSpace(streamLanguage))
+	wanted = strings.ToLower(strings.TrimSpace(wanted))
+	if streamLanguage == "" || wanted == "" {
+		return false
+	}
+	// Accept two-letter codes for three-letter tags, e.g. "en" for "eng"
+	return streamLanguage == wanted || (len(wanted) == 2 && strings.HasPrefix(streamLanguage, wanted))
+}
+
+// selectAudioStream picks the primary audio stream. Streams in the first
+// preferred language that has any are considered, otherwise all of them;
+// among those the most channels win, then the default stream, then the
+// lowest index.
+func selectAudioStream(streams []MetadataStream, languages []string) (MetadataStream, bool) {
+	var audio []MetadataStream
+	for _, stream := range streams {
+		if stream.CodecType == "audio" {
+			audio = append(audio, stream)
+		}
+	}
+	if len(audio) == 0 {
+		return MetadataStream{}, false
+	}
+
+	candidates := audio
+	for _, language := range languages {
+		var matching []MetadataStream
+		for _, stream := range audio {
+			if languageMatches(stream.Language(), language) {
+				matching = append(matching, stream)
+			}
+		}
+		if len(matching) > 0 {
+			candidates = matching
+			break
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Channels != b.Channels {
+			return a.Channels > b.Channels
+		}
+		if a.Disposition["default"] != b.Disposition["default"] {
+			return a.Disposition["default"] > b.Disposition["default"]
+		}
+		return a.Index < b.Index
+	})
+	return candidates[0], true
+}
+
+// selectVideoStream returns the first real video track, ignoring cover art.
+func selectVideoStream(streams []MetadataStream) (MetadataStream, bool) {
+	for _, stream := range streams {
+		if stream.CodecType == "video" && !stream.IsAttachedPicture() {
+			return stream, true
+		}
+	}
+	return MetadataStream{}, false
+}
+
+// selectCoverStream picks the first attached picture, the embedded cover art.
+func selectCoverStream(streams []MetadataStream) (MetadataStream, bool) {
+	for _, stream := range streams {
+		if stream.CodecType == "video" && stream.IsAttachedPicture() {
+			return stream, true
+		}
+	}
+	return MetadataStream{}, false
+}
+
+// streamByIndex returns the audio stream with the given index.
+func streamByIndex(streams []MetadataStream, index int) (MetadataStream, bool) {
+	for _, stream := range streams {
+		if stream.Index == index && stream.CodecType == "audio" {
+			return stream, true
+		}
+	}
+	return MetadataStream{}, false
+}
+
+// streamMapArgs maps one primary audio stream and at most one picture,
+// dropping video tracks, subtitles and data streams. The audio stream is the
+// configured index when the source has such an audio stream, otherwise the
+// best match for the language preference. The picture is the embedded cover,
+// or with video input a thumbnail of the video track, which is reported so the
+// caller can encode it. Without probed streams everything is mapped.
+func streamMapArgs(config Config, metadata *Metadata) ([]string, bool) {
+	if metadata == nil {
+		return []string{"-map", "0"}, false
+	}
+
+	audio, ok := MetadataStream{}, false
+	if config.AudioStream != nil {
+		audio, ok = streamByIndex(metadata.Streams, *config.AudioStream)
+	}
+	if !ok {
+		audio, ok = selectAudioStream(metadata.Streams, config.AudioLanguages)
+	}
+	if !ok {
+		return []string{"-map", "0"}, false
+	}
+
+	args := []string{"-map", fmt.Sprintf("0:%d", audio.Index)}
+	if !keepsCoverArt(effectiveCodec(config)) {
+		return args, false
+	}
+	if cover, ok := selectCoverStream(metadata.Streams); ok {
+		return append(args, "-map", fmt.Sprintf("0:%d", cover.Index)), false
+	}
+	if config.VideoInput {
+		if video, ok := selectVideoStream(metadata.Streams); ok {
+			return append(args, "-map", fmt.Sprintf("0:%d", video.Index)), true
+		}
+	}
+	return args, false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectAudioStream(t *testing.T) {
+	streams := []MetadataStream{
+		{Index: 0, CodecType: "video", CodecName: "h264"},
+		{Index: 1, CodecType: "audio", Channels: 2, Tags: map[string]string{"language": "jpn"}, Disposition: map[string]int{"default": 1}},
+		{Index: 2, CodecType: "audio", Channels: 6, Tags: map[string]string{"language": "jpn"}},
+		{Index: 3, CodecType: "audio", Channels: 2, Tags: map[string]string{"LANGUAGE": "eng"}},
+		{Index: 4, CodecType: "audio", Channels: 2, Tags: map[string]string{"language": "eng"}, Disposition: map[string]int{"default": 1}},
+		{Index: 5, CodecType: "subtitle"},
+	}
+
+	tests := []struct {
+		name      string
+		languages []string
+		want      int
+	}{
+		{"most channels", nil, 2},
+		{"preferred language", []string{"eng"}, 4},
+		{"two letter code", []string{"en"}, 4},
+		{"first available preference", []string{"fra", "jpn"}, 2},
+		{"no match falls back", []string{"deu"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, ok := selectAudioStream(streams, tt.languages)
+			if !ok || stream.Index != tt.want {
+				t.Errorf("selectAudioStream(%v) = %d, %v; want %d", tt.languages, stream.Index, ok, tt.want)
+			}
+		})
+	}
+
+	if _, ok := selectAudioStream(streams[:1], nil); ok {
+		t.Error("selectAudioStream found audio in a video-only file")
+	}
+}
+
+func TestSelectVideoStream(t *testing.T) {
+	streams := []MetadataStream{
+		{Index: 0, CodecType: "audio"},
+		{Index: 1, CodecType: "video", CodecName: "mjpeg", Disposition: map[string]int{"attached_pic": 1}},
+		{Index: 2, CodecType: "video", CodecName: "vp9"},
+	}
+
+	stream, ok := selectVideoStream(streams)
+	if !ok || stream.Index != 2 {
+		t.Errorf("selectVideoStream = %d, %v; want 2", stream.Index, ok)
+	}
+	if _, ok := selectVideoStream(streams[:2]); ok {
+		t.Error("selectVideoStream treated cover art as a video track")
+	}
+}
+
+func TestStreamMapArgs(t *testing.T) {
+	webm := &Metadata{Streams: []MetadataStream{
+		{Index: 0, CodecType: "video", CodecName: "vp9"},
+		{Index: 1, CodecType: "audio", CodecName: "opus", Channels: 2, Tags: map[string]string{"language": "eng"}},
+		{Index: 2, CodecType: "audio", CodecName: "opus", Channels: 6, Tags: map[string]string{"language": "deu"}},
+		{Index: 3, CodecType: "subtitle", CodecName: "webvtt"},
+	}}
+	album := &Metadata{Streams: []MetadataStream{
+		{Index: 0, CodecType: "audio", CodecName: "flac", Channels: 2},
+		{Index: 1, CodecType: "data", CodecName: "bin_data"},
+		{Index: 2, CodecType: "video", CodecName: "png", Disposition: map[string]int{"attached_pic": 1}},
+		{Index: 3, CodecType: "video", CodecName: "mjpeg", Disposition: map[string]int{"attached_pic": 1}},
+	}}
+	index := func(i int) *int { return &i }
+
+	tests := []struct {
+		name      string
+		config    Config
+		metadata  *Metadata
+		want      string
+		thumbnail bool
+	}{
+		{"drops video tracks and subtitles", Config{Codec: "aac"}, webm, "-map 0:2", false},
+		{"language preference", Config{Codec: "aac", AudioLanguages: []string{"eng"}}, webm, "-map 0:1", false},
+		{"stream index", Config{Codec: "aac", AudioStream: index(1)}, webm, "-map 0:1", false},
+		{"stream index that is not audio", Config{Codec: "aac", AudioStream: index(3)}, webm, "-map 0:2", false},
+		{"video thumbnail", Config{Codec: "aac", VideoInput: true}, webm, "-map 0:2 -map 0:0", true},
+		{"one cover, no data streams", Config{Codec: "alac"}, album, "-map 0:0 -map 0:2", false},
+		{"no cover without artwork support", Config{Codec: "opus"}, album, "-map 0:0", false},
+		{"unprobed source", Config{Codec: "aac"}, &Metadata{}, "-map 0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, thumbnail := streamMapArgs(tt.config, tt.metadata)
+			if got := strings.Join(args, " "); got != tt.want || thumbnail != tt.thumbnail {
+				t.Errorf("streamMapArgs = %q, %v; want %q, %v", got, thumbnail, tt.want, tt.thumbnail)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errTagsUnsupported is returned for files the built-in tag reader leaves to
+// ffprobe.
+var errTagsUnsupported = errors.New("format not supported by the built-in tag reader")
+
+// maxTagBlock bounds a single tag block or header box, so a corrupt length
+// cannot allocate without limit. Embedded cover art stays far below it.
+const maxTagBlock = 64 << 20
+
+// vorbisCommentKeys renames the Vorbis comments that ffprobe reports under
+// generic names. Other keys are upper-cased.
+var vorbisCommentKeys = map[string]string{
+	"ALBUMARTIST": "album_artist",
+	"TRACKNUMBER": "track",
+	"DISCNUMBER":  "disc",
+	"DESCRIPTION": "comment",
+}
+
+// readTags reads tags and basic stream info from FLAC, MP3, MP4 and Ogg
+// Vorbis/Opus files without running ffprobe. Tag keys, stream order and
+// durations follow what ffprobe reports for the same file. Other formats
+// return errTagsUnsupported.
+func readTags(path string) (*Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readTagsFrom(f, info.Size())
+}
+
+func readTagsFrom(r io.ReaderAt, size int64) (*Metadata, error) {
+	head := make([]byte, 12)
+	if n, _ := r.ReadAt(head, 0); n < len(head) {
+		return nil, errTagsUnsupported
+	}
+
+	var metadata *Metadata
+	var err error
+	switch {
+	case string(head[:4]) == "fLaC":
+		metadata, err = readFLACTags(r, 0)
+	case string(head[:4]) == "OggS":
+		metadata, err = readOggTags(r, size)
+	case string(head[4:8]) == "ftyp":
+		metadata, err = readMP4Tags(r, size)
+	case string(head[:3]) == "ID3":
+		metadata, err = readMP3Tags(r, size)
+	default:
+		if _, ok := parseMPEGHeader(head); !ok {
+			return nil, errTagsUnsupported
+		}
+		metadata, err = readMP3Tags(r, size)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range metadata.Streams {
+		metadata.Streams[i].Index = i
+	}
+	return metadata, nil
+}
+
+// readTagBytes reads exactly n bytes at off.
+func readTagBytes(r io.ReaderAt, off, n int64) ([]byte, error) {
+	if n < 0 || n > maxTagBlock {
+		return nil, fmt.Errorf("tag block of %d bytes is too large", n)
+	}
+	buf := make([]byte, n)
+	if read, err := r.ReadAt(buf, off); read < len(buf) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("read tags: %w", err)
+	}
+	return buf, nil
+}
+
+// tagAudioStream describes an audio stream with a duration known in seconds.
+func tagAudioStream(codec string, channels int, seconds float64) MetadataStream {
+	stream := MetadataStream{CodecType: "audio", CodecName: codec, Channels: channels}
+	if seconds > 0 {
+		stream.Duration = strconv.FormatFloat(seconds, 'f', 6, 64)
+	}
+	return stream
+}
+
+// attachedPictureStream describes embedded cover art, which ffprobe lists as
+// a video stream with the attached_pic disposition.
+func attachedPictureStream(data []byte, mime, title string) MetadataStream {
+	stream := MetadataStream{
+		CodecType:   "video",
+		CodecName:   pictureCodec(data, mime),
+		Disposition: map[string]int{"attached_pic": 1},
+	}
+	if title != "" {
+		stream.Tags = map[string]string{"title": title}
+	}
+	return stream
+}
+
+// pictureCodec names the image codec by the picture's signature, or by its
+// MIME type when the data is not recognized.
+func pictureCodec(data []byte, mime string) string {
+	switch {
+	case len(data) >= 3 && data[0] == 0xff && data[1] == 0xd8 && data[2] == 0xff:
+		return "mjpeg"
+	case strings.HasPrefix(string(data), "\x89PNG"):
+		return "png"
+	case strings.HasPrefix(string(data), "GIF8"):
+		return "gif"
+	case strings.HasPrefix(string(data), "BM"):
+		return "bmp"
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "webp"
+	}
+
+	mime = strings.ToLower(mime)
+	switch {
+	case strings.Contains(mime, "png"):
+		return "png"
+	case strings.Contains(mime, "gif"):
+		return "gif"
+	case strings.Contains(mime, "bmp"):
+		return "bmp"
+	case strings.Contains(mime, "webp"):
+		return "webp"
+	}
+	return "mjpeg"
+}
+
+// addTag sets a tag, joining repeated keys with ";" as ffprobe does.
+func addTag(tags map[string]string, key, value string) {
+	if key == "" {
+		return
+	}
+	if existing, ok := tags[key]; ok && existing != value {
+		value = existing + ";" + value
+	}
+	tags[key] = value
+}
+
+// parseVorbisComment reads a Vorbis comment block, the tag format of FLAC,
+// Vorbis and Opus. Embedded pictures are returned as attached picture streams.
+func parseVorbisComment(data []byte) (map[string]string, []MetadataStream, error) {
+	errShort := errors.New("truncated vorbis comment")
+
+	next := func() ([]byte, error) {
+		if len(data) < 4 {
+			return nil, errShort
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return nil, errShort
+		}
+		field := data[4 : 4+n]
+		data = data[4+n:]
+		return field, nil
+	}
+
+	if _, err := next(); err != nil { // vendor string
+		return nil, nil, err
+	}
+	if len(data) < 4 {
+		return nil, nil, errShort
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+
+	tags := make(map[string]string)
+	var pictures []MetadataStream
+	for i := uint32(0); i < count; i++ {
+		field, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		key, value, ok := strings.Cut(string(field), "=")
+		if !ok || key == "" {
+			continue
+		}
+		key = strings.ToUpper(key)
+
+		if key == "METADATA_BLOCK_PICTURE" {
+			block, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				continue
+			}
+			if picture, err := parseFLACPicture(block); err == nil {
+				pictures = append(pictures, picture)
+			}
+			continue
+		}
+		if generic, ok := vorbisCommentKeys[key]; ok {
+			key = generic
+		}
+		addTag(tags, key, value)
+	}
+	return tags, pictures, nil
+}
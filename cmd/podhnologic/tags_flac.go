@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+	flacBlockPicture       = 6
+)
+
+// readFLACTags walks the metadata blocks of a FLAC stream starting at off.
+func readFLACTags(r io.ReaderAt, off int64) (*Metadata, error) {
+	magic, err := readTagBytes(r, off, 4)
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) != "fLaC" {
+		return nil, errors.New("not a FLAC stream")
+	}
+	off += 4
+
+	var metadata Metadata
+	audio := tagAudioStream("flac", 0, 0)
+	var pictures []MetadataStream
+	for {
+		header, err := readTagBytes(r, off, 4)
+		if err != nil {
+			return nil, err
+		}
+		last := header[0]&0x80 != 0
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		off += 4
+
+		switch header[0] & 0x7f {
+		case flacBlockStreamInfo:
+			block, err := readTagBytes(r, off, length)
+			if err != nil {
+				return nil, err
+			}
+			if len(block) < 18 {
+				return nil, errors.New("truncated FLAC stream info")
+			}
+			sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+			channels := int(block[12]>>1&0x07) + 1
+			samples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+			var seconds float64
+			if sampleRate > 0 {
+				seconds = float64(samples) / float64(sampleRate)
+			}
+			audio = tagAudioStream("flac", channels, seconds)
+		case flacBlockVorbisComment:
+			block, err := readTagBytes(r, off, length)
+			if err != nil {
+				return nil, err
+			}
+			tags, embedded, err := parseVorbisComment(block)
+			if err != nil {
+				return nil, err
+			}
+			metadata.Format.Tags = tags
+			pictures = append(pictures, embedded...)
+		case flacBlockPicture:
+			block, err := readTagBytes(r, off, length)
+			if err != nil {
+				return nil, err
+			}
+			if picture, err := parseFLACPicture(block); err == nil {
+				pictures = append(pictures, picture)
+			}
+		}
+
+		off += length
+		if last {
+			break
+		}
+	}
+
+	metadata.Streams = append([]MetadataStream{audio}, pictures...)
+	return &metadata, nil
+}
+
+// parseFLACPicture reads a FLAC picture block, which Vorbis comments also
+// embed base64-encoded.
+func parseFLACPicture(block []byte) (MetadataStream, error) {
+	errShort := errors.New("truncated FLAC picture")
+
+	field := func(skip int) ([]byte, error) {
+		if len(block) < skip+4 {
+			return nil, errShort
+		}
+		n := binary.BigEndian.Uint32(block[skip:])
+		block = block[skip+4:]
+		if uint64(n) > uint64(len(block)) {
+			return nil, errShort
+		}
+		value := block[:n]
+		block = block[n:]
+		return value, nil
+	}
+
+	mime, err := field(4) // after the picture type
+	if err != nil {
+		return MetadataStream{}, err
+	}
+	description, err := field(0)
+	if err != nil {
+		return MetadataStream{}, err
+	}
+	data, err := field(16) // after width, height, depth and palette size
+	if err != nil {
+		return MetadataStream{}, err
+	}
+	return attachedPictureStream(data, string(mime), string(description)), nil
+}
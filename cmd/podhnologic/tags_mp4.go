@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// mp4TagKeys renames iTunes metadata items to the keys ffprobe reports.
+var mp4TagKeys = map[string]string{
+	"\xa9nam": "title",
+	"\xa9ART": "artist",
+	"aART":    "album_artist",
+	"\xa9alb": "album",
+	"\xa9day": "date",
+	"\xa9gen": "genre",
+	"gnre":    "genre",
+	"\xa9wrt": "composer",
+	"\xa9cmt": "comment",
+	"\xa9lyr": "lyrics",
+	"\xa9too": "encoder",
+	"\xa9enc": "encoder",
+	"\xa9grp": "grouping",
+	"cprt":    "copyright",
+	"\xa9cpy": "copyright",
+	"desc":    "description",
+	"ldes":    "synopsis",
+	"tvsh":    "show",
+	"cpil":    "compilation",
+	"pgap":    "gapless_playback",
+	"tmpo":    "tmpo",
+	"trkn":    "track",
+	"disk":    "disc",
+	"soar":    "sort_artist",
+	"soal":    "sort_album",
+	"sonm":    "sort_name",
+	"soaa":    "sort_album_artist",
+	"soco":    "sort_composer",
+}
+
+// mp4SampleCodecs names the codecs of the sample entries understood here.
+// AAC entries are resolved further by their esds box.
+var mp4SampleCodecs = map[string]string{
+	"mp4a": "aac",
+	"alac": "alac",
+	"fLaC": "flac",
+	"Opus": "opus",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	".mp3": "mp3",
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"mp4v": "mpeg4",
+	"av01": "av1",
+	"vp09": "vp9",
+	"jpeg": "mjpeg",
+}
+
+type mp4Box struct {
+	Type string
+	Data []byte
+}
+
+// readMP4Tags reads iTunes-style tags and track info from the moov box,
+// seeking past the media data. Files with tracks other than audio and video
+// are left to ffprobe.
+func readMP4Tags(r io.ReaderAt, size int64) (*Metadata, error) {
+	for off := int64(0); off+8 <= size; {
+		header, err := readTagBytes(r, off, 8)
+		if err != nil {
+			return nil, err
+		}
+		boxSize, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			large, err := readTagBytes(r, off+8, 8)
+			if err != nil {
+				return nil, err
+			}
+			boxSize, headerSize = int64(binary.BigEndian.Uint64(large)), 16
+		}
+		if boxSize < headerSize {
+			return nil, errors.New("invalid MP4 box size")
+		}
+
+		if string(header[4:8]) == "moov" {
+			moov, err := readTagBytes(r, off+headerSize, boxSize-headerSize)
+			if err != nil {
+				return nil, err
+			}
+			return parseMP4Moov(moov)
+		}
+		off += boxSize
+	}
+	return nil, errors.New("no moov box found")
+}
+
+// mp4Boxes splits data into the boxes it contains.
+func mp4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated MP4 box")
+		}
+		size, headerSize := uint64(binary.BigEndian.Uint32(data)), uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, errors.New("truncated MP4 box")
+			}
+			size, headerSize = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return nil, errors.New("invalid MP4 box size")
+		}
+		boxes = append(boxes, mp4Box{Type: string(data[4:8]), Data: data[headerSize:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// mp4Child returns the first child box of the given type.
+func mp4Child(data []byte, boxType string) (mp4Box, bool) {
+	boxes, err := mp4Boxes(data)
+	if err != nil {
+		return mp4Box{}, false
+	}
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// mp4Path follows a chain of child box types.
+func mp4Path(data []byte, path ...string) (mp4Box, bool) {
+	box := mp4Box{Data: data}
+	for _, boxType := range path {
+		var ok bool
+		if box, ok = mp4Child(box.Data, boxType); !ok {
+			return mp4Box{}, false
+		}
+	}
+	return box, true
+}
+
+// parseMP4Moov lists tracks and cover art in the order ffprobe numbers them,
+// which is the order their boxes appear in.
+func parseMP4Moov(moov []byte) (*Metadata, error) {
+	boxes, err := mp4Boxes(moov)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata Metadata
+	tags := make(map[string]string)
+	for _, box := range boxes {
+		switch box.Type {
+		case "trak":
+			stream, err := parseMP4Track(box.Data)
+			if err != nil {
+				return nil, err
+			}
+			metadata.Streams = append(metadata.Streams, stream)
+		case "udta", "meta":
+			meta := box
+			if box.Type == "udta" {
+				var ok bool
+				if meta, ok = mp4Child(box.Data, "meta"); !ok {
+					continue
+				}
+			}
+			ilst, ok := mp4Child(mp4MetaChildren(meta.Data), "ilst")
+			if !ok {
+				continue
+			}
+			pictures, err := parseMP4Items(ilst.Data, tags)
+			if err != nil {
+				return nil, err
+			}
+			metadata.Streams = append(metadata.Streams, pictures...)
+		}
+	}
+	if len(tags) > 0 {
+		metadata.Format.Tags = tags
+	}
+	return &metadata, nil
+}
+
+// mp4MetaChildren skips the version and flags that MP4 meta boxes carry and
+// QuickTime ones do not.
+func mp4MetaChildren(data []byte) []byte {
+	if len(data) >= 12 && string(data[4:8]) != "hdlr" {
+		return data[4:]
+	}
+	return data
+}
+
+func parseMP4Track(trak []byte) (MetadataStream, error) {
+	hdlr, ok := mp4Path(trak, "mdia", "hdlr")
+	if !ok || len(hdlr.Data) < 12 {
+		return MetadataStream{}, errors.New("MP4 track without handler")
+	}
+	var stream MetadataStream
+	switch string(hdlr.Data[8:12]) {
+	case "soun":
+		stream.CodecType = "audio"
+	case "vide":
+		stream.CodecType = "video"
+	default:
+		return MetadataStream{}, errTagsUnsupported
+	}
+
+	stsd, ok := mp4Path(trak, "mdia", "minf", "stbl", "stsd")
+	if !ok || len(stsd.Data) < 8 {
+		return MetadataStream{}, errors.New("MP4 track without sample description")
+	}
+	entries, err := mp4Boxes(stsd.Data[8:])
+	if err != nil || len(entries) == 0 {
+		return MetadataStream{}, errors.New("MP4 track without sample description")
+	}
+	entry := entries[0]
+	codec, ok := mp4SampleCodecs[entry.Type]
+	if !ok {
+		return MetadataStream{}, errTagsUnsupported
+	}
+	stream.CodecName = codec
+	if stream.CodecType == "audio" {
+		if entry.Type == "mp4a" {
+			if stream.CodecName, err = mp4AudioObjectCodec(entry.Data); err != nil {
+				return MetadataStream{}, err
+			}
+		}
+		if len(entry.Data) >= 18 {
+			stream.Channels = int(binary.BigEndian.Uint16(entry.Data[16:]))
+		}
+	}
+
+	if mdhd, ok := mp4Path(trak, "mdia", "mdhd"); ok {
+		seconds, language := parseMP4MediaHeader(mdhd.Data)
+		if seconds > 0 {
+			stream.Duration = strconv.FormatFloat(seconds, 'f', 6, 64)
+		}
+		if language != "" {
+			stream.Tags = map[string]string{"language": language}
+		}
+	}
+	if tkhd, ok := mp4Child(trak, "tkhd"); ok && len(tkhd.Data) >= 4 && tkhd.Data[3]&0x01 != 0 {
+		stream.Disposition = map[string]int{"default": 1}
+	}
+	return stream, nil
+}
+
+// parseMP4MediaHeader returns a track's duration in seconds and its
+// ISO 639-2 language.
+func parseMP4MediaHeader(mdhd []byte) (float64, string) {
+	var timescale uint32
+	var duration uint64
+	var rest []byte
+	switch {
+	case len(mdhd) >= 34 && mdhd[0] == 1:
+		timescale = binary.BigEndian.Uint32(mdhd[20:])
+		duration = binary.BigEndian.Uint64(mdhd[24:])
+		rest = mdhd[32:]
+	case len(mdhd) >= 22 && mdhd[0] == 0:
+		timescale = binary.BigEndian.Uint32(mdhd[12:])
+		duration = uint64(binary.BigEndian.Uint32(mdhd[16:]))
+		if duration == 0xffffffff {
+			duration = 0
+		}
+		rest = mdhd[20:]
+	default:
+		return 0, ""
+	}
+
+	var seconds float64
+	if timescale > 0 {
+		seconds = float64(duration) / float64(timescale)
+	}
+
+	// Three 5-bit letters offset from 0x60
+	packed := binary.BigEndian.Uint16(rest)
+	language := []byte{byte(packed>>10&0x1f) + 0x60, byte(packed>>5&0x1f) + 0x60, byte(packed&0x1f) + 0x60}
+	for _, c := range language {
+		if c < 'a' || c > 'z' {
+			return seconds, ""
+		}
+	}
+	return seconds, string(language)
+}
+
+// mp4AudioObjectCodec names the codec of an mp4a sample entry from the object
+// type in its elementary stream descriptor.
+func mp4AudioObjectCodec(entry []byte) (string, error) {
+	if len(entry) < 28 {
+		return "", errors.New("truncated MP4 audio sample entry")
+	}
+	// QuickTime sound descriptions version 1 and 2 are longer
+	children := entry[28:]
+	switch binary.BigEndian.Uint16(entry[8:]) {
+	case 1:
+		children = entry[min(44, len(entry)):]
+	case 2:
+		children = entry[min(64, len(entry)):]
+	}
+
+	esds, ok := mp4Child(children, "esds")
+	if !ok {
+		if esds, ok = mp4Path(children, "wave", "esds"); !ok {
+			return "aac", nil
+		}
+	}
+
+	// ES_Descriptor, then DecoderConfigDescriptor with the object type
+	data := esds.Data
+	if len(data) < 4 {
+		return "", errors.New("truncated MP4 esds box")
+	}
+	data = data[4:]
+	tag, data := mp4Descriptor(data)
+	if tag != 0x03 || len(data) < 3 {
+		return "aac", nil
+	}
+	flags := data[2]
+	data = data[3:]
+	if flags&0x80 != 0 && len(data) >= 2 {
+		data = data[2:]
+	}
+	if flags&0x40 != 0 && len(data) >= 1 {
+		data = data[min(1+int(data[0]), len(data)):]
+	}
+	if flags&0x20 != 0 && len(data) >= 2 {
+		data = data[2:]
+	}
+	if tag, data = mp4Descriptor(data); tag != 0x04 || len(data) < 1 {
+		return "aac", nil
+	}
+
+	switch data[0] {
+	case 0x40, 0x66, 0x67, 0x68:
+		return "aac", nil
+	case 0x69, 0x6b:
+		return "mp3", nil
+	case 0xa5:
+		return "ac3", nil
+	case 0xa6:
+		return "eac3", nil
+	}
+	return "", errTagsUnsupported
+}
+
+// mp4Descriptor returns an MPEG-4 descriptor's tag and body; lengths use up
+// to four 7-bit bytes.
+func mp4Descriptor(data []byte) (byte, []byte) {
+	if len(data) < 2 {
+		return 0, nil
+	}
+	tag := data[0]
+	data = data[1:]
+	var length int
+	for i := 0; i < 4 && len(data) > 0; i++ {
+		b := data[0]
+		data = data[1:]
+		length = length<<7 | int(b&0x7f)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	if length > len(data) {
+		length = len(data)
+	}
+	return tag, data[:length]
+}
+
+// parseMP4Items reads the items of an ilst box into tags and returns the
+// cover art as attached picture streams.
+func parseMP4Items(ilst []byte, tags map[string]string) ([]MetadataStream, error) {
+	items, err := mp4Boxes(ilst)
+	if err != nil {
+		return nil, err
+	}
+
+	var pictures []MetadataStream
+	for _, item := range items {
+		children, err := mp4Boxes(item.Data)
+		if err != nil {
+			continue
+		}
+
+		key := mp4TagKeys[item.Type]
+		for _, child := range children {
+			switch child.Type {
+			case "name":
+				// Freeform items are keyed by their name
+				if item.Type == "----" && len(child.Data) >= 4 {
+					key = string(child.Data[4:])
+				}
+			case "data":
+				if len(child.Data) < 8 {
+					continue
+				}
+				dataType := binary.BigEndian.Uint32(child.Data) & 0xffffff
+				value := child.Data[8:]
+				if item.Type == "covr" {
+					pictures = append(pictures, attachedPictureStream(value, "", ""))
+					continue
+				}
+				if key == "" {
+					continue
+				}
+				if s, ok := mp4ItemValue(item.Type, dataType, value); ok {
+					addTag(tags, key, s)
+				}
+			}
+		}
+	}
+	return pictures, nil
+}
+
+// mp4ItemValue formats an item's data as ffprobe does: text as is, track and
+// disc numbers as n or n/total, genres by name and integers in decimal.
+func mp4ItemValue(itemType string, dataType uint32, value []byte) (string, bool) {
+	switch itemType {
+	case "trkn", "disk":
+		if len(value) < 6 {
+			return "", false
+		}
+		n, total := binary.BigEndian.Uint16(value[2:]), binary.BigEndian.Uint16(value[4:])
+		if total > 0 {
+			return fmt.Sprintf("%d/%d", n, total), true
+		}
+		return strconv.Itoa(int(n)), true
+	case "gnre":
+		if len(value) < 2 {
+			return "", false
+		}
+		if n := int(binary.BigEndian.Uint16(value)); n > 0 && n <= len(id3Genres) {
+			return id3Genres[n-1], true
+		}
+		return "", false
+	}
+
+	switch dataType {
+	case 1: // UTF-8
+		return string(value), true
+	case 0, 21: // implicit or signed big-endian integer
+		if len(value) == 0 || len(value) > 8 {
+			return "", false
+		}
+		var n int64
+		if value[0]&0x80 != 0 {
+			n = -1
+		}
+		for _, b := range value {
+			n = n<<8 | int64(b)
+		}
+		return strconv.FormatInt(n, 10), true
+	}
+	return "", false
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// oggTailWindow is how much of the file end is searched for the last page,
+// whose granule position gives the duration.
+const oggTailWindow = 64 << 10
+
+// oggPage is a page header and its segment table.
+type oggPage struct {
+	bos      bool
+	granule  uint64
+	serial   uint32
+	segments []byte
+	size     int64 // header and body
+}
+
+func readOggPage(r io.ReaderAt, off int64) (oggPage, error) {
+	header, err := readTagBytes(r, off, 27)
+	if err != nil {
+		return oggPage{}, err
+	}
+	if string(header[:4]) != "OggS" {
+		return oggPage{}, errors.New("lost Ogg page sync")
+	}
+	segments, err := readTagBytes(r, off+27, int64(header[26]))
+	if err != nil {
+		return oggPage{}, err
+	}
+	page := oggPage{
+		bos:      header[5]&0x02 != 0,
+		granule:  binary.LittleEndian.Uint64(header[6:]),
+		serial:   binary.LittleEndian.Uint32(header[14:]),
+		segments: segments,
+		size:     27 + int64(len(segments)),
+	}
+	for _, lacing := range segments {
+		page.size += int64(lacing)
+	}
+	return page, nil
+}
+
+// readOggTags reads the identification and comment headers of an Ogg Vorbis
+// or Opus file. ffprobe reports Ogg comments as stream tags, so they are set
+// on the audio stream. Multiplexed and other Ogg codecs are left to ffprobe.
+func readOggTags(r io.ReaderAt, size int64) (*Metadata, error) {
+	var packets [][]byte
+	var packet []byte
+	var serial uint32
+	for off := int64(0); len(packets) < 2; {
+		page, err := readOggPage(r, off)
+		if err != nil {
+			return nil, err
+		}
+		if off == 0 {
+			serial = page.serial
+		} else if page.bos || page.serial != serial {
+			return nil, errTagsUnsupported
+		}
+
+		body, err := readTagBytes(r, off+27+int64(len(page.segments)), page.size-27-int64(len(page.segments)))
+		if err != nil {
+			return nil, err
+		}
+		for _, lacing := range page.segments {
+			segment := body[:lacing]
+			body = body[lacing:]
+			if len(packet)+len(segment) > maxTagBlock {
+				return nil, errors.New("Ogg header packet is too large")
+			}
+			packet = append(packet, segment...)
+			if lacing < 255 {
+				packets = append(packets, packet)
+				packet = nil
+				if len(packets) == 2 {
+					break
+				}
+			}
+		}
+		off += page.size
+	}
+
+	var codec string
+	var channels, preSkip, sampleRate int
+	var comments []byte
+	id, comment := packets[0], packets[1]
+	switch {
+	case len(id) >= 16 && bytes.HasPrefix(id, []byte("\x01vorbis")) && bytes.HasPrefix(comment, []byte("\x03vorbis")):
+		codec, channels = "vorbis", int(id[11])
+		sampleRate = int(binary.LittleEndian.Uint32(id[12:]))
+		comments = comment[7:]
+	case len(id) >= 12 && bytes.HasPrefix(id, []byte("OpusHead")) && bytes.HasPrefix(comment, []byte("OpusTags")):
+		codec, channels = "opus", int(id[9])
+		preSkip, sampleRate = int(binary.LittleEndian.Uint16(id[10:])), 48000
+		comments = comment[8:]
+	default:
+		return nil, errTagsUnsupported
+	}
+
+	tags, pictures, err := parseVorbisComment(comments)
+	if err != nil {
+		return nil, err
+	}
+
+	var seconds float64
+	if granule, ok := lastOggGranule(r, size, serial); ok && sampleRate > 0 && granule > uint64(preSkip) {
+		seconds = float64(granule-uint64(preSkip)) / float64(sampleRate)
+	}
+	audio := tagAudioStream(codec, channels, seconds)
+	if len(tags) > 0 {
+		audio.Tags = tags
+	}
+
+	return &Metadata{Streams: append([]MetadataStream{audio}, pictures...)}, nil
+}
+
+// lastOggGranule returns the granule position of the last complete page of
+// the stream, counted in samples.
+func lastOggGranule(r io.ReaderAt, size int64, serial uint32) (uint64, bool) {
+	start := size - oggTailWindow
+	if start < 0 {
+		start = 0
+	}
+	tail, err := readTagBytes(r, start, size-start)
+	if err != nil {
+		return 0, false
+	}
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if i+27 > len(tail) {
+			continue
+		}
+		granule := binary.LittleEndian.Uint64(tail[i+6:])
+		if binary.LittleEndian.Uint32(tail[i+14:]) == serial && granule != ^uint64(0) {
+			return granule, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	testJPEG = []byte{0xff, 0xd8, 0xff, 0xe0, 0, 0x10, 'J', 'F', 'I', 'F'}
+	testPNG  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+func be32(n int) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(n))
+}
+
+func le32(n int) []byte {
+	return binary.LittleEndian.AppendUint32(nil, uint32(n))
+}
+
+func vorbisComment(comments ...string) []byte {
+	data := append(le32(6), "tester"...)
+	data = append(data, le32(len(comments))...)
+	for _, c := range comments {
+		data = append(data, le32(len(c))...)
+		data = append(data, c...)
+	}
+	return data
+}
+
+func flacPicture(mime, description string, data []byte) []byte {
+	block := be32(3) // front cover
+	block = append(block, be32(len(mime))...)
+	block = append(block, mime...)
+	block = append(block, be32(len(description))...)
+	block = append(block, description...)
+	block = append(block, make([]byte, 16)...)
+	block = append(block, be32(len(data))...)
+	return append(block, data...)
+}
+
+// buildFLAC writes a FLAC header: 10 seconds of 44.1 kHz stereo, the given
+// comments and an optional picture block.
+func buildFLAC(picture []byte, comments ...string) []byte {
+	info := make([]byte, 34)
+	rate, channels, samples := 44100, 2, 441000
+	info[10] = byte(rate >> 12)
+	info[11] = byte(rate >> 4)
+	info[12] = byte(rate<<4) | byte(channels-1)<<1
+	binary.BigEndian.PutUint32(info[14:], uint32(samples))
+
+	blocks := [][]byte{info, vorbisComment(comments...)}
+	types := []byte{flacBlockStreamInfo, flacBlockVorbisComment}
+	if picture != nil {
+		blocks = append(blocks, picture)
+		types = append(types, flacBlockPicture)
+	}
+
+	data := []byte("fLaC")
+	for i, block := range blocks {
+		header := be32(len(block))
+		header[0] = types[i]
+		if i == len(blocks)-1 {
+			header[0] |= 0x80
+		}
+		data = append(data, header...)
+		data = append(data, block...)
+	}
+	return append(data, 0xff, 0xf8) // first audio frame
+}
+
+func writeTagFixture(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func readTagFixture(t *testing.T, name string, data []byte) *Metadata {
+	t.Helper()
+	metadata, err := readTags(writeTagFixture(t, name, data))
+	if err != nil {
+		t.Fatalf("readTags(%s) failed: %v", name, err)
+	}
+	return metadata
+}
+
+func checkTags(t *testing.T, got map[string]string, want map[string]string) {
+	t.Helper()
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("tag %q = %q, want %q (tags %v)", key, got[key], value, got)
+		}
+	}
+}
+
+func TestReadTagsFLAC(t *testing.T) {
+	metadata := readTagFixture(t, "song.flac", buildFLAC(
+		flacPicture("image/jpeg", "Front", testJPEG),
+		"TITLE=Song", "Artist=Band", "ARTIST=Guest", "TRACKNUMBER=3/12", "ALBUMARTIST=Band", "DISCNUMBER=1",
+	))
+
+	checkTags(t, metadata.Format.Tags, map[string]string{
+		"TITLE":        "Song",
+		"ARTIST":       "Band;Guest",
+		"track":        "3/12",
+		"album_artist": "Band",
+		"disc":         "1",
+	})
+	if metadata.Tag("title") != "Song" {
+		t.Errorf("Tag(title) = %q", metadata.Tag("title"))
+	}
+
+	if len(metadata.Streams) != 2 {
+		t.Fatalf("streams = %+v, want audio and cover", metadata.Streams)
+	}
+	audio, cover := metadata.Streams[0], metadata.Streams[1]
+	if audio.Index != 0 || audio.CodecName != "flac" || audio.Channels != 2 || audio.Duration != "10.000000" {
+		t.Errorf("audio stream = %+v", audio)
+	}
+	if cover.Index != 1 || cover.CodecName != "mjpeg" || !cover.IsAttachedPicture() || cover.Tags["title"] != "Front" {
+		t.Errorf("cover stream = %+v", cover)
+	}
+}
+
+// id3Frame builds an ID3v2.3 frame, or v2.4 with syncsafe sizes.
+func id3Frame(version int, id string, body []byte) []byte {
+	size := be32(len(body))
+	if version == 4 {
+		size = syncsafeBytes(len(body))
+	}
+	frame := append([]byte(id), size...)
+	frame = append(frame, 0, 0)
+	return append(frame, body...)
+}
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+func id3Tag(version int, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	body = append(body, make([]byte, 16)...) // padding
+	tag := []byte{'I', 'D', '3', byte(version), 0, 0}
+	tag = append(tag, syncsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+// mpegFrames builds CBR MPEG-1 layer III frames at 128 kbps and 44.1 kHz,
+// 417 bytes each without padding. The first frame can carry a Xing header.
+func mpegFrames(count int, xingFrames int) []byte {
+	var data []byte
+	for i := 0; i < count; i++ {
+		frame := make([]byte, 417)
+		copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+		if i == 0 && xingFrames > 0 {
+			copy(frame[36:], "Xing")
+			copy(frame[40:], be32(1))
+			copy(frame[44:], be32(xingFrames))
+		}
+		data = append(data, frame...)
+	}
+	return data
+}
+
+func utf16Text(s string) []byte {
+	text := []byte{1, 0xff, 0xfe}
+	for _, r := range s {
+		text = binary.LittleEndian.AppendUint16(text, uint16(r))
+	}
+	return text
+}
+
+func TestReadTagsID3v24(t *testing.T) {
+	apic := append([]byte{0}, "image/png\x00\x03Cover\x00"...)
+	apic = append(apic, testPNG...)
+	data := id3Tag(4,
+		id3Frame(4, "TIT2", []byte("\x03Song")),
+		id3Frame(4, "TPE1", []byte("\x03Band\x00Guest")),
+		id3Frame(4, "TRCK", []byte("\x003/12")),
+		id3Frame(4, "TCON", []byte("\x00(17)")),
+		id3Frame(4, "TDRC", []byte("\x002019-05-01")),
+		id3Frame(4, "TXXX", []byte("\x00MusicBrainz Album Id\x00abc")),
+		id3Frame(4, "COMM", []byte("\x00engNote\x00Live")),
+		id3Frame(4, "USLT", []byte("\x00eng\x00First line")),
+		id3Frame(4, "APIC", apic),
+	)
+	data = append(data, mpegFrames(10, 0)...)
+
+	metadata := readTagFixture(t, "song.mp3", data)
+	checkTags(t, metadata.Format.Tags, map[string]string{
+		"title":                "Song",
+		"artist":               "Band;Guest",
+		"track":                "3/12",
+		"genre":                "Rock",
+		"date":                 "2019-05-01",
+		"MusicBrainz Album Id": "abc",
+		"comment-Note":         "Live",
+		"lyrics-eng":           "First line",
+	})
+
+	if len(metadata.Streams) != 2 {
+		t.Fatalf("streams = %+v, want audio and cover", metadata.Streams)
+	}
+	audio, cover := metadata.Streams[0], metadata.Streams[1]
+	// 4170 bytes at 128 kbps
+	if audio.CodecName != "mp3" || audio.Channels != 2 || audio.Duration != "0.260625" {
+		t.Errorf("audio stream = %+v", audio)
+	}
+	if cover.Index != 1 || cover.CodecName != "png" || !cover.IsAttachedPicture() || cover.Tags["title"] != "Cover" {
+		t.Errorf("cover stream = %+v", cover)
+	}
+}
+
+func TestReadTagsID3v23(t *testing.T) {
+	data := id3Tag(3,
+		id3Frame(3, "TIT2", utf16Text("Café")),
+		id3Frame(3, "TYER", []byte("\x001999")),
+		id3Frame(3, "TDAT", []byte("\x002412")),
+		id3Frame(3, "TPE2", []byte("\x00Caf\xe9")),
+	)
+	data = append(data, mpegFrames(3, 1000)...)
+
+	metadata := readTagFixture(t, "song.mp3", data)
+	checkTags(t, metadata.Format.Tags, map[string]string{
+		"title":        "Café",
+		"date":         "1999-12-24",
+		"album_artist": "Café",
+	})
+	// 1000 frames of 1152 samples from the Xing header
+	if got := metadata.Streams[0].Duration; got != "26.122449" {
+		t.Errorf("duration = %s, want the Xing frame count's", got)
+	}
+}
+
+func TestReadTagsID3v1(t *testing.T) {
+	tag := make([]byte, 128)
+	copy(tag, "TAG")
+	copy(tag[3:], "Old Song")
+	copy(tag[33:], "Old Band")
+	copy(tag[93:], "1987")
+	tag[126] = 7
+	tag[127] = 17
+
+	metadata := readTagFixture(t, "old.mp3", append(mpegFrames(5, 0), tag...))
+	checkTags(t, metadata.Format.Tags, map[string]string{
+		"title":  "Old Song",
+		"artist": "Old Band",
+		"date":   "1987",
+		"track":  "7",
+		"genre":  "Rock",
+	})
+	if got := metadata.Streams[0].Duration; got != "0.130312" {
+		t.Errorf("duration = %s, want 5 frames without the ID3v1 tag", got)
+	}
+}
+
+func mp4Atom(boxType string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	return append(append(be32(8+len(body)), boxType...), body...)
+}
+
+func mp4Data(dataType int, value []byte) []byte {
+	return mp4Atom("data", be32(dataType), be32(0), value)
+}
+
+func mp4Track(handler, codec string, channels int, language string, entryChildren ...[]byte) []byte {
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[16:], uint16(channels))
+	entry = append(entry, bytes.Join(entryChildren, nil)...)
+
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], 44100)
+	binary.BigEndian.PutUint32(mdhd[16:], 44100*5/2)
+	binary.BigEndian.PutUint16(mdhd[20:], uint16(language[0]-0x60)<<10|uint16(language[1]-0x60)<<5|uint16(language[2]-0x60))
+
+	return mp4Atom("trak",
+		mp4Atom("tkhd", []byte{0, 0, 0, 1}, make([]byte, 80)),
+		mp4Atom("mdia",
+			mp4Atom("mdhd", mdhd),
+			mp4Atom("hdlr", make([]byte, 8), []byte(handler), make([]byte, 12)),
+			mp4Atom("minf", mp4Atom("stbl", mp4Atom("stsd", be32(0), be32(1), mp4Atom(codec, entry)))),
+		),
+	)
+}
+
+func buildM4A(tracks ...[]byte) []byte {
+	esds := mp4Atom("esds", be32(0), []byte{0x03, 0x15, 0, 1, 0, 0x04, 0x0d, 0x40, 0x15})
+	if len(tracks) == 0 {
+		tracks = [][]byte{mp4Track("soun", "mp4a", 2, "eng", esds)}
+	}
+
+	ilst := mp4Atom("ilst",
+		mp4Atom("\xa9nam", mp4Data(1, []byte("Song"))),
+		mp4Atom("\xa9ART", mp4Data(1, []byte("Band"))),
+		mp4Atom("trkn", mp4Data(0, []byte{0, 0, 0, 3, 0, 12, 0, 0})),
+		mp4Atom("gnre", mp4Data(0, []byte{0, 18})),
+		mp4Atom("cpil", mp4Data(21, []byte{1})),
+		mp4Atom("----",
+			mp4Atom("mean", be32(0), []byte("com.apple.iTunes")),
+			mp4Atom("name", be32(0), []byte("MusicBrainz Track Id")),
+			mp4Data(1, []byte("xyz")),
+		),
+		mp4Atom("covr", mp4Data(13, testJPEG)),
+	)
+	meta := mp4Atom("meta", be32(0), mp4Atom("hdlr", make([]byte, 8), []byte("mdir"), make([]byte, 12)), ilst)
+
+	moov := mp4Atom("moov", append(bytes.Join(tracks, nil), mp4Atom("udta", meta)...))
+	data := mp4Atom("ftyp", []byte("M4A "), be32(0), []byte("isomM4A "))
+	data = append(data, mp4Atom("mdat", make([]byte, 1024))...)
+	return append(data, moov...)
+}
+
+func TestReadTagsMP4(t *testing.T) {
+	metadata := readTagFixture(t, "song.m4a", buildM4A())
+	checkTags(t, metadata.Format.Tags, map[string]string{
+		"title":                "Song",
+		"artist":               "Band",
+		"track":                "3/12",
+		"genre":                "Rock",
+		"compilation":          "1",
+		"MusicBrainz Track Id": "xyz",
+	})
+
+	if len(metadata.Streams) != 2 {
+		t.Fatalf("streams = %+v, want audio and cover", metadata.Streams)
+	}
+	audio, cover := metadata.Streams[0], metadata.Streams[1]
+	if audio.CodecName != "aac" || audio.Channels != 2 || audio.Duration != "2.500000" || audio.Language() != "eng" || audio.Disposition["default"] != 1 {
+		t.Errorf("audio stream = %+v", audio)
+	}
+	if cover.Index != 1 || cover.CodecName != "mjpeg" || !cover.IsAttachedPicture() {
+		t.Errorf("cover stream = %+v", cover)
+	}
+}
+
+func TestReadTagsMP4Video(t *testing.T) {
+	metadata := readTagFixture(t, "clip.mp4", buildM4A(
+		mp4Track("vide", "avc1", 0, "und"),
+		mp4Track("soun", "alac", 2, "deu"),
+	))
+	if len(metadata.Streams) != 3 {
+		t.Fatalf("streams = %+v, want video, audio and cover", metadata.Streams)
+	}
+	video, audio := metadata.Streams[0], metadata.Streams[1]
+	if video.CodecType != "video" || video.CodecName != "h264" || video.IsAttachedPicture() {
+		t.Errorf("video stream = %+v", video)
+	}
+	if audio.Index != 1 || audio.CodecName != "alac" || audio.Language() != "deu" {
+		t.Errorf("audio stream = %+v", audio)
+	}
+}
+
+func oggPageBytes(flags byte, granule uint64, packets ...[]byte) []byte {
+	var segments, body []byte
+	for _, packet := range packets {
+		n := len(packet)
+		for ; n >= 255; n -= 255 {
+			segments = append(segments, 255)
+		}
+		segments = append(segments, byte(n))
+		body = append(body, packet...)
+	}
+	page := []byte{'O', 'g', 'g', 'S', 0, flags}
+	page = binary.LittleEndian.AppendUint64(page, granule)
+	page = append(page, le32(0x1234)...)
+	page = append(page, make([]byte, 8)...) // sequence number and checksum
+	page = append(page, byte(len(segments)))
+	page = append(page, segments...)
+	return append(page, body...)
+}
+
+func TestReadTagsOpus(t *testing.T) {
+	head := append([]byte("OpusHead"), 1, 2)
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = append(head, le32(48000)...)
+	head = append(head, 0, 0, 0)
+
+	picture := base64.StdEncoding.EncodeToString(flacPicture("image/png", "", testPNG))
+	// A long comment packet spans lacing values of 255
+	tags := append([]byte("OpusTags"), vorbisComment("TITLE=Song", "ARTIST=Band", "COMMENT="+strings.Repeat("x", 600), "METADATA_BLOCK_PICTURE="+picture)...)
+
+	data := oggPageBytes(0x02, 0, head)
+	data = append(data, oggPageBytes(0, 0, tags)...)
+	data = append(data, oggPageBytes(0, 48000*3+312, make([]byte, 100))...)
+	data = append(data, oggPageBytes(0x04, 48000*4+312, make([]byte, 100))...)
+
+	metadata := readTagFixture(t, "song.opus", data)
+	if len(metadata.Streams) != 2 {
+		t.Fatalf("streams = %+v, want audio and cover", metadata.Streams)
+	}
+	audio := metadata.Streams[0]
+	if audio.CodecName != "opus" || audio.Channels != 2 || audio.Duration != "4.000000" {
+		t.Errorf("audio stream = %+v", audio)
+	}
+	// ffprobe reports Ogg comments on the stream
+	checkTags(t, audio.Tags, map[string]string{"TITLE": "Song", "ARTIST": "Band", "COMMENT": strings.Repeat("x", 600)})
+	if cover := metadata.Streams[1]; cover.CodecName != "png" || !cover.IsAttachedPicture() {
+		t.Errorf("cover stream = %+v", cover)
+	}
+}
+
+func TestReadTagsVorbis(t *testing.T) {
+	id := append([]byte("\x01vorbis"), 0, 0, 0, 0, 1)
+	id = append(id, le32(44100)...)
+	id = append(id, make([]byte, 14)...)
+	comment := append([]byte("\x03vorbis"), vorbisComment("TRACKNUMBER=5")...)
+
+	data := oggPageBytes(0x02, 0, id)
+	data = append(data, oggPageBytes(0, 0, comment, []byte("\x05vorbis"))...)
+	data = append(data, oggPageBytes(0x04, 44100*2, make([]byte, 10))...)
+
+	metadata := readTagFixture(t, "song.ogg", data)
+	audio := metadata.Streams[0]
+	if audio.CodecName != "vorbis" || audio.Channels != 1 || audio.Duration != "2.000000" || audio.Tags["track"] != "5" {
+		t.Errorf("audio stream = %+v", audio)
+	}
+}
+
+func TestReadTagsUnsupported(t *testing.T) {
+	wav := append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 64)...)
+	if _, err := readTags(writeTagFixture(t, "song.wav", wav)); !errors.Is(err, errTagsUnsupported) {
+		t.Errorf("WAV error = %v, want errTagsUnsupported", err)
+	}
+
+	ape := append(mpegFrames(3, 0), "APETAGEX"...)
+	ape = append(ape, make([]byte, 24)...)
+	if _, err := readTags(writeTagFixture(t, "ape.mp3", ape)); !errors.Is(err, errTagsUnsupported) {
+		t.Errorf("APE-tagged MP3 error = %v, want errTagsUnsupported", err)
+	}
+
+	chapters := buildM4A(mp4Track("soun", "alac", 2, "eng"), mp4Track("text", "text", 0, "eng"))
+	if _, err := readTags(writeTagFixture(t, "book.m4b", chapters)); !errors.Is(err, errTagsUnsupported) {
+		t.Errorf("chapter track error = %v, want errTagsUnsupported", err)
+	}
+
+	flac := buildFLAC(nil, "TITLE=Song")
+	if _, err := readTags(writeTagFixture(t, "cut.flac", flac[:30])); err == nil {
+		t.Error("truncated FLAC was read without error")
+	}
+}
+
+func TestProbeMetadataIgnoresTagReader(t *testing.T) {
+	backend := newFakeBackend()
+	path := writeTagFixture(t, "song.flac", buildFLAC(nil, "TITLE=Song"))
+	probed := &Metadata{Streams: []MetadataStream{{Index: 0, CodecType: "video", CodecName: "mjpeg"}, {Index: 1, CodecType: "audio", CodecName: "flac"}}}
+	backend.Metadata[path] = probed
+
+	metadata, err := probeMetadata(backend, path, 0)
+	if err != nil {
+		t.Fatalf("probeMetadata failed: %v", err)
+	}
+	if len(metadata.Streams) != 2 || metadata.Streams[1].CodecType != "audio" {
+		t.Errorf("streams = %+v, want ffprobe's numbering", metadata.Streams)
+	}
+	if calls := backend.Calls(LinkedFFmpegToolFFprobe); len(calls) != 1 {
+		t.Errorf("ffprobe ran %d times, want 1", len(calls))
+	}
+}
+
+func TestPlanUsesTagReaderButConversionProbes(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	input := helper.WriteInputFile("rip/track01.flac", buildFLAC(nil, "ARTIST=Band", "TITLE=Song"))
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "alac", OutputTemplate: "{artist}/{title}"}
+
+	backend := newFakeBackend()
+	job, err := planFile(backend, input, config, false)
+	if err != nil {
+		t.Fatalf("planFile failed: %v", err)
+	}
+	if want := filepath.Join(helper.outputDir, "Band", "Song.m4a"); job.OutputPath != want {
+		t.Errorf("output = %s, want %s", job.OutputPath, want)
+	}
+	if calls := backend.Calls(LinkedFFmpegToolFFprobe); len(calls) != 0 {
+		t.Errorf("planning ran ffprobe %d times, want 0", len(calls))
+	}
+	if job.Metadata != nil {
+		t.Error("the tag reader's streams were kept for the conversion")
+	}
+
+	if err := runBatch(backend, []conversionJob{job}, false); err != nil {
+		t.Fatalf("runBatch failed: %v", err)
+	}
+	if calls := backend.Calls(LinkedFFmpegToolFFprobe); len(calls) != 1 {
+		t.Errorf("conversion ran ffprobe %d times, want 1", len(calls))
+	}
+}
+
+func TestDryRunPlansWithTagReader(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	input := helper.WriteInputFile("rip/track01.flac", buildFLAC(nil, "ARTIST=Band", "TITLE=Song"))
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "alac", OutputTemplate: "{artist}/{title}"}
+
+	backend := newFakeBackend()
+	job, err := planFile(backend, input, config, true)
+	if err != nil {
+		t.Fatalf("planFile failed: %v", err)
+	}
+	if want := filepath.Join(helper.outputDir, "Band", "Song.m4a"); job.OutputPath != want {
+		t.Errorf("output = %s, want %s", job.OutputPath, want)
+	}
+	if calls := backend.Calls(LinkedFFmpegToolFFprobe); len(calls) != 0 {
+		t.Errorf("dry run ran ffprobe %d times, want 0", len(calls))
+	}
+	if summary := metadataSummary(job.Metadata); summary != "Band - Song" {
+		t.Errorf("summary = %q", summary)
+	}
+}
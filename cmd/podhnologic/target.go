@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target is one named output tree produced from the input library
+type Target struct {
+	Name           string `json:"name"`
+	OutputDir      string `json:"output_dir"`
+	Codec          string `json:"codec"`
+	IPod           bool   `json:"ipod"`
+	NoLyrics       bool   `json:"no_lyrics"`
+	OutputTemplate string `json:"output_template,omitempty"`
+	Filesystem     string `json:"filesystem,omitempty"`
+}
+
+// targetConfig is the effective configuration for one target.
+type targetConfig struct {
+	Name   string
+	Config Config
+}
+
+// targetConfigs expands the configured targets into full configurations.
+// Without targets, the top-level settings form a single unnamed target.
+// Templates and filesystem profiles are inherited when a target leaves them empty.
+func (c Config) targetConfigs() []targetConfig {
+	if len(c.Targets) == 0 {
+		return []targetConfig{{Config: c}}
+	}
+
+	configs := make([]targetConfig, 0, len(c.Targets))
+	for _, target := range c.Targets {
+		tc := c
+		tc.Targets = nil
+		tc.OutputDir = expandPath(target.OutputDir)
+		tc.Codec = target.Codec
+		tc.IPod = target.IPod
+		tc.NoLyrics = c.NoLyrics || target.NoLyrics
+		if target.OutputTemplate != "" {
+			tc.OutputTemplate = target.OutputTemplate
+		}
+		if target.Filesystem != "" {
+			tc.Filesystem = target.Filesystem
+		}
+		if tc.Codec == "" && tc.IPod {
+			tc.Codec = "aac"
+		}
+		configs = append(configs, targetConfig{Name: target.Name, Config: tc})
+	}
+	return configs
+}
+
+// selectTargets narrows the configured targets to the named ones.
+func (c *Config) selectTargets(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	var selected []Target
+	for _, name := range names {
+		found := false
+		for _, target := range c.Targets {
+			if target.Name == name {
+				selected = append(selected, target)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown target %q (configured: %s)", name, strings.Join(c.targetNames(), ", "))
+		}
+	}
+	c.Targets = selected
+	return nil
+}
+
+func (c Config) targetNames() []string {
+	names := make([]string, 0, len(c.Targets))
+	for _, target := range c.Targets {
+		names = append(names, target.Name)
+	}
+	return names
+}
+
+func validateTargets(config Config) error {
+	seen := make(map[string]bool, len(config.Targets))
+	for i, target := range config.Targets {
+		if target.Name == "" {
+			return fmt.Errorf("target %d has no name", i+1)
+		}
+		if seen[target.Name] {
+			return fmt.Errorf("target %q is defined more than once", target.Name)
+		}
+		seen[target.Name] = true
+		if target.OutputDir == "" {
+			return fmt.Errorf("target %q has no output directory", target.Name)
+		}
+		if target.Codec == "" && !target.IPod {
+			return fmt.Errorf("target %q needs a codec or ipod mode", target.Name)
+		}
+	}
+	return nil
+}
+
+func hasOutput(config Config) bool {
+	return config.OutputDir != "" || len(config.Targets) > 0
+}
+
+func hasCodec(config Config) bool {
+	return config.Codec != "" || config.IPod || len(config.Targets) > 0
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetConfigs(t *testing.T) {
+	config := Config{
+		InputDir:       "/music",
+		OutputDir:      "/out",
+		Codec:          "flac",
+		OutputTemplate: "{artist}/{title}",
+		Targets: []Target{
+			{Name: "ipod", OutputDir: "/mnt/ipod", IPod: true, Filesystem: "fat32"},
+			{Name: "phone", OutputDir: "/mnt/phone", Codec: "opus", OutputTemplate: "{album}/{title}"},
+		},
+	}
+
+	targets := config.targetConfigs()
+	if len(targets) != 2 {
+		t.Fatalf("targets = %d, want 2", len(targets))
+	}
+
+	ipod := targets[0].Config
+	if targets[0].Name != "ipod" || ipod.OutputDir != "/mnt/ipod" || ipod.Codec != "aac" || !ipod.IPod {
+		t.Errorf("ipod target = %+v", ipod)
+	}
+	if ipod.OutputTemplate != "{artist}/{title}" || ipod.Filesystem != "fat32" {
+		t.Errorf("ipod target did not inherit settings: %+v", ipod)
+	}
+
+	phone := targets[1].Config
+	if phone.Codec != "opus" || phone.IPod || phone.OutputTemplate != "{album}/{title}" || phone.InputDir != "/music" {
+		t.Errorf("phone target = %+v", phone)
+	}
+
+	single := Config{OutputDir: "/out", Codec: "aac"}.targetConfigs()
+	if len(single) != 1 || single[0].Name != "" || single[0].Config.OutputDir != "/out" {
+		t.Errorf("untargeted config = %+v", single)
+	}
+}
+
+func TestSelectTargets(t *testing.T) {
+	config := Config{Targets: []Target{{Name: "ipod"}, {Name: "phone"}, {Name: "archive"}}}
+
+	if err := config.selectTargets([]string{"archive", "ipod"}); err != nil {
+		t.Fatalf("selectTargets error: %v", err)
+	}
+	if names := config.targetNames(); len(names) != 2 || names[0] != "archive" || names[1] != "ipod" {
+		t.Errorf("selected = %v, want [archive ipod]", names)
+	}
+	if err := config.selectTargets([]string{"car"}); err == nil {
+		t.Error("selectTargets accepted an unknown target")
+	}
+}
+
+func TestValidateTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []Target
+		wantErr bool
+	}{
+		{"valid", []Target{{Name: "a", OutputDir: "/a", Codec: "aac"}, {Name: "b", OutputDir: "/b", IPod: true}}, false},
+		{"missing name", []Target{{OutputDir: "/a", Codec: "aac"}}, true},
+		{"duplicate name", []Target{{Name: "a", OutputDir: "/a", Codec: "aac"}, {Name: "a", OutputDir: "/b", Codec: "aac"}}, true},
+		{"missing output", []Target{{Name: "a", Codec: "aac"}}, true},
+		{"missing codec", []Target{{Name: "a", OutputDir: "/a"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTargets(Config{Targets: tt.targets})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateTargets error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlanConversionAcrossTargets(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	files := planTestFiles(t, helper, "a.flac", "b.flac")
+
+	ipodDir := filepath.Join(helper.outputDir, "ipod")
+	archiveDir := filepath.Join(helper.outputDir, "archive")
+	config := Config{
+		InputDir: helper.inputDir,
+		Targets: []Target{
+			{Name: "ipod", OutputDir: ipodDir, IPod: true},
+			{Name: "archive", OutputDir: archiveDir, Codec: "alac"},
+		},
+	}
+
+	jobs, collisions, errs := planConversion(newFakeBackend(), files, config, true)
+	if len(errs) != 0 || len(collisions) != 0 {
+		t.Fatalf("planConversion errs = %v, collisions = %v", errs, collisions)
+	}
+	if len(jobs) != 4 {
+		t.Fatalf("jobs = %d, want 4", len(jobs))
+	}
+
+	batches := batchJobsByInput(jobs)
+	if len(batches) != 2 {
+		t.Fatalf("batches = %d, want 2", len(batches))
+	}
+	for _, batch := range batches {
+		if len(batch) != 2 || batch[0].InputPath != batch[1].InputPath {
+			t.Fatalf("batch does not group one source: %+v", batch)
+		}
+		for _, job := range batch {
+			dir := ipodDir
+			if job.Target == "archive" {
+				dir = archiveDir
+			}
+			if filepath.Dir(job.OutputPath) != dir || filepath.Ext(job.OutputPath) != ".m4a" {
+				t.Errorf("[%s] output = %q", job.Target, job.OutputPath)
+			}
+		}
+	}
+}
+
+func TestBuildOutputArgsSharesInput(t *testing.T) {
+	metadata := &Metadata{}
+	ipod := buildOutputArgs("/out/ipod/a.m4a", Config{Codec: "aac", IPod: true}, metadata)
+	archive := buildOutputArgs("/out/archive/a.m4a", Config{Codec: "alac"}, metadata)
+
+	if ipod[0] != "-map" || archive[0] != "-map" {
+		t.Errorf("output args should start with -map: %v / %v", ipod, archive)
+	}
+	if ipod[len(ipod)-1] != "/out/ipod/a.m4a" || archive[len(archive)-1] != "/out/archive/a.m4a" {
+		t.Errorf("output args should end with the output path: %v / %v", ipod, archive)
+	}
+
+	full := buildFFmpegArgs("/in/a.flac", "/out/archive/a.m4a", Config{Codec: "alac"}, metadata)
+	if len(full) != len(archive)+2 || full[0] != "-i" || full[1] != "/in/a.flac" {
+		t.Errorf("buildFFmpegArgs = %v", full)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var templatePlaceholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(?::(\d+))?\}`)
+
+// templateTagFallbacks lists the tags consulted, in order, for a placeholder.
+var templateTagFallbacks = map[string][]string{
+	"albumartist": {"album_artist", "albumartist", "album artist", "artist"},
+	"artist":      {"artist", "album_artist", "albumartist"},
+	"album":       {"album"},
+	"title":       {"title"},
+	"genre":       {"genre"},
+	"year":        {"date", "year", "originaldate"},
+	"date":        {"date", "year"},
+	"track":       {"track", "tracknumber"},
+	"disc":        {"disc", "discnumber"},
+}
+
+var templateDefaults = map[string]string{
+	"albumartist": "Unknown Artist",
+	"artist":      "Unknown Artist",
+	"album":       "Unknown Album",
+	"genre":       "Unknown Genre",
+	"year":        "0000",
+	"date":        "0000",
+	"track":       "0",
+	"disc":        "1",
+}
+
+func validateOutputTemplate(tmpl string) error {
+	stripped := templatePlaceholderPattern.ReplaceAllString(tmpl, "")
+	if strings.ContainsAny(stripped, "{}") {
+		return fmt.Errorf("invalid output template %q: unmatched or malformed placeholder", tmpl)
+	}
+	return nil
+}
+
+// renderOutputTemplate renders a relative output path (without extension)
+// from the template, the source tags, and the source's relative path.
+func renderOutputTemplate(tmpl string, metadata *Metadata, relPath string) string {
+	stem := strings.TrimSuffix(filepath.Base(relPath), filepath.Ext(relPath))
+	dir := filepath.ToSlash(filepath.Dir(relPath))
+	if dir == "." {
+		dir = ""
+	}
+
+	rendered := templatePlaceholderPattern.ReplaceAllStringFunc(tmpl, func(match string) string {
+		parts := templatePlaceholderPattern.FindStringSubmatch(match)
+		name := strings.ToLower(parts[1])
+
+		var value string
+		switch name {
+		case "filename":
+			value = stem
+		case "dir":
+			return dir
+		default:
+			value = templateValue(name, metadata)
+			if value == "" && name == "title" {
+				value = stem
+			}
+		}
+
+		if parts[2] != "" {
+			width, _ := strconv.Atoi(parts[2])
+			if n, err := strconv.Atoi(value); err == nil {
+				value = fmt.Sprintf("%0*d", width, n)
+			}
+		}
+
+		return templatePathComponent(value)
+	})
+
+	var components []string
+	for _, component := range strings.Split(filepath.ToSlash(rendered), "/") {
+		component = strings.TrimSpace(component)
+		if component == "" || component == "." || component == ".." {
+			continue
+		}
+		components = append(components, component)
+	}
+	if len(components) == 0 {
+		components = []string{templatePathComponent(stem)}
+	}
+
+	return filepath.Join(components...)
+}
+
+func templateValue(name string, metadata *Metadata) string {
+	keys, ok := templateTagFallbacks[name]
+	if !ok {
+		keys = []string{name}
+	}
+
+	var value string
+	for _, key := range keys {
+		if value = strings.TrimSpace(metadata.Tag(key)); value != "" {
+			break
+		}
+	}
+
+	switch name {
+	case "year":
+		if len(value) >= 4 {
+			value = value[:4]
+		}
+	case "track", "disc":
+		value = strings.TrimSpace(strings.SplitN(value, "/", 2)[0])
+		if _, err := strconv.Atoi(value); err != nil {
+			value = ""
+		}
+	}
+
+	if value == "" {
+		value = templateDefaults[name]
+	}
+	return value
+}
+
+// templatePathComponent keeps tag values from introducing extra directories.
+func templatePathComponent(value string) string {
+	value = strings.NewReplacer("/", "-", "\\", "-").Replace(value)
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderOutputTemplate(t *testing.T) {
+	tagged := &Metadata{}
+	tagged.SetTag("ALBUM_ARTIST", "Various/Artists")
+	tagged.SetTag("artist", "Someone")
+	tagged.SetTag("album", "Greatest Hits")
+	tagged.SetTag("date", "1999-04-01")
+	tagged.SetTag("track", "3/12")
+	tagged.SetTag("disc", "2/2")
+	tagged.SetTag("title", "Song: Part 1")
+
+	tests := []struct {
+		name     string
+		tmpl     string
+		metadata *Metadata
+		relPath  string
+		expected string
+	}{
+		{
+			name:     "all tags present",
+			tmpl:     "{albumartist}/{year} - {album}/{disc}-{track:02} {title}",
+			metadata: tagged,
+			relPath:  "messy/folder/01.flac",
+			expected: filepath.Join("Various-Artists", "1999 - Greatest Hits", "2-03 Song: Part 1"),
+		},
+		{
+			name:     "fallbacks for missing tags",
+			tmpl:     "{albumartist}/{album}/{disc}-{track:02} {title}",
+			metadata: &Metadata{},
+			relPath:  "messy/folder/01 intro.flac",
+			expected: filepath.Join("Unknown Artist", "Unknown Album", "1-00 01 intro"),
+		},
+		{
+			name:     "source dir and filename",
+			tmpl:     "{dir}/{filename}",
+			metadata: &Metadata{},
+			relPath:  "a/b/song.mp3",
+			expected: filepath.Join("a", "b", "song"),
+		},
+		{
+			name:     "arbitrary tag",
+			tmpl:     "{composer}/{title}",
+			metadata: tagged,
+			relPath:  "song.mp3",
+			expected: filepath.Join("Song: Part 1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderOutputTemplate(tt.tmpl, tt.metadata, tt.relPath)
+			if got != tt.expected {
+				t.Errorf("renderOutputTemplate(%q) = %q, want %q", tt.tmpl, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateOutputTemplate(t *testing.T) {
+	for _, tmpl := range []string{"", "{artist}/{title}", "{disc}-{track:02} {title}"} {
+		if err := validateOutputTemplate(tmpl); err != nil {
+			t.Errorf("validateOutputTemplate(%q) returned %v", tmpl, err)
+		}
+	}
+	for _, tmpl := range []string{"{artist/{title}", "{track:xx}", "}"} {
+		if err := validateOutputTemplate(tmpl); err == nil {
+			t.Errorf("validateOutputTemplate(%q) returned nil", tmpl)
+		}
+	}
+}
+
+func TestBuildOutputPath(t *testing.T) {
+	config := Config{OutputDir: "/out", Codec: "aac"}
+	if got := buildOutputPath(config, filepath.Join("a", "song.flac"), nil); got != filepath.Join("/out", "a", "song.m4a") {
+		t.Errorf("mirrored output path = %q", got)
+	}
+
+	metadata := &Metadata{}
+	metadata.SetTag("artist", "Artist")
+	metadata.SetTag("title", "Title")
+	config.OutputTemplate = "{artist}/{title}"
+	if got := buildOutputPath(config, filepath.Join("a", "song.flac"), metadata); got != filepath.Join("/out", "Artist", "Title.m4a") {
+		t.Errorf("templated output path = %q", got)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A conversion may run for defaultJobTimeout plus defaultJobTimeoutScale times
+// the source duration before it is stopped.
+const (
+	defaultJobTimeout      = 5 * time.Minute
+	defaultJobTimeoutScale = 2.0
+)
+
+// errConversionTimeout marks a conversion that was stopped for running past
+// its timeout. It is reported as the "timeout" failure class.
+var errConversionTimeout = errors.New("timed out")
+
+// parseJobTimeout reads the job_timeout setting. Empty means the default and
+// 0 or "off" turns timeouts off.
+func parseJobTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "":
+		return defaultJobTimeout, nil
+	case "off":
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid job timeout %q: use seconds, a duration like 10m, or off", value)
+	}
+	return d, nil
+}
+
+// jobTimeout is how long the conversion of a source may run, or 0 for no
+// limit. Sources of unknown duration get the base timeout.
+func jobTimeout(config Config, metadata *Metadata) time.Duration {
+	base, _ := parseJobTimeout(config.JobTimeout)
+	if base == 0 {
+		return 0
+	}
+	scale := defaultJobTimeoutScale
+	if config.JobTimeoutScale != nil {
+		scale = *config.JobTimeoutScale
+	}
+	return base + time.Duration(scale*float64(metadata.Duration()))
+}
+
+// probeTimeout is how long probing a source may take: the base timeout, as
+// for a source of unknown duration.
+func probeTimeout(config Config) time.Duration {
+	return jobTimeout(config, nil)
+}
+
+// batchTimeout is the longest timeout of the batch's targets, which share
+// one ffmpeg run. A target without a timeout leaves the run unbounded.
+func batchTimeout(jobs []conversionJob, metadata *Metadata) time.Duration {
+	var longest time.Duration
+	for _, job := range jobs {
+		timeout := jobTimeout(job.Config, metadata)
+		if timeout == 0 {
+			return 0
+		}
+		if timeout > longest {
+			longest = timeout
+		}
+	}
+	return longest
+}
+
+// jobContext bounds one conversion by timeout, or not at all when it is zero.
+func jobContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// parseMemoryLimit reads a byte count with an optional K, M, G or T suffix
+// in powers of 1024, such as 512M or 2G. Empty means no limit.
+func parseMemoryLimit(value string) (int64, error) {
+	upper := strings.ToUpper(strings.TrimSpace(value))
+	if upper == "" {
+		return 0, nil
+	}
+	number := strings.TrimSuffix(strings.TrimSuffix(upper, "B"), "I")
+	shift := 0
+	if n := len(number); n > 0 {
+		if i := strings.IndexByte("KMGT", number[n-1]); i >= 0 {
+			shift = 10 * (i + 1)
+			number = number[:n-1]
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid memory limit %q: use bytes or a size like 512M or 2G", value)
+	}
+	return int64(n * float64(int64(1)<<shift)), nil
+}
+
+// validateJobLimits checks the timeout and resource limit settings.
+func validateJobLimits(config Config) error {
+	if _, err := parseJobTimeout(config.JobTimeout); err != nil {
+		return err
+	}
+	if config.JobTimeoutScale != nil && *config.JobTimeoutScale < 0 {
+		return fmt.Errorf("job_timeout_scale must be 0 or more, got %g", *config.JobTimeoutScale)
+	}
+	_, err := linkedFFmpegLimitsFor(config)
+	return err
+}
+
+// linkedFFmpegLimitsFor reads the resource limits for bridge workers.
+func linkedFFmpegLimitsFor(config Config) (LinkedFFmpegLimits, error) {
+	var limits LinkedFFmpegLimits
+	if value := strings.TrimSpace(config.CPULimit); value != "" {
+		cpu, err := parseMinDuration(value)
+		if err != nil || cpu <= 0 {
+			return limits, fmt.Errorf("invalid CPU limit %q: use seconds or a duration like 10m", value)
+		}
+		limits.CPU = cpu
+	}
+	memory, err := parseMemoryLimit(config.MemoryLimit)
+	if err != nil {
+		return limits, err
+	}
+	limits.Memory = memory
+	return limits, nil
+}
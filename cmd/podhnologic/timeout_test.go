@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseJobTimeout(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultJobTimeout},
+		{"off", 0},
+		{"0", 0},
+		{"90", 90 * time.Second},
+		{"10m", 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := parseJobTimeout(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseJobTimeout(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"soon", "-5m"} {
+		if _, err := parseJobTimeout(value); err == nil {
+			t.Errorf("parseJobTimeout(%q) accepted an invalid timeout", value)
+		}
+	}
+}
+
+func TestJobTimeoutScalesWithDuration(t *testing.T) {
+	metadata := &Metadata{Streams: []MetadataStream{{CodecType: "audio", Duration: "600.000000"}}}
+
+	if got := jobTimeout(Config{}, metadata); got != defaultJobTimeout+20*time.Minute {
+		t.Errorf("default timeout for a 10 minute source = %v", got)
+	}
+	scale := 0.5
+	if got := jobTimeout(Config{JobTimeout: "1m", JobTimeoutScale: &scale}, metadata); got != 6*time.Minute {
+		t.Errorf("1m plus half the source = %v, want 6m", got)
+	}
+	if got := jobTimeout(Config{}, &Metadata{}); got != defaultJobTimeout {
+		t.Errorf("timeout for an unknown duration = %v, want the base timeout", got)
+	}
+	if got := jobTimeout(Config{JobTimeout: "off"}, metadata); got != 0 {
+		t.Errorf("timeout when off = %v, want 0", got)
+	}
+}
+
+func TestBatchTimeoutTakesTheLongest(t *testing.T) {
+	short := conversionJob{Config: Config{JobTimeout: "1m", JobTimeoutScale: new(float64)}}
+	long := conversionJob{Config: Config{JobTimeout: "10m", JobTimeoutScale: new(float64)}}
+	off := conversionJob{Config: Config{JobTimeout: "off"}}
+
+	if got := batchTimeout([]conversionJob{short, long}, &Metadata{}); got != 10*time.Minute {
+		t.Errorf("batch timeout = %v, want 10m", got)
+	}
+	if got := batchTimeout([]conversionJob{short, off}, &Metadata{}); got != 0 {
+		t.Errorf("batch timeout with an unbounded target = %v, want 0", got)
+	}
+}
+
+func TestParseMemoryLimit(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"", 0},
+		{"1048576", 1 << 20},
+		{"512M", 512 << 20},
+		{"2G", 2 << 30},
+		{"1.5GiB", 3 << 29},
+		{"64k", 64 << 10},
+	}
+	for _, tt := range tests {
+		got, err := parseMemoryLimit(tt.value)
+		if err != nil || got != tt.want {
+			t.Errorf("parseMemoryLimit(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+	for _, value := range []string{"lots", "0", "-1G"} {
+		if _, err := parseMemoryLimit(value); err == nil || !strings.Contains(err.Error(), strconv.Quote(value)) {
+			t.Errorf("parseMemoryLimit(%q) error = %v, want one quoting the input", value, err)
+		}
+	}
+}
+
+func TestValidateSettingsChecksJobLimits(t *testing.T) {
+	scale := -1.0
+	for _, config := range []Config{
+		{JobTimeout: "soon"},
+		{JobTimeoutScale: &scale},
+		{CPULimit: "forever"},
+		{MemoryLimit: "lots"},
+	} {
+		if err := validateSettings(config); err == nil {
+			t.Errorf("validateSettings(%+v) accepted an invalid limit", config)
+		}
+	}
+}
+
+func TestProbeMetadataStopsHungProbes(t *testing.T) {
+	backend := newFakeBackend()
+	backend.ProbeDelay = time.Minute
+	scale := 0.0
+	config := Config{JobTimeout: "100ms", JobTimeoutScale: &scale}
+
+	start := time.Now()
+	_, err := probeMetadata(backend, "/music/hung.flac", probeTimeout(config))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("probeMetadata error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("hung probe took %v to stop", elapsed)
+	}
+}
+
+func TestRunConversionStopsHungConversions(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+	hung := helper.WriteInputFile("hung.flac", []byte("audio"))
+	scale := 0.0
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "mp3", JobTimeout: "100ms", JobTimeoutScale: &scale}
+
+	backend := newFakeBackend()
+	backend.Delay = time.Minute
+	start := time.Now()
+	err := runConversion(backend, config, false)
+	if !errors.Is(err, errConversionTimeout) {
+		t.Fatalf("runConversion error = %v, want errConversionTimeout", err)
+	}
+	if !strings.Contains(err.Error(), hung) || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("error does not name the file and timeout:\n%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("hung conversion took %v to stop", elapsed)
+	}
+	if calls := len(backend.Calls(LinkedFFmpegToolFFmpeg)); calls != 1 {
+		t.Errorf("ffmpeg ran %d times, want the timed out conversion not retried", calls)
+	}
+	helper.VerifyFileNotExists(filepath.Join(helper.outputDir, "hung.mp3"))
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+var supportedCodecs = []string{"flac", "alac", "aac", "wav", "mp3", "opus"}
+
+// iPodCodecs are the codecs the iPod firmware can play.
+var iPodCodecs = []string{"aac", "alac", "mp3", "wav"}
+
+func aacEncoder() string {
+	// Use aac_at for macOS (best quality), fallback to aac for other platforms
+	if runtime.GOOS == "darwin" {
+		return "aac_at"
+	}
+	return "aac"
+}
+
+// codecEncoder names the FFmpeg encoder a codec is converted with.
+func codecEncoder(codec string) string {
+	switch codec {
+	case "aac":
+		return aacEncoder()
+	case "mp3":
+		return "libmp3lame"
+	case "opus":
+		return "libopus"
+	case "wav":
+		return "pcm_s16le"
+	default:
+		return codec
+	}
+}
+
+// configuredCodecs lists the valid codecs the targets convert to, sorted.
+func configuredCodecs(config Config) []string {
+	seen := make(map[string]bool)
+	var codecs []string
+	for _, target := range config.targetConfigs() {
+		codec := effectiveCodec(target.Config)
+		if validateCodec(codec) == nil && !seen[codec] {
+			seen[codec] = true
+			codecs = append(codecs, codec)
+		}
+	}
+	sort.Strings(codecs)
+	return codecs
+}
+
+func validateCodec(codec string) error {
+	for _, known := range supportedCodecs {
+		if codec == known {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported codec %q (want %s)", codec, strings.Join(supportedCodecs, ", "))
+}
+
+// effectiveCodec is the codec a configuration converts to, with iPod mode
+// defaulting to AAC.
+func effectiveCodec(config Config) string {
+	if config.Codec == "" && config.IPod {
+		return "aac"
+	}
+	return config.Codec
+}
+
+// Validate checks a configuration before anything is converted: required
+// settings, codec names, option combinations and the input and output
+// directories. Every problem found is returned, joined.
+func (c Config) Validate() error {
+	return c.validate(true)
+}
+
+// validate is Validate, creating a test file in each output directory only
+// when probeWrites is set. Dry runs leave the output directories alone.
+func (c Config) validate(probeWrites bool) error {
+	var errs []error
+
+	if c.InputDir == "" {
+		errs = append(errs, errors.New("input directory is not set; pass --input or run podhnologic config set input_dir DIR"))
+	} else if info, err := os.Stat(c.InputDir); err != nil {
+		errs = append(errs, fmt.Errorf("input directory does not exist: %s", c.InputDir))
+	} else if !info.IsDir() {
+		errs = append(errs, fmt.Errorf("input path is not a directory: %s", c.InputDir))
+	}
+	if !hasOutput(c) {
+		errs = append(errs, errors.New("output directory is not set; pass --output or configure targets"))
+	}
+	if !hasCodec(c) {
+		errs = append(errs, errors.New("codec is not set; pass --codec or --ipod"))
+	}
+
+	if hasOutput(c) && hasCodec(c) {
+		for _, target := range c.targetConfigs() {
+			for _, err := range validateTargetConfig(target, probeWrites) {
+				if target.Name != "" {
+					err = fmt.Errorf("target %q: %w", target.Name, err)
+				}
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err := validateSettings(c); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Warnings lists settings that are valid but have no effect together.
+func (c Config) Warnings() []string {
+	var warnings []string
+
+	anyIPod := false
+	for _, target := range c.targetConfigs() {
+		anyIPod = anyIPod || target.Config.IPod
+	}
+	if c.PlainLyrics && !anyIPod {
+		warnings = append(warnings, "--plain-lyrics only applies to iPod outputs and has no effect without --ipod")
+	}
+	if c.NoLyrics && c.LyricsImport {
+		warnings = append(warnings, "--lrc-import has no effect with --no-lyrics")
+	}
+	return warnings
+}
+
+func validateTargetConfig(target targetConfig, probeWrites bool) []error {
+	config := target.Config
+	var errs []error
+
+	codec := effectiveCodec(config)
+	if err := validateCodec(codec); err != nil {
+		errs = append(errs, err)
+	} else if config.IPod && !containsString(iPodCodecs, codec) {
+		errs = append(errs, fmt.Errorf("iPods cannot play %s; use %s with --ipod", codec, strings.Join(iPodCodecs, ", ")))
+	}
+
+	if config.OutputDir == "" {
+		return errs
+	}
+	if config.InputDir != "" && outputOverlap(config.InputDir, config.OutputDir) == overlapSame {
+		errs = append(errs, fmt.Errorf("output directory is the input directory: %s; choose a directory outside the library", config.OutputDir))
+	}
+	if err := checkWritable(config.OutputDir, probeWrites); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// checkWritable reports whether files can be created in dir, or in its
+// nearest existing parent when dir has not been created yet. Without
+// probeWrites it only checks that the directory can be reached.
+func checkWritable(dir string, probeWrites bool) error {
+	existing := existingAncestor(dir)
+	info, err := os.Stat(existing)
+	if err != nil {
+		return fmt.Errorf("output directory %s is not reachable: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("output path %s is not a directory", existing)
+	}
+	if !probeWrites {
+		return nil
+	}
+
+	probe, err := os.CreateTemp(existing, ".podhnologic-write-test-*")
+	if err != nil {
+		return fmt.Errorf("output directory %s is not writable; check permissions or that the device is mounted read-write", existing)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+func existingAncestor(dir string) string {
+	dir = filepath.Clean(dir)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigValidate(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	valid := Config{InputDir: helper.inputDir, OutputDir: filepath.Join(helper.outputDir, "new", "dir"), IPod: true}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("Validate rejected a valid config: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"unknown codec", func(c *Config) { c.Codec = "foo" }, `unsupported codec "foo"`},
+		{"ipod flac", func(c *Config) { c.Codec = "flac" }, "iPods cannot play flac"},
+		{"missing input", func(c *Config) { c.InputDir = filepath.Join(helper.inputDir, "missing") }, "input directory does not exist"},
+		{"same directories", func(c *Config) { c.OutputDir = helper.inputDir }, "output directory is the input directory"},
+		{"target codec", func(c *Config) {
+			c.Targets = []Target{{Name: "car", OutputDir: helper.outputDir, Codec: "ogg"}}
+		}, `target "car": unsupported codec "ogg"`},
+		{"bad template", func(c *Config) { c.OutputTemplate = "{album" }, "template"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := valid
+			tt.modify(&config)
+			err := config.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigWarnings(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "flac", PlainLyrics: true, NoLyrics: true, LyricsImport: true}
+	if err := config.validate(false); err != nil {
+		t.Fatalf("validate rejected settings that only have no effect: %v", err)
+	}
+
+	warnings := strings.Join(config.Warnings(), "\n")
+	for _, want := range []string{"--plain-lyrics only applies", "--lrc-import has no effect"} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("warnings = %q, want %q", warnings, want)
+		}
+	}
+	if warnings := (Config{IPod: true, PlainLyrics: true}).Warnings(); len(warnings) != 0 {
+		t.Errorf("warnings = %q, want none", warnings)
+	}
+}
+
+func TestConfigValidateDryRunDoesNotWrite(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	// Creating and removing a probe file would move the directory's mtime
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(helper.outputDir, old, old); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	config := Config{InputDir: helper.inputDir, OutputDir: helper.outputDir, Codec: "aac"}
+	if err := config.validate(false); err != nil {
+		t.Fatalf("validate error = %v", err)
+	}
+	info, err := os.Stat(helper.outputDir)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("dry-run validation wrote to the output directory")
+	}
+}
+
+func TestCheckWritable(t *testing.T) {
+	helper := NewTestHelper(t)
+	helper.Setup()
+
+	if err := checkWritable(filepath.Join(helper.outputDir, "not", "created"), true); err != nil {
+		t.Errorf("checkWritable rejected a creatable directory: %v", err)
+	}
+	file := helper.WriteInputFile("song.flac", []byte("audio"))
+	if err := checkWritable(filepath.Join(file, "sub"), false); err == nil {
+		t.Error("checkWritable accepted a path below a file")
+	}
+}
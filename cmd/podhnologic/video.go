@@ -0,0 +1,51 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var videoExtensions = []string{".avi", ".m4v", ".mkv", ".mov", ".mp4", ".ts", ".webm"}
+
+// videoCoverFilter picks a representative frame and scales it to a size
+// players handle well as cover art.
+const videoCoverFilter = "thumbnail,scale=600:-2"
+
+func isVideoFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, videoExt := range videoExtensions {
+		if ext == videoExt {
+			return true
+		}
+	}
+	return false
+}
+
+// keepsCoverArt reports whether a codec's output carries embedded artwork.
+func keepsCoverArt(codec string) bool {
+	switch codec {
+	case "aac", "alac", "flac", "mp3":
+		return true
+	default:
+		return false
+	}
+}
+
+// videoCoverArgs replaces stream-copied video with a single encoded thumbnail.
+func videoCoverArgs(params []string) []string {
+	var out []string
+	for i := 0; i < len(params); i++ {
+		if params[i] == "-c:v" && i+1 < len(params) && params[i+1] == "copy" {
+			out = append(out,
+				"-filter:v", videoCoverFilter,
+				"-frames:v", "1",
+				"-c:v", "mjpeg",
+				"-disposition:v", "attached_pic",
+			)
+			i++
+			continue
+		}
+		out = append(out, params[i])
+	}
+	return out
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsVideoFile(t *testing.T) {
+	for path, want := range map[string]bool{
+		"concert.mkv":  true,
+		"Live.MP4":     true,
+		"clip.webm":    true,
+		"song.flac":    false,
+		"notes.txt":    false,
+		"no-extension": false,
+	} {
+		if got := isVideoFile(path); got != want {
+			t.Errorf("isVideoFile(%q) = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestBuildOutputArgsVideoInput(t *testing.T) {
+	metadata := &Metadata{Streams: []MetadataStream{
+		{Index: 0, CodecType: "video", CodecName: "h264"},
+		{Index: 1, CodecType: "audio", CodecName: "aac", Channels: 2, Tags: map[string]string{"language": "eng"}},
+		{Index: 2, CodecType: "audio", CodecName: "ac3", Channels: 6, Tags: map[string]string{"language": "eng"}},
+	}}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{
+			name:   "aac keeps a thumbnail",
+			config: Config{Codec: "aac", VideoInput: true},
+			want: []string{
+				"-map", "0:2", "-map", "0:0", "-map_metadata", "-1",
+				"-c:a", aacEncoder(), "-b:a", "256k",
+				"-filter:v", videoCoverFilter, "-frames:v", "1", "-c:v", "mjpeg", "-disposition:v", "attached_pic",
+				"/out.m4a",
+			},
+		},
+		{
+			name:   "opus drops video",
+			config: Config{Codec: "opus", VideoInput: true},
+			want: []string{
+				"-map", "0:2", "-map_metadata", "-1",
+				"-c:a", "libopus", "-b:a", "128k", "-vn",
+				"/out.m4a",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildOutputArgs("/out.m4a", tt.config, metadata)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildOutputArgs =\n%v\nwant\n%v", got, tt.want)
+			}
+		})
+	}
+}
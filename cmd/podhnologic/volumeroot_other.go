@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// volumeRelativeDir returns dir's path below its volume name, such as a drive
+// letter on Windows.
+func volumeRelativeDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	rel := strings.TrimLeft(abs[len(filepath.VolumeName(abs)):], `\/`)
+	return filepath.ToSlash(rel)
+}
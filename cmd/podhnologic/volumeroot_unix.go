@@ -0,0 +1,44 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+)
+
+// volumeRelativeDir returns dir's path below the mount point of the volume
+// holding it. A directory that does not exist yet is placed on the volume of
+// its nearest existing parent.
+func volumeRelativeDir(dir string) string {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return filepath.ToSlash(dir)
+	}
+
+	existing := abs
+	var stat syscall.Stat_t
+	for syscall.Stat(existing, &stat) != nil {
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return filepath.ToSlash(abs[1:])
+		}
+		existing = parent
+	}
+
+	mount := existing
+	for {
+		parent := filepath.Dir(mount)
+		var parentStat syscall.Stat_t
+		if parent == mount || syscall.Stat(parent, &parentStat) != nil || parentStat.Dev != stat.Dev {
+			break
+		}
+		mount = parent
+	}
+
+	rel, err := filepath.Rel(mount, abs)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
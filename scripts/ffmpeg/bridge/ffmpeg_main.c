@@ -0,0 +1,29 @@
+/*
+ * Builds fftools/ffmpeg.c as podhnologic_ffmpeg_main and reaches into its
+ * signal state, which is static, so a run in this process can be stopped the
+ * way SIGTERM stops the ffmpeg CLI.
+ */
+#define main podhnologic_ffmpeg_main
+#include "fftools/ffmpeg.c"
+#undef main
+
+/* Makes the next run start as if no signal had been received. */
+void podhnologic_ffmpeg_reset_interrupt(void)
+{
+    received_sigterm = 0;
+    received_nb_signals = 0;
+    atomic_store(&transcode_init_done, 0);
+}
+
+/*
+ * Stops the running ffmpeg as one SIGTERM would. Until transcoding starts,
+ * the interrupt callback reports the request, so I/O that polls it gives up.
+ * After that the callback ignores a single signal: the transcode loop sees
+ * received_sigterm between packets and exits with code 255, and a read that
+ * blocks without polling the callback holds it up until the read returns.
+ */
+void podhnologic_ffmpeg_interrupt(void)
+{
+    received_sigterm = SIGTERM;
+    received_nb_signals = 1;
+}
@@ -1,17 +1,195 @@
+#include <errno.h>
+#include <pthread.h>
+#include <signal.h>
+#include <stdarg.h>
+#include <stdatomic.h>
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
 #include <unistd.h>
 
+#include <libavutil/log.h>
+
 extern int podhnologic_ffmpeg_main(int argc, char **argv);
 extern int podhnologic_ffprobe_main(int argc, char **argv);
+extern void podhnologic_ffmpeg_reset_interrupt(void);
+extern void podhnologic_ffmpeg_interrupt(void);
+
+/*
+ * glibc and macOS let the C stdout and stderr streams be replaced for the
+ * length of a run, which leaves descriptors 1 and 2 alone. Elsewhere, such as
+ * on musl and MinGW, stdout can only be moved with dup2, which redirects the
+ * whole process; runs refuse to do that unless a bridge worker allowed it.
+ */
+#if defined(__GLIBC__) || defined(__APPLE__)
+#define PODHNOLOGIC_SWAP_STDIO 1
+#else
+#define PODHNOLOGIC_SWAP_STDIO 0
+#endif
+
+/*
+ * ffmpeg installs its own handlers for these signals when it starts. They
+ * would outlive the run and take the signals from Go, such as SIGXCPU from
+ * a worker's CPU limit, so every run puts the previous handlers back.
+ */
+#ifndef _WIN32
+static const int run_signals[] = {
+    SIGINT,
+    SIGTERM,
+    SIGPIPE,
+#ifdef SIGXCPU
+    SIGXCPU,
+#endif
+};
+#define RUN_SIGNAL_COUNT (sizeof(run_signals) / sizeof(run_signals[0]))
+#endif
+
+/* Set by podhnologic_linked_ffmpeg_interrupt until the next reset. */
+static atomic_int interrupt_requested;
+
+/* Set by podhnologic_linked_ffmpeg_allow_stdout_redirect. */
+static atomic_int stdout_redirect_allowed;
+
+/*
+ * The stderr of the run in progress, or -1 between runs. Every thread but a
+ * probing one logs there, since ffmpeg logs from threads of its own; probes
+ * mark their thread with podhnologic_linked_ffmpeg_quiet_thread instead.
+ */
+static pthread_mutex_t log_lock = PTHREAD_MUTEX_INITIALIZER;
+static int log_fd = -1;
+static int log_print_prefix = 1;
+
+/* Set while the thread probes a file, whose log lines are dropped. */
+static _Thread_local int log_quiet;
+
+/* Clears an earlier interrupt. Call it before starting a run. */
+void podhnologic_linked_ffmpeg_reset_interrupt(void)
+{
+    atomic_store(&interrupt_requested, 0);
+    podhnologic_ffmpeg_reset_interrupt();
+}
+
+/*
+ * Asks the running tool to stop. ffmpeg sees the request in its interrupt
+ * callback before transcoding starts and in its transcode loop after;
+ * ffprobe only sees it before it starts.
+ */
+void podhnologic_linked_ffmpeg_interrupt(void)
+{
+    if (atomic_exchange(&interrupt_requested, 1))
+        return;
+    podhnologic_ffmpeg_interrupt();
+}
+
+/* Reports whether runs leave the process's descriptors 1 and 2 alone. */
+int podhnologic_linked_ffmpeg_swaps_stdio(void)
+{
+    return PODHNOLOGIC_SWAP_STDIO;
+}
+
+/*
+ * Lets runs point descriptor 1 at the tool's stdout where the C streams
+ * cannot be swapped. Only a bridge worker, which keeps nothing else on
+ * descriptor 1 and runs one tool at a time, may call it.
+ */
+void podhnologic_linked_ffmpeg_allow_stdout_redirect(void)
+{
+    atomic_store(&stdout_redirect_allowed, 1);
+}
+
+static void write_all(int fd, const char *buf, size_t len)
+{
+    while (len > 0) {
+        ssize_t n = write(fd, buf, len);
+        if (n < 0) {
+            if (errno == EINTR)
+                continue;
+            return;
+        }
+        buf += n;
+        len -= (size_t)n;
+    }
+}
+
+/*
+ * Sends FFmpeg's log lines to the stderr of the run in progress. Lines logged
+ * between runs, such as by the in-process prober, go to the default callback.
+ */
+static void bridge_log_callback(void *avcl, int level, const char *fmt, va_list vl)
+{
+    char line[4096];
+    int len;
 
+    if (log_quiet || level > av_log_get_level())
+        return;
+
+    pthread_mutex_lock(&log_lock);
+    if (log_fd < 0) {
+        pthread_mutex_unlock(&log_lock);
+        av_log_default_callback(avcl, level, fmt, vl);
+        return;
+    }
+    len = av_log_format_line2(avcl, level, fmt, vl, line, sizeof(line), &log_print_prefix);
+    if (len > 0) {
+        if ((size_t)len >= sizeof(line))
+            len = sizeof(line) - 1;
+        write_all(log_fd, line, (size_t)len);
+    }
+    pthread_mutex_unlock(&log_lock);
+}
+
+static void set_log_fd(int fd)
+{
+    pthread_mutex_lock(&log_lock);
+    log_fd = fd;
+    log_print_prefix = 1;
+    pthread_mutex_unlock(&log_lock);
+}
+
+/*
+ * Drops the log lines of libav calls made on this thread until it is called
+ * again with 0. The in-process prober uses it, as ffprobe ran with -v quiet,
+ * so its warnings reach neither the terminal nor a run's stderr.
+ */
+void podhnologic_linked_ffmpeg_quiet_thread(int quiet)
+{
+    av_log_set_callback(bridge_log_callback);
+    log_quiet = quiet;
+}
+
+/* Opens a stream on a duplicate of fd, so closing it leaves fd open. */
+static FILE *open_run_stream(int fd)
+{
+    FILE *f;
+    int dup_fd = dup(fd);
+
+    if (dup_fd < 0)
+        return NULL;
+    if (!(f = fdopen(dup_fd, "w")))
+        close(dup_fd);
+    return f;
+}
+
+/*
+ * Runs ffmpeg or ffprobe with their output on stdout_fd and stderr_fd. The
+ * tools keep global state, so the caller runs one at a time. Standard input
+ * is never read: the caller names its input as pipe:<fd> in argv instead.
+ */
 int podhnologic_linked_ffmpeg_main(const char *tool, int argc, const char **argv, int stdout_fd, int stderr_fd)
 {
-    int saved_stdout = -1;
-    int saved_stderr = -1;
     int exit_code = 1;
     char **tool_argv = NULL;
+#if PODHNOLOGIC_SWAP_STDIO
+    FILE *saved_stdout = stdout;
+    FILE *saved_stderr = stderr;
+    FILE *run_stdout = NULL;
+    FILE *run_stderr = NULL;
+#else
+    int saved_stdout = -1;
+#endif
+#ifndef _WIN32
+    struct sigaction saved_actions[RUN_SIGNAL_COUNT];
+#endif
 
     if (!tool)
         return 1;
@@ -24,17 +202,36 @@ int podhnologic_linked_ffmpeg_main(const char *tool, int argc, const char **argv
     for (int i = 0; i < argc; i++)
         tool_argv[i + 1] = (char *)argv[i];
 
-    saved_stdout = dup(STDOUT_FILENO);
-    saved_stderr = dup(STDERR_FILENO);
-    if (saved_stdout < 0 || saved_stderr < 0)
-        goto finish;
+#ifndef _WIN32
+    for (size_t i = 0; i < RUN_SIGNAL_COUNT; i++)
+        sigaction(run_signals[i], NULL, &saved_actions[i]);
+#endif
 
-    if (dup2(stdout_fd, STDOUT_FILENO) < 0)
+#if PODHNOLOGIC_SWAP_STDIO
+    if (!(run_stdout = open_run_stream(stdout_fd)) || !(run_stderr = open_run_stream(stderr_fd)))
         goto finish;
-    if (dup2(stderr_fd, STDERR_FILENO) < 0)
+    stdout = run_stdout;
+    stderr = run_stderr;
+#else
+    if (!atomic_load(&stdout_redirect_allowed)) {
+        static const char msg[] = "linked ffmpeg cannot run in this process on this platform without redirecting its stdout; use hidden mode\n";
+        write_all(stderr_fd, msg, sizeof(msg) - 1);
         goto finish;
+    }
+    fflush(stdout);
+    saved_stdout = dup(STDOUT_FILENO);
+    if (saved_stdout < 0 || dup2(stdout_fd, STDOUT_FILENO) < 0)
+        goto finish;
+#endif
+
+    /* A run may have changed these; every run starts from the defaults */
+    av_log_set_level(AV_LOG_INFO);
+    av_log_set_callback(bridge_log_callback);
+    set_log_fd(stderr_fd);
 
-    if (strcmp(tool, "ffmpeg") == 0) {
+    if (atomic_load(&interrupt_requested)) {
+        exit_code = 255;
+    } else if (strcmp(tool, "ffmpeg") == 0) {
         exit_code = podhnologic_ffmpeg_main(argc + 1, tool_argv);
     } else if (strcmp(tool, "ffprobe") == 0) {
         exit_code = podhnologic_ffprobe_main(argc + 1, tool_argv);
@@ -42,16 +239,27 @@ int podhnologic_linked_ffmpeg_main(const char *tool, int argc, const char **argv
 
     fflush(stdout);
     fflush(stderr);
+    set_log_fd(-1);
+    av_log_set_callback(bridge_log_callback);
 
 finish:
+#ifndef _WIN32
+    for (size_t i = 0; i < RUN_SIGNAL_COUNT; i++)
+        sigaction(run_signals[i], &saved_actions[i], NULL);
+#endif
+#if PODHNOLOGIC_SWAP_STDIO
+    stdout = saved_stdout;
+    stderr = saved_stderr;
+    if (run_stdout)
+        fclose(run_stdout);
+    if (run_stderr)
+        fclose(run_stderr);
+#else
     if (saved_stdout >= 0) {
         dup2(saved_stdout, STDOUT_FILENO);
         close(saved_stdout);
     }
-    if (saved_stderr >= 0) {
-        dup2(saved_stderr, STDERR_FILENO);
-        close(saved_stderr);
-    }
+#endif
     free(tool_argv);
     return exit_code;
 }
@@ -440,11 +440,16 @@ build_ffmpeg() {
 	make distclean >/dev/null 2>&1 || true
 
 	local audio_decoders audio_demuxers audio_encoders audio_parsers cover_art_decoders pcm_adpcm_decoders
+	local video_decoders video_demuxers video_parsers
 	audio_demuxers="aa,aac,aax,ac3,aiff,ape,asf,au,caf,dsf,dts,eac3,flac,hca,matroska,mov,mp3,mpc,mpc8,ogg,oma,shorten,tak,tta,voc,w64,wav,wv,xwma"
 	audio_decoders="aac,aac_latm,ac3,alac,ape,atrac1,atrac3,atrac3al,atrac3p,atrac3pal,atrac9,cook,dca,dsd_lsbf,dsd_lsbf_planar,dsd_msbf,dsd_msbf_planar,eac3,flac,hca,mace3,mace6,metasound,mp1,mp1float,mp2,mp2float,mp3,mp3adu,mp3adufloat,mp3float,mp3on4,mp3on4float,mpc7,mpc8,opus,qdm2,qdmc,ra_144,ra_288,ralf,shorten,tak,tta,vorbis,wavpack,wmalossless,wmapro,wmav1,wmav2"
 	audio_encoders="aac,alac,flac,libmp3lame,libopus,pcm_alaw,pcm_mulaw,pcm_s16be,pcm_s16le,pcm_s24be,pcm_s24le,pcm_s32be,pcm_s32le,pcm_f32be,pcm_f32le,pcm_u8"
 	audio_parsers="aac,aac_latm,ac3,cook,dca,flac,mpegaudio,opus,tak,vorbis"
 	cover_art_decoders="bmp,mjpeg,png"
+	# Video containers are only read for their audio and a cover art thumbnail
+	video_demuxers="avi,mpegps,mpegts"
+	video_decoders="h264,hevc,mpeg2video,mpeg4,vp8,vp9"
+	video_parsers="h264,hevc,mpeg4video,mpegvideo,vp8,vp9"
 	pcm_adpcm_decoders="adpcm_4xm,adpcm_adx,adpcm_afc,adpcm_agm,adpcm_aica,adpcm_argo,adpcm_circus,adpcm_ct,adpcm_dtk,adpcm_ea,adpcm_ea_maxis_xa,adpcm_ea_r1,adpcm_ea_r2,adpcm_ea_r3,adpcm_ea_xas,adpcm_g722,adpcm_g726,adpcm_g726le,adpcm_ima_acorn,adpcm_ima_alp,adpcm_ima_amv,adpcm_ima_apc,adpcm_ima_apm,adpcm_ima_cunning,adpcm_ima_dat4,adpcm_ima_dk3,adpcm_ima_dk4,adpcm_ima_ea_eacs,adpcm_ima_ea_sead,adpcm_ima_escape,adpcm_ima_hvqm2,adpcm_ima_hvqm4,adpcm_ima_iss,adpcm_ima_magix,adpcm_ima_moflex,adpcm_ima_mtf,adpcm_ima_oki,adpcm_ima_pda,adpcm_ima_qt,adpcm_ima_qt_at,adpcm_ima_rad,adpcm_ima_smjpeg,adpcm_ima_ssi,adpcm_ima_wav,adpcm_ima_ws,adpcm_ima_xbox,adpcm_ms,adpcm_mtaf,adpcm_n64,adpcm_psx,adpcm_psxc,adpcm_sanyo,adpcm_sbpro_2,adpcm_sbpro_3,adpcm_sbpro_4,adpcm_swf,adpcm_thp,adpcm_thp_le,adpcm_vima,adpcm_xa,adpcm_xmd,adpcm_yamaha,adpcm_zork,pcm_alaw,pcm_bluray,pcm_dvd,pcm_f16le,pcm_f24le,pcm_f32be,pcm_f32le,pcm_f64be,pcm_f64le,pcm_lxf,pcm_mulaw,pcm_s16be,pcm_s16be_planar,pcm_s16le,pcm_s16le_planar,pcm_s24be,pcm_s24daud,pcm_s24le,pcm_s24le_planar,pcm_s32be,pcm_s32le,pcm_s32le_planar,pcm_s64be,pcm_s64le,pcm_s8,pcm_s8_planar,pcm_sga,pcm_u16be,pcm_u16le,pcm_u24be,pcm_u24le,pcm_u32be,pcm_u32le,pcm_u8,pcm_vidc"
 
 	if [[ "$ffmpeg_os" == "darwin" ]]; then
@@ -471,13 +476,14 @@ build_ffmpeg() {
 		--enable-swresample
 		--enable-protocol=file,pipe
 		--enable-zlib
-		--enable-demuxer="$audio_demuxers"
+		--enable-demuxer="$audio_demuxers,$video_demuxers"
 		--enable-muxer=adts,flac,ipod,mov,mp3,ogg,opus,mp4,wav
-		--enable-decoder="$audio_decoders,$cover_art_decoders,$pcm_adpcm_decoders"
-		--enable-encoder="$audio_encoders"
-		--enable-parser="$audio_parsers"
+		--enable-decoder="$audio_decoders,$cover_art_decoders,$pcm_adpcm_decoders,$video_decoders"
+		--enable-encoder="$audio_encoders,mjpeg"
+		--enable-parser="$audio_parsers,$video_parsers"
 		--enable-bsf=aac_adtstoasc
-		--enable-filter=aformat,anull,aresample,atrim,crop,format,hflip,null,rotate,transpose,trim,vflip
+		--enable-filter=aformat,anull,aresample,atrim,crop,format,hflip,null,rotate,scale,thumbnail,transpose,trim,vflip
+		--enable-swscale
 		--enable-libmp3lame
 		--enable-libopus
 	)
@@ -560,8 +566,7 @@ build_link_bridge() {
 		-Werror=implicit-function-declaration \
 		-Werror=return-type \
 		-Wno-missing-prototypes \
-		-Dmain=podhnologic_ffmpeg_main \
-		-c fftools/ffmpeg.c \
+		-c "$SCRIPT_DIR/bridge/ffmpeg_main.c" \
 		-o "$bridge_dir/ffmpeg_main.o"
 
 	"$CC" \
@@ -609,10 +614,12 @@ build_link_bridge() {
 	fi
 
 	"$CC" \
+		-I"$prefix/include" \
 		$cflags \
 		$cppflags \
 		-D_GNU_SOURCE \
 		-std=c17 \
+		-pthread \
 		-Wall \
 		-Werror=implicit-function-declaration \
 		-Werror=return-type \